@@ -80,7 +80,7 @@ echo "Creating apis        "
 echo "====================="
 echo ""
 APIDIR=../source/api
-APIS=`ls -1 $APIDIR`
+APIS=`ls -1 $APIDIR | grep "_types.go$"`
 for APISOURCE in $APIS
 do
    KIND=`cat $APIDIR/$APISOURCE | grep "^type" | tail -2 | head -1 | awk '{print $2}'`
@@ -94,11 +94,33 @@ do
 done
 echo ""
 echo "====================="
+echo "Creating webhooks    "
+echo "====================="
+echo ""
+WEBHOOKS=`ls -1 $APIDIR | grep "_webhook.go$"`
+for WEBHOOKSOURCE in $WEBHOOKS
+do
+   APISOURCE=`echo $WEBHOOKSOURCE | sed "s#_webhook.go#_types.go#"`
+   KIND=`cat $APIDIR/$APISOURCE | grep "^type" | tail -2 | head -1 | awk '{print $2}'`
+   echo "Creating validating webhook for kind $KIND (source: $WEBHOOKSOURCE)"
+   operator-sdk create webhook --group $GROUP --version $API_VERSION --kind $KIND --programmatic-validation
+   if [ $? != 0 ]
+   then
+     echo Creating webhook failed
+     exit 1
+   fi
+done
+echo ""
+echo "====================="
 echo "Adding api sources   "
 echo "====================="
 echo ""
 cp ../source/api/* api/$API_VERSION/
 ls -l api/$API_VERSION
+# CEL is used for the when clauses of steps
+go get github.com/google/cel-go@v0.17.8
+# image digests of cached steps are resolved in the registry
+go get github.com/google/go-containerregistry@v0.19.1
 echo ""
 echo "====================="
 echo "Generating manifests "
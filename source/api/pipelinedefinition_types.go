@@ -2,6 +2,8 @@ package v1
 
 import (
 	"encoding/json"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -17,6 +19,41 @@ type PipelineJobStepSpec struct {
 	Config json.RawMessage `json:"config,omitempty"`
 	// +kubebuilder:validation:Required
 	JobSpec JobSpec `json:"jobSpec"`
+	// steps that are ready at the same time are started in order of decreasing priority (default 0)
+	// +kubebuilder:validation:Optional
+	Priority *int32 `json:"priority,omitempty"`
+	// failed steps are retried with a fresh Job according to this policy (overrides the backoff limit of the job spec)
+	// +kubebuilder:validation:Optional
+	RetryPolicy *RetryPolicy `json:"retryPolicy,omitempty"`
+	// the output of the step is cached and reused by later executions with the same image, command, arguments, config,
+	// environment variables and inputs (the step must be deterministic, images should be referenced by digest, only
+	// supported for runs that store their outputs in volumes)
+	// +kubebuilder:validation:Optional
+	Cache bool `json:"cache,omitempty"`
+	// output volume and working directory of the step (unset fields are taken from the namespace defaults)
+	// +kubebuilder:validation:Optional
+	Storage *StorageSpec `json:"storage,omitempty"`
+	// CEL expression deciding whether the step is executed once its inputs are available, the step is skipped if it
+	// evaluates to false (see WhenEnv for the available variables)
+	// +kubebuilder:validation:Optional
+	When string `json:"when,omitempty"`
+}
+
+/* StorageSpec defines the output volume and the working directory of a job step */
+type StorageSpec struct {
+	// size of the output volume
+	// +kubebuilder:validation:Optional
+	Size *resource.Quantity `json:"size,omitempty"`
+	// storage class of the output volume
+	// +kubebuilder:validation:Optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+	// access mode of the output volume
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=ReadWriteOnce;ReadOnlyMany;ReadWriteMany;ReadWriteOncePod
+	AccessMode corev1.PersistentVolumeAccessMode `json:"accessMode,omitempty"`
+	// size limit of the emptyDir volume mounted as working directory
+	// +kubebuilder:validation:Optional
+	WorkdirSizeLimit *resource.Quantity `json:"workdirSizeLimit,omitempty"`
 }
 
 /* SubPipelineSpec defines details of a pipeline step that will run as a sub-pipeline */
@@ -31,8 +68,25 @@ type SubPipelineSpec struct {
 	PipelineName string `json:"pipelineName"`
 	// +kubebuilder:validation:Required
 	VersionPattern string `json:"versionPattern"`
+	// if set, one child run is started per item of the batch manifest (a JSON array of strings read from the
+	// manifest pipe), each child gets the sub-directory named by its item of all other input pipes
 	// +kubebuilder:validation:Optional
 	Batched *bool `json:"batched"`
+	// name (at the receiving end) of the input pipe that holds the batch manifest, defaults to "manifest"
+	// +kubebuilder:validation:Optional
+	ManifestPipe *string `json:"manifestPipe,omitempty"`
+	// percentage of the child runs of a batched sub-pipeline that may fail without failing the step
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	MaxFailedPercentage *int32 `json:"maxFailedPercentage,omitempty"`
+	// steps that are ready at the same time are started in order of decreasing priority (default 0)
+	// +kubebuilder:validation:Optional
+	Priority *int32 `json:"priority,omitempty"`
+	// CEL expression deciding whether the sub-pipeline is executed once its inputs are available, it is skipped if it
+	// evaluates to false (see WhenEnv for the available variables)
+	// +kubebuilder:validation:Optional
+	When string `json:"when,omitempty"`
 }
 
 /* PipelinePipe defines details of a pipe connection between two pipeline steps */
@@ -43,7 +97,7 @@ type PipelinePipe struct {
 	To PipeConnector `json:"to"`
 }
 
-/* PipelineConnector defines step and pipe name at either end of a pipe */
+/* PipelineConnector defines step and pipe name at either end of a pipe (the reserved step ids "input" and "output" refer to the inputs and outputs of the pipeline) */
 type PipeConnector struct {
 	// +kubebuilder:validation:Required
 	StepId string `json:"stepId"`
@@ -59,6 +113,34 @@ type PipelineStructure struct {
 	SubPipelines []*SubPipelineSpec `json:"subPipelines,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
 	// +kubebuilder:validation:Required
 	Pipes []*PipelinePipe `json:"pipes,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+	// maximum number of steps of a run that are active at the same time (unlimited if not set)
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	MaxParallelSteps *int32 `json:"maxParallelSteps,omitempty"`
+	// retention of the outputs of the job steps of a run
+	// +kubebuilder:validation:Optional
+	Retention *RetentionPolicy `json:"retention,omitempty"`
+}
+
+/*
+RetentionPolicy defines how long the outputs (and PipelineJobs) of the job steps of a run are kept. Outputs consumed by
+other steps are deleted as soon as all consumers have succeeded (unless keepIntermediateOutputs is set), the remaining
+outputs are deleted when the TTL of the run has expired.
+*/
+type RetentionPolicy struct {
+	// time after completion of a succeeded run until its outputs are deleted (kept forever if not set)
+	// +kubebuilder:validation:Optional
+	SucceededTTL *metav1.Duration `json:"succeededTTL,omitempty"`
+	// time after completion of a failed (or terminated) run until its outputs are deleted (kept forever if not set)
+	// +kubebuilder:validation:Optional
+	FailedTTL *metav1.Duration `json:"failedTTL,omitempty"`
+	// keep the outputs consumed by other steps until the TTL has expired
+	// +kubebuilder:validation:Optional
+	KeepIntermediateOutputs bool `json:"keepIntermediateOutputs,omitempty"`
+	// keep the outputs of steps without consumers (neither steps nor output pipes) until the TTL has expired,
+	// otherwise they are deleted once the step has succeeded (defaults to false)
+	// +kubebuilder:validation:Optional
+	KeepFinalOutputs *bool `json:"keepFinalOutputs,omitempty"`
 }
 
 /* PipelineDefinitionSpec holds the definition of the pipeline structure, the configuration of steps, and meta information */
@@ -75,6 +157,12 @@ type PipelineDefinitionSpec struct {
 	PipelineStructure PipelineStructure `json:"pipelineStructure"`
 	// +kubebuilder:validation:Optional
 	TerminationJobs []JobSpec `json:"terminationJobs,omitempty"`
+	// deprecated versions are only used by runs that pin exactly this version, retired versions are not used by new
+	// runs at all (runs in flight are not affected), this is the only field that may be changed after creation
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Active;Deprecated;Retired
+	// +kubebuilder:default=Active
+	Lifecycle string `json:"lifecycle,omitempty"`
 }
 
 // ScheduleStatus defines the observed state of Schedule
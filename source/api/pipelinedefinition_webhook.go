@@ -0,0 +1,558 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	pathpkg "path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// reserved step ids referring to the inputs and outputs of a pipeline
+	InputStepId  = "input"
+	OutputStepId = "output"
+)
+
+var (
+	// directories of the step container used by the operator (working directory, config and pipe volumes)
+	reservedMountPaths      = []string{"/workdir", "/etc/config", "/vol"}
+	reservedMountPathsMutex sync.RWMutex
+	// names (and name prefixes) of the volumes created by the operator
+	reservedVolumeNames    = []string{"config", "workdir"}
+	reservedVolumePrefixes = []string{"secret-mount-", "configmap-", "secret-"}
+	// containers created by the operator besides the step container "main"
+	reservedContainerNames = []string{"init", "download", "upload"}
+	// check of the images against the image policy of the operator (nil if not set up)
+	imageAdmission      func(context.Context, *PipelineDefinition) ([]string, error)
+	imageAdmissionMutex sync.RWMutex
+)
+
+// SetupWebhookWithManager registers the validating webhook of pipeline definitions
+func (r *PipelineDefinition) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithValidator(&PipelineDefinitionValidator{}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-pipeline-k-pipe-cloud-v1-pipelinedefinition,mutating=false,failurePolicy=fail,sideEffects=None,groups=pipeline.k-pipe.cloud,resources=pipelinedefinitions,verbs=create;update,versions=v1,name=vpipelinedefinition.kb.io,admissionReviewVersions=v1
+
+// PipelineDefinitionValidator validates pipeline definitions when they are created or updated
+// +kubebuilder:object:generate=false
+type PipelineDefinitionValidator struct{}
+
+var _ webhook.CustomValidator = &PipelineDefinitionValidator{}
+
+func asPipelineDefinition(obj runtime.Object) (*PipelineDefinition, error) {
+	pd, ok := obj.(*PipelineDefinition)
+	if !ok {
+		return nil, fmt.Errorf("expected a PipelineDefinition but got a %T", obj)
+	}
+	return pd, nil
+}
+
+// ValidateCreate implements webhook.CustomValidator
+func (v *PipelineDefinitionValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	pd, err := asPipelineDefinition(obj)
+	if err != nil {
+		return nil, err
+	}
+	if err := pd.validate(); err != nil {
+		return nil, err
+	}
+	violations, err := admitImages(ctx, pd)
+	if err != nil {
+		return nil, err
+	}
+	if len(violations) > 0 {
+		return nil, apierrors.NewInvalid(GroupVersion.WithKind("PipelineDefinition").GroupKind(), pd.Name, field.ErrorList{
+			field.Forbidden(field.NewPath("spec"), "images are not admitted by the image policy: "+strings.Join(violations, "; ")),
+		})
+	}
+	return nil, nil
+}
+
+// ValidateUpdate implements webhook.CustomValidator, the spec except for the lifecycle is immutable
+func (v *PipelineDefinitionValidator) ValidateUpdate(ctx context.Context, oldObj runtime.Object, newObj runtime.Object) (admission.Warnings, error) {
+	oldPd, err := asPipelineDefinition(oldObj)
+	if err != nil {
+		return nil, err
+	}
+	pd, err := asPipelineDefinition(newObj)
+	if err != nil {
+		return nil, err
+	}
+	oldSpec := oldPd.Spec.DeepCopy()
+	oldSpec.Lifecycle = pd.Spec.Lifecycle
+	if !equality.Semantic.DeepEqual(*oldSpec, pd.Spec) {
+		return nil, apierrors.NewInvalid(GroupVersion.WithKind("PipelineDefinition").GroupKind(), pd.Name, field.ErrorList{
+			field.Forbidden(field.NewPath("spec"), "pipeline definition "+pd.Name+" is immutable (only spec.lifecycle may be changed), publish the changes as a new version by bumping spec.version"),
+		})
+	}
+	if err := pd.validate(); err != nil {
+		return nil, err
+	}
+	// the images can not be changed anymore, violations of a changed image policy are only reported
+	violations, err := admitImages(ctx, pd)
+	if err != nil {
+		return admission.Warnings{"images could not be checked against the image policy: " + err.Error()}, nil
+	}
+	var warnings admission.Warnings
+	for _, violation := range violations {
+		warnings = append(warnings, "image policy violation: "+violation)
+	}
+	return warnings, nil
+}
+
+// ValidateDelete implements webhook.CustomValidator
+func (v *PipelineDefinitionValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (r *PipelineDefinition) validate() error {
+	errs := r.ValidateStructure()
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("PipelineDefinition").GroupKind(), r.Name, errs)
+}
+
+/*
+SetImageAdmission sets the check of the images of pipeline definitions against the image policy of the operator, it
+returns the violations of the policy. New definitions with violations are rejected, updates of existing ones only get
+warnings.
+*/
+func SetImageAdmission(check func(context.Context, *PipelineDefinition) ([]string, error)) {
+	imageAdmissionMutex.Lock()
+	defer imageAdmissionMutex.Unlock()
+	imageAdmission = check
+}
+
+func admitImages(ctx context.Context, pd *PipelineDefinition) ([]string, error) {
+	imageAdmissionMutex.RLock()
+	check := imageAdmission
+	imageAdmissionMutex.RUnlock()
+	if check == nil {
+		return nil, nil
+	}
+	return check(ctx, pd)
+}
+
+/*
+ValidateStructure checks the naming of the definition, that the steps and pipes of the pipeline structure form a
+directed acyclic graph, that the when clauses of the steps compile and that the job specs are consistent
+*/
+func (r *PipelineDefinition) ValidateStructure() field.ErrorList {
+	var errs field.ErrorList
+	if r.Name != r.Spec.Name+"-"+r.Spec.Version {
+		errs = append(errs, field.Invalid(field.NewPath("metadata", "name"), r.Name, "must be <spec.name>-<spec.version>: "+r.Spec.Name+"-"+r.Spec.Version))
+	}
+	structure := &r.Spec.PipelineStructure
+	path := field.NewPath("spec", "pipelineStructure")
+
+	// step ids must be unique and usable in names of jobs and volumes
+	steps := map[string]bool{}
+	checkId := func(id string, idPath *field.Path) {
+		if (id == InputStepId) || (id == OutputStepId) {
+			errs = append(errs, field.Invalid(idPath, id, "step id is reserved"))
+		} else if steps[id] {
+			errs = append(errs, field.Duplicate(idPath, id))
+		} else {
+			for _, msg := range validation.IsDNS1123Label(id) {
+				errs = append(errs, field.Invalid(idPath, id, msg))
+			}
+		}
+		steps[id] = true
+	}
+	for i, step := range structure.JobSteps {
+		checkId(step.Id, path.Child("jobSteps").Index(i).Child("id"))
+	}
+	for i, sp := range structure.SubPipelines {
+		checkId(sp.Id, path.Child("subPipelines").Index(i).Child("id"))
+	}
+
+	// pipes must connect known steps and every target file of a step must be written only once
+	targets := map[PipeConnector]bool{}
+	for i, pipe := range structure.Pipes {
+		pipePath := path.Child("pipes").Index(i)
+		if (pipe.From.StepId != InputStepId) && !steps[pipe.From.StepId] {
+			errs = append(errs, field.NotFound(pipePath.Child("from", "stepId"), pipe.From.StepId))
+		}
+		if (pipe.To.StepId != OutputStepId) && !steps[pipe.To.StepId] {
+			errs = append(errs, field.NotFound(pipePath.Child("to", "stepId"), pipe.To.StepId))
+		}
+		if targets[pipe.To] {
+			errs = append(errs, field.Duplicate(pipePath.Child("to", "name"), pipe.To.Name))
+		}
+		targets[pipe.To] = true
+	}
+
+	// when clauses must compile to boolean expressions and may only refer to upstream steps
+	checkWhen := func(stepId string, when string, whenPath *field.Path) {
+		if len(when) == 0 {
+			return
+		}
+		refs, err := WhenStepReferences(when)
+		if err != nil {
+			errs = append(errs, field.Invalid(whenPath, when, err.Error()))
+			return
+		}
+		upstream := upstreamSteps(structure, stepId)
+		for _, ref := range refs {
+			if !upstream[ref] {
+				errs = append(errs, field.Invalid(whenPath, when, "refers to step "+ref+" which is not upstream of step "+stepId))
+			}
+		}
+	}
+	for i, step := range structure.JobSteps {
+		checkWhen(step.Id, step.When, path.Child("jobSteps").Index(i).Child("when"))
+	}
+	for i, sp := range structure.SubPipelines {
+		checkWhen(sp.Id, sp.When, path.Child("subPipelines").Index(i).Child("when"))
+	}
+
+	// job specs must not conflict with the volumes and containers of the operator
+	for i, step := range structure.JobSteps {
+		errs = append(errs, validateJobSpec(&step.JobSpec, path.Child("jobSteps").Index(i).Child("jobSpec"))...)
+	}
+	for i := range r.Spec.TerminationJobs {
+		errs = append(errs, validateJobSpec(&r.Spec.TerminationJobs[i], field.NewPath("spec", "terminationJobs").Index(i))...)
+	}
+
+	if i, cycle := findCycle(structure); cycle != nil {
+		errs = append(errs, field.Invalid(path.Child("pipes").Index(i), pipeString(structure.Pipes[i]), "pipes form a cycle: "+strings.Join(cycle, " -> ")))
+	}
+	return errs
+}
+
+/*
+check that secret mounts do not overlap with the directories used by the operator, requests do not exceed limits and the
+specification does not conflict with the pod template of the operator
+*/
+func validateJobSpec(js *JobSpec, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	mountPaths := map[string]bool{}
+	for i, sm := range js.SecretMounts {
+		mountPath := path.Child("secretMounts").Index(i).Child("mountPath")
+		dir := pathpkg.Clean(sm.MountPath)
+		if !pathpkg.IsAbs(dir) || (dir == "/") {
+			errs = append(errs, field.Invalid(mountPath, sm.MountPath, "must be an absolute path below /"))
+			continue
+		}
+		if reserved := reservedMountPath(dir); len(reserved) > 0 {
+			errs = append(errs, field.Invalid(mountPath, sm.MountPath, "overlaps with "+reserved+" used by the operator"))
+		}
+		if mountPaths[dir] {
+			errs = append(errs, field.Duplicate(mountPath, sm.MountPath))
+		}
+		mountPaths[dir] = true
+	}
+	var names []string
+	for name := range js.Resources.Limits {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		limit := js.Resources.Limits[corev1.ResourceName(name)]
+		if request, found := js.Resources.Requests[corev1.ResourceName(name)]; found && (request.Cmp(limit) > 0) {
+			errs = append(errs, field.Invalid(path.Child("resources", "requests").Key(name), request.String(), "must not exceed the limit of "+limit.String()))
+		}
+	}
+	return append(errs, validateSpecification(js, path.Child("specification"))...)
+}
+
+/*
+SetReservedMountPaths sets the directories of the step container used by the operator, they are taken from the operator
+configuration (the defaults are /workdir, /etc/config and /vol)
+*/
+func SetReservedMountPaths(paths ...string) {
+	reservedMountPathsMutex.Lock()
+	defer reservedMountPathsMutex.Unlock()
+	reservedMountPaths = append([]string{}, paths...)
+}
+
+// the directory used by the operator that overlaps with the given directory, empty if there is none
+func reservedMountPath(dir string) string {
+	reservedMountPathsMutex.RLock()
+	defer reservedMountPathsMutex.RUnlock()
+	dir = pathpkg.Clean(dir)
+	for _, reserved := range reservedMountPaths {
+		if (dir == "/") || (dir == reserved) || strings.HasPrefix(dir, reserved+"/") || strings.HasPrefix(reserved, dir+"/") {
+			return reserved
+		}
+	}
+	return ""
+}
+
+func isReservedVolume(name string) bool {
+	for _, reserved := range reservedVolumeNames {
+		if name == reserved {
+			return true
+		}
+	}
+	for _, prefix := range reservedVolumePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+SpecificationPatch returns the specification of a job spec as strategic merge patch of the pod template of the job: a
+full PodTemplateSpec if it has the key metadata or spec, otherwise a partial PodSpec (nil if the specification is not
+set)
+*/
+func SpecificationPatch(specification json.RawMessage) ([]byte, error) {
+	if len(specification) == 0 {
+		return nil, nil
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(specification, &fields); err != nil {
+		return nil, errors.New("must be a JSON object (partial PodSpec or PodTemplateSpec)")
+	}
+	if _, found := fields["spec"]; found {
+		return specification, nil
+	}
+	if _, found := fields["metadata"]; found {
+		return specification, nil
+	}
+	return json.Marshal(map[string]json.RawMessage{"spec": specification})
+}
+
+/*
+check that the specification of a job spec does not conflict with the pod template generated by the operator: volumes
+and containers of the operator must not be overridden, mounts must not hide its directories and image, command and
+arguments of the step container are given by the job spec
+*/
+func validateSpecification(js *JobSpec, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	patch, err := SpecificationPatch(js.Specification)
+	if err != nil {
+		return append(errs, field.Invalid(path, string(js.Specification), err.Error()))
+	}
+	if patch == nil {
+		return nil
+	}
+	template := &corev1.PodTemplateSpec{}
+	if err := json.Unmarshal(patch, template); err != nil {
+		return append(errs, field.Invalid(path, string(js.Specification), "must be a partial PodSpec or PodTemplateSpec: "+err.Error()))
+	}
+	for _, volume := range template.Spec.Volumes {
+		if isReservedVolume(volume.Name) {
+			errs = append(errs, field.Forbidden(path.Child("volumes").Key(volume.Name), "conflicts with the volumes of the operator"))
+		}
+	}
+	checkContainers := func(containers []corev1.Container, containersPath *field.Path) {
+		for _, c := range containers {
+			containerPath := containersPath.Key(c.Name)
+			for _, reserved := range reservedContainerNames {
+				if c.Name == reserved {
+					errs = append(errs, field.Forbidden(containerPath, "container is managed by the operator"))
+				}
+			}
+			if (c.Name == "main") && ((len(c.Image) > 0) || (len(c.Command) > 0) || (len(c.Args) > 0)) {
+				errs = append(errs, field.Forbidden(containerPath, "image, command and args of the step container are set in the job spec"))
+			}
+			for _, vm := range c.VolumeMounts {
+				if reserved := reservedMountPath(vm.MountPath); len(reserved) > 0 {
+					errs = append(errs, field.Invalid(containerPath.Child("volumeMounts").Key(vm.Name), vm.MountPath, "overlaps with "+reserved+" used by the operator"))
+				}
+			}
+		}
+	}
+	checkContainers(template.Spec.InitContainers, path.Child("initContainers"))
+	checkContainers(template.Spec.Containers, path.Child("containers"))
+	return errs
+}
+
+/*
+WhenEnv is the CEL environment of the when clauses of steps, it declares the variables steps (map from step id to a map
+with keys state, the state of the step being one of Pending, Running, Succeeded, Failed or Skipped, executed, whether
+the step has run (i.e. has not been skipped), and outputs, the values published by the step in its termination
+message) and params (the parameters of the run), e.g. steps['detect-drift'].outputs.drift > 0.1 && params.mode !=
+'dry-run'. A step with when clause is started even if some of its input steps were skipped (their inputs are missing
+then), e.g. a fallback step with when clause !steps.primary.executed.
+*/
+func WhenEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("steps", cel.MapType(cel.StringType, cel.MapType(cel.StringType, cel.DynType))),
+		cel.Variable("params", cel.MapType(cel.StringType, cel.StringType)),
+		cel.CrossTypeNumericComparisons(true),
+	)
+}
+
+func compileWhen(when string) (*cel.Env, *cel.Ast, error) {
+	env, err := WhenEnv()
+	if err != nil {
+		return nil, nil, err
+	}
+	ast, issues := env.Compile(when)
+	if issues.Err() != nil {
+		return nil, nil, issues.Err()
+	}
+	if !ast.OutputType().IsExactType(cel.BoolType) {
+		return nil, nil, errors.New("expression must evaluate to bool, not " + ast.OutputType().String())
+	}
+	return env, ast, nil
+}
+
+// CompileWhen compiles the when clause of a step, which must be a boolean expression
+func CompileWhen(when string) (cel.Program, error) {
+	env, ast, err := compileWhen(when)
+	if err != nil {
+		return nil, err
+	}
+	return env.Program(ast)
+}
+
+/*
+WhenStepReferences compiles the when clause of a step and returns the ids of the steps it refers to by constant names
+(steps.x or steps['x']), sorted and without duplicates
+*/
+func WhenStepReferences(when string) ([]string, error) {
+	_, ast, err := compileWhen(when)
+	if err != nil {
+		return nil, err
+	}
+	found := map[string]bool{}
+	isSteps := func(e *exprpb.Expr) bool {
+		return (e.GetIdentExpr() != nil) && (e.GetIdentExpr().Name == "steps")
+	}
+	var walk func(e *exprpb.Expr)
+	walk = func(e *exprpb.Expr) {
+		if e == nil {
+			return
+		}
+		switch kind := e.ExprKind.(type) {
+		case *exprpb.Expr_SelectExpr:
+			if isSteps(kind.SelectExpr.Operand) {
+				found[kind.SelectExpr.Field] = true
+			}
+			walk(kind.SelectExpr.Operand)
+		case *exprpb.Expr_CallExpr:
+			args := kind.CallExpr.Args
+			if (kind.CallExpr.Function == "_[_]") && (len(args) == 2) && isSteps(args[0]) {
+				if c, ok := args[1].GetConstExpr().GetConstantKind().(*exprpb.Constant_StringValue); ok {
+					found[c.StringValue] = true
+				}
+			}
+			walk(kind.CallExpr.Target)
+			for _, arg := range args {
+				walk(arg)
+			}
+		case *exprpb.Expr_ListExpr:
+			for _, element := range kind.ListExpr.Elements {
+				walk(element)
+			}
+		case *exprpb.Expr_StructExpr:
+			for _, entry := range kind.StructExpr.Entries {
+				walk(entry.GetMapKey())
+				walk(entry.Value)
+			}
+		case *exprpb.Expr_ComprehensionExpr:
+			c := kind.ComprehensionExpr
+			for _, sub := range []*exprpb.Expr{c.IterRange, c.AccuInit, c.LoopCondition, c.LoopStep, c.Result} {
+				walk(sub)
+			}
+		}
+	}
+	walk(ast.Expr())
+	var res []string
+	for stepId := range found {
+		res = append(res, stepId)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// the steps from which a step is reachable by pipes
+func upstreamSteps(structure *PipelineStructure, stepId string) map[string]bool {
+	res := map[string]bool{}
+	todo := []string{stepId}
+	for len(todo) > 0 {
+		current := todo[0]
+		todo = todo[1:]
+		for _, pipe := range structure.Pipes {
+			if (pipe.To.StepId == current) && (pipe.From.StepId != InputStepId) && !res[pipe.From.StepId] {
+				res[pipe.From.StepId] = true
+				todo = append(todo, pipe.From.StepId)
+			}
+		}
+	}
+	return res
+}
+
+/*
+find a cycle in the graph of steps connected by pipes, returns the index of the pipe that closes the cycle and the
+step ids along the cycle (nil if the graph is acyclic)
+*/
+func findCycle(structure *PipelineStructure) (int, []string) {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := map[string]int{}
+	var stack []string
+	var visit func(stepId string) (int, []string)
+	visit = func(stepId string) (int, []string) {
+		state[stepId] = visiting
+		stack = append(stack, stepId)
+		for i, pipe := range structure.Pipes {
+			if pipe.From.StepId != stepId {
+				continue
+			}
+			switch state[pipe.To.StepId] {
+			case visiting:
+				for j, id := range stack {
+					if id == pipe.To.StepId {
+						return i, append(append([]string{}, stack[j:]...), pipe.To.StepId)
+					}
+				}
+			case unvisited:
+				if i, cycle := visit(pipe.To.StepId); cycle != nil {
+					return i, cycle
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[stepId] = done
+		return 0, nil
+	}
+	var stepIds []string
+	for _, step := range structure.JobSteps {
+		stepIds = append(stepIds, step.Id)
+	}
+	for _, sp := range structure.SubPipelines {
+		stepIds = append(stepIds, sp.Id)
+	}
+	for _, stepId := range stepIds {
+		if state[stepId] == unvisited {
+			if i, cycle := visit(stepId); cycle != nil {
+				return i, cycle
+			}
+		}
+	}
+	return 0, nil
+}
+
+func pipeString(pipe *PipelinePipe) string {
+	return pipe.From.StepId + "." + pipe.From.Name + " -> " + pipe.To.StepId + "." + pipe.To.Name
+}
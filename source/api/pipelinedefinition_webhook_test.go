@@ -0,0 +1,164 @@
+package v1
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("PipelineDefinition validation", func() {
+	pipe := func(from string, fromName string, to string, toName string) *PipelinePipe {
+		return &PipelinePipe{From: PipeConnector{StepId: from, Name: fromName}, To: PipeConnector{StepId: to, Name: toName}}
+	}
+	definition := func(pipes ...*PipelinePipe) *PipelineDefinition {
+		return &PipelineDefinition{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-1.0.0"},
+			Spec: PipelineDefinitionSpec{
+				Name:    "test",
+				Version: "1.0.0",
+				PipelineStructure: PipelineStructure{
+					JobSteps:     []*PipelineJobStepSpec{{Id: "a"}, {Id: "b"}},
+					SubPipelines: []*SubPipelineSpec{{Id: "c"}},
+					Pipes:        pipes,
+				},
+			},
+		}
+	}
+	fields := func(pd *PipelineDefinition) []string {
+		var res []string
+		for _, err := range pd.ValidateStructure() {
+			res = append(res, err.Field)
+		}
+		return res
+	}
+
+	It("should accept a valid definition", func() {
+		Expect(fields(definition(pipe("input", "x", "a", "x"), pipe("a", "y", "b", "y"), pipe("b", "z", "c", "z"), pipe("c", "r", "output", "r")))).To(BeEmpty())
+	})
+
+	It("should reject a cycle", func() {
+		Expect(fields(definition(pipe("a", "y", "b", "y"), pipe("b", "z", "c", "z"), pipe("c", "r", "a", "r")))).To(Equal([]string{"spec.pipelineStructure.pipes[2]"}))
+	})
+
+	It("should reject pipes referring to unknown steps", func() {
+		Expect(fields(definition(pipe("d", "y", "b", "y"), pipe("a", "z", "e", "z")))).To(Equal([]string{
+			"spec.pipelineStructure.pipes[0].from.stepId",
+			"spec.pipelineStructure.pipes[1].to.stepId",
+		}))
+	})
+
+	It("should reject duplicate and invalid step ids", func() {
+		pd := definition()
+		pd.Spec.PipelineStructure.SubPipelines = append(pd.Spec.PipelineStructure.SubPipelines, &SubPipelineSpec{Id: "a"}, &SubPipelineSpec{Id: "Step_1"}, &SubPipelineSpec{Id: "output"})
+		Expect(fields(pd)).To(Equal([]string{
+			"spec.pipelineStructure.subPipelines[1].id",
+			"spec.pipelineStructure.subPipelines[2].id",
+			"spec.pipelineStructure.subPipelines[3].id",
+		}))
+	})
+
+	It("should reject when clauses that are not boolean expressions", func() {
+		pd := definition()
+		pd.Spec.PipelineStructure.JobSteps[0].When = "params.mode != 'dry-run'"
+		pd.Spec.PipelineStructure.JobSteps[1].When = "params.mode"
+		pd.Spec.PipelineStructure.SubPipelines[0].When = "steps.x.state =="
+		Expect(fields(pd)).To(Equal([]string{
+			"spec.pipelineStructure.jobSteps[1].when",
+			"spec.pipelineStructure.subPipelines[0].when",
+		}))
+	})
+
+	It("should reject when clauses referring to steps that are not upstream", func() {
+		pd := definition(pipe("a", "y", "b", "y"), pipe("b", "z", "c", "z"))
+		pd.Spec.PipelineStructure.JobSteps[0].When = "steps.b.state == 'Succeeded'"
+		pd.Spec.PipelineStructure.JobSteps[1].When = "steps.a.executed && params.mode != 'dry-run'"
+		pd.Spec.PipelineStructure.SubPipelines[0].When = "!steps['a'].executed || steps.x.state == 'Skipped'"
+		Expect(fields(pd)).To(Equal([]string{
+			"spec.pipelineStructure.jobSteps[0].when",
+			"spec.pipelineStructure.subPipelines[0].when",
+		}))
+		Expect(WhenStepReferences("!steps['a'].executed || steps.x.outputs.y > 1")).To(Equal([]string{"a", "x"}))
+	})
+
+	It("should reject secret mounts hiding operator directories and requests exceeding limits", func() {
+		pd := definition()
+		pd.Spec.PipelineStructure.JobSteps[0].JobSpec = JobSpec{
+			SecretMounts: []SecretMount{
+				{SecretName: "s", MountPath: "/etc/secrets"},
+				{SecretName: "s", MountPath: "/workdir/secrets"},
+				{SecretName: "s", MountPath: "etc/secrets"},
+				{SecretName: "s", MountPath: "/etc/secrets/"},
+			},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2"), corev1.ResourceMemory: resource.MustParse("1Gi")},
+				Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("2Gi")},
+			},
+		}
+		pd.Spec.TerminationJobs = []JobSpec{{SecretMounts: []SecretMount{{SecretName: "s", MountPath: "/"}}}}
+		Expect(fields(pd)).To(Equal([]string{
+			"spec.pipelineStructure.jobSteps[0].jobSpec.secretMounts[1].mountPath",
+			"spec.pipelineStructure.jobSteps[0].jobSpec.secretMounts[2].mountPath",
+			"spec.pipelineStructure.jobSteps[0].jobSpec.secretMounts[3].mountPath",
+			"spec.pipelineStructure.jobSteps[0].jobSpec.resources.requests[cpu]",
+			"spec.terminationJobs[0].secretMounts[0].mountPath",
+		}))
+	})
+
+	It("should reject specifications conflicting with the pod template of the operator", func() {
+		pd := definition()
+		pd.Spec.PipelineStructure.JobSteps[0].JobSpec.Specification = []byte(`{"tolerations": [{"key": "gpu", "operator": "Exists"}], "containers": [{"name": "main", "securityContext": {"runAsNonRoot": true}}]}`)
+		pd.Spec.PipelineStructure.JobSteps[1].JobSpec.Specification = []byte(`{"spec": {"volumes": [{"name": "workdir"}], "initContainers": [{"name": "init"}], "containers": [{"name": "main", "image": "other", "volumeMounts": [{"name": "x", "mountPath": "/vol/a"}]}]}}`)
+		pd.Spec.TerminationJobs = []JobSpec{{Specification: []byte(`"tolerations"`)}}
+		Expect(fields(pd)).To(Equal([]string{
+			"spec.pipelineStructure.jobSteps[1].jobSpec.specification.volumes[workdir]",
+			"spec.pipelineStructure.jobSteps[1].jobSpec.specification.initContainers[init]",
+			"spec.pipelineStructure.jobSteps[1].jobSpec.specification.containers[main]",
+			"spec.pipelineStructure.jobSteps[1].jobSpec.specification.containers[main].volumeMounts[x]",
+			"spec.terminationJobs[0].specification",
+		}))
+	})
+
+	It("should reject pipes writing the same target file", func() {
+		Expect(fields(definition(pipe("a", "x", "c", "in"), pipe("b", "y", "c", "in")))).To(Equal([]string{"spec.pipelineStructure.pipes[1].to.name"}))
+	})
+
+	It("should only allow changes of the lifecycle", func() {
+		old := definition(pipe("a", "y", "b", "y"))
+		pd := old.DeepCopy()
+		pd.Spec.Lifecycle = "Deprecated"
+		validator := &PipelineDefinitionValidator{}
+		_, err := validator.ValidateUpdate(context.Background(), old, pd)
+		Expect(err).NotTo(HaveOccurred())
+		pd.Spec.PipelineStructure.Pipes[0].To.Name = "z"
+		_, err = validator.ValidateUpdate(context.Background(), old, pd)
+		Expect(err).To(HaveOccurred())
+		_, err = validator.ValidateUpdate(context.Background(), old, &PipelineRun{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reject new definitions violating the image policy and warn on updates", func() {
+		SetImageAdmission(func(ctx context.Context, pd *PipelineDefinition) ([]string, error) {
+			return []string{"step a: image busybox is denied by the image policy"}, nil
+		})
+		defer SetImageAdmission(nil)
+		old := definition()
+		validator := &PipelineDefinitionValidator{}
+		_, err := validator.ValidateCreate(context.Background(), old)
+		Expect(err).To(MatchError(ContainSubstring("image busybox is denied")))
+		pd := old.DeepCopy()
+		pd.Spec.Lifecycle = "Deprecated"
+		warnings, err := validator.ValidateUpdate(context.Background(), old, pd)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(warnings).To(HaveLen(1))
+	})
+
+	It("should reject a name that does not match pipeline name and version", func() {
+		pd := definition()
+		pd.Name = "test"
+		Expect(fields(pd)).To(Equal([]string{"metadata.name"}))
+	})
+})
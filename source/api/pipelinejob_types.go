@@ -3,6 +3,7 @@ package v1
 import (
 	"encoding/json"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -28,17 +29,124 @@ type JobSpec struct {
 	BackoffLimit *int32 `json:"backoffLimit"`
 	// +kubebuilder:validation:Optional
 	ServiceAccountName string `json:"serviceAccountName,omitempty"`
-
+	// compute resources of the step container (requests and limits not set are taken from the namespace defaults)
+	// +kubebuilder:validation:Optional
+	Resources v1.ResourceRequirements `json:"resources,omitempty"`
+	// environment variables of the step container
+	// +kubebuilder:validation:Optional
+	Env []v1.EnvVar `json:"env,omitempty"`
+	// environment variables of the step container taken from config maps or secrets
+	// +kubebuilder:validation:Optional
+	EnvFrom []v1.EnvFromSource `json:"envFrom,omitempty"`
+	// secrets mounted into the step container
+	// +kubebuilder:validation:Optional
+	SecretMounts []SecretMount `json:"secretMounts,omitempty"`
+	// secrets for pulling the images of the job (defaults to the ones configured for the namespace)
+	// +kubebuilder:validation:Optional
+	ImagePullSecrets []v1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+	// strategic merge patch applied to the pod template of the job: a partial PodSpec or a full PodTemplateSpec (e.g.
+	// tolerations, affinity, security contexts, sidecars or extra volumes), the volumes and containers generated by
+	// the operator can not be overridden
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:validation:Schemaless
 	// +kubebuilder:pruning:PreserveUnknownFields
 	Specification json.RawMessage `json:"specification,omitempty"`
 }
 
+/* SecretMount defines a secret that is mounted as directory into the step container */
+type SecretMount struct {
+	// +kubebuilder:validation:Required
+	SecretName string `json:"secretName"`
+	// absolute path of the directory, must not overlap with the paths used by the operator (by default /workdir, /etc/config, /vol)
+	// +kubebuilder:validation:Required
+	MountPath string `json:"mountPath"`
+	// keys of the secret to be mounted (all keys if not set)
+	// +kubebuilder:validation:Optional
+	Items []v1.KeyToPath `json:"items,omitempty"`
+	// +kubebuilder:validation:Optional
+	Optional *bool `json:"optional,omitempty"`
+}
+
+/* ArtifactStore defines where the outputs of job steps are stored: in persistent volume claims (default) or in an S3 compatible object storage */
+type ArtifactStore struct {
+	// +kubebuilder:validation:Optional
+	S3 *S3ArtifactStore `json:"s3,omitempty"`
+}
+
+/*
+S3ArtifactStore defines a bucket of an S3 compatible object storage (e.g. MinIO). Inputs are downloaded into the working
+directory before the step container starts, the output directory is uploaded after it has succeeded.
+*/
+type S3ArtifactStore struct {
+	// url of the storage service (defaults to AWS S3)
+	// +kubebuilder:validation:Optional
+	Endpoint string `json:"endpoint,omitempty"`
+	// +kubebuilder:validation:Required
+	Bucket string `json:"bucket"`
+	// key prefix of the artifacts in the bucket
+	// +kubebuilder:validation:Optional
+	Prefix string `json:"prefix,omitempty"`
+	// +kubebuilder:validation:Optional
+	Region string `json:"region,omitempty"`
+	// name of a secret holding the keys AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+	// +kubebuilder:validation:Optional
+	CredentialsSecret string `json:"credentialsSecret,omitempty"`
+	// image providing the aws cli (defaults to amazon/aws-cli)
+	// +kubebuilder:validation:Optional
+	Image string `json:"image,omitempty"`
+}
+
+/* RetryPolicy defines how often and under which conditions a failed step is retried with a fresh Job */
+type RetryPolicy struct {
+	// maximum number of attempts (including the first one)
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Minimum=1
+	MaxAttempts int32 `json:"maxAttempts"`
+	// delay before the second attempt, doubled for each further attempt (defaults to 10)
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	BackoffSeconds *int32 `json:"backoffSeconds,omitempty"`
+	// upper limit of the delay between attempts (defaults to 600)
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	MaxBackoffSeconds *int32 `json:"maxBackoffSeconds,omitempty"`
+	// failure reasons that lead to a retry, all failures are retried if not set
+	// +kubebuilder:validation:Optional
+	RetryOn []RetryCondition `json:"retryOn,omitempty"`
+	// exit codes that are retried if "Error" is one of the retry conditions (any non-zero exit code if not set)
+	// +kubebuilder:validation:Optional
+	ExitCodes []int32 `json:"exitCodes,omitempty"`
+}
+
+// +kubebuilder:validation:Enum=Error;OOMKilled;Evicted;DeadlineExceeded
+type RetryCondition string
+
+/* JobAttempt records the outcome of one execution of a step */
+type JobAttempt struct {
+	// +kubebuilder:validation:Required
+	Attempt int32 `json:"attempt"`
+	// +kubebuilder:validation:Required
+	JobName string `json:"jobName"`
+	// +kubebuilder:validation:Required
+	Succeeded bool `json:"succeeded"`
+	// exit code of the main container (if it has terminated)
+	// +kubebuilder:validation:Optional
+	ExitCode *int32 `json:"exitCode,omitempty"`
+	// +kubebuilder:validation:Optional
+	Reason string `json:"reason,omitempty"`
+	// +kubebuilder:validation:Optional
+	FinishedAt *metav1.Time `json:"finishedAt,omitempty"`
+}
+
 /* InputPipe defines source and target name of input pipe file */
 type InputPipe struct {
+	// name of the resource holding the file (the uri of the directory for artifacts)
 	// +kubebuilder:validation:Required
 	Volume string `json:"volume"`
+	// kind of resource the volume refers to, defaults to a persistent volume claim
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=PersistentVolumeClaim;ConfigMap;Secret;Artifact
+	VolumeType string `json:"volumeType,omitempty"`
 	// +kubebuilder:validation:Required
 	MountPath string `json:"mountPath"`
 	// +kubebuilder:validation:Required
@@ -63,6 +171,23 @@ type PipelineJobSpec struct {
 	Inputs []InputPipe `json:"inputVolumes,omitempty"`
 	// +kubebuilder:validation:Required
 	JobSpec *JobSpec `json:"jobSpec"`
+	// environment variables provided by the operator
+	// +kubebuilder:validation:Optional
+	Env []v1.EnvVar `json:"env,omitempty"`
+	// termination jobs of a run have no config and no output volume, their results do not affect the run
+	// +kubebuilder:validation:Optional
+	TerminationJob bool `json:"terminationJob,omitempty"`
+	// +kubebuilder:validation:Optional
+	RetryPolicy *RetryPolicy `json:"retryPolicy,omitempty"`
+	// size limit of the emptyDir volume mounted as working directory (defaults to 1Gi)
+	// +kubebuilder:validation:Optional
+	WorkdirSizeLimit *resource.Quantity `json:"workdirSizeLimit,omitempty"`
+	// store from which artifact inputs are downloaded and to which the output is uploaded
+	// +kubebuilder:validation:Optional
+	ArtifactStore *ArtifactStore `json:"artifactStore,omitempty"`
+	// uri to which the output directory is uploaded (if not set, the output is written to the volume of the job)
+	// +kubebuilder:validation:Optional
+	OutputArtifact string `json:"outputArtifact,omitempty"`
 }
 
 // ScheduleStatus defines the observed state of Schedule
@@ -70,6 +195,15 @@ type PipelineJobStatus struct {
 	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
 	// +kubebuilder:validation:Optional
 	State *string `json:"state"`
+	// outcomes of the finished attempts
+	// +kubebuilder:validation:Optional
+	Attempts []JobAttempt `json:"attempts,omitempty"`
+	// values published by the succeeded step as JSON object in its termination message (not set if the message is no
+	// JSON object or exceeds the size limit)
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Outputs json.RawMessage `json:"outputs,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -1,9 +1,78 @@
 package v1
 
 import (
+	"encoding/json"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+/*
+PipeBinding binds a named input or output pipe of a pipeline run to a file. The file is located in a persistent volume
+claim, a config map, a secret or an artifact store (exactly one of them must be set), or is taken from an output pipe of
+another run.
+*/
+type PipeBinding struct {
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+	// name of the persistent volume claim holding the file
+	// +kubebuilder:validation:Optional
+	Volume string `json:"volume,omitempty"`
+	// name of the config map holding the file (the source file is the key)
+	// +kubebuilder:validation:Optional
+	ConfigMap string `json:"configMap,omitempty"`
+	// name of the secret holding the file (the source file is the key)
+	// +kubebuilder:validation:Optional
+	Secret string `json:"secret,omitempty"`
+	// uri of the directory holding the file in the artifact store of the run (e.g. s3://bucket/prefix)
+	// +kubebuilder:validation:Optional
+	Artifact string `json:"artifact,omitempty"`
+	// name of a succeeded run in the same namespace, the source file is the name of one of its output pipes
+	// +kubebuilder:validation:Optional
+	Run string `json:"run,omitempty"`
+	// +kubebuilder:validation:Required
+	SourceFile string `json:"sourceFile"`
+}
+
+/* BatchStatus holds the progress of a batched sub-pipeline step */
+type BatchStatus struct {
+	// +kubebuilder:validation:Required
+	StepId string `json:"stepId"`
+	// +kubebuilder:validation:Optional
+	Items []string `json:"items,omitempty"`
+	// +kubebuilder:validation:Required
+	NumSucceeded int `json:"numSucceeded"`
+	// +kubebuilder:validation:Required
+	NumFailed int `json:"numFailed"`
+}
+
+/* StepOutputs holds the values published by a job step through its termination message */
+type StepOutputs struct {
+	// +kubebuilder:validation:Required
+	StepId string `json:"stepId"`
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Outputs json.RawMessage `json:"outputs,omitempty"`
+}
+
+/* TerminationJobStatus holds the state of a termination job of a pipeline run */
+type TerminationJobStatus struct {
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+	// +kubebuilder:validation:Optional
+	State *string `json:"state"`
+}
+
+/* StepReuse refers to succeeded steps of a previous run whose outputs are reused instead of executing the steps again */
+type StepReuse struct {
+	// name of the previous run (in the same namespace)
+	// +kubebuilder:validation:Required
+	Run string `json:"run"`
+	// ids of the job steps to be reused
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinItems=1
+	Steps []string `json:"steps"`
+}
+
 /* PipelineRunSpec defines specs of a pipeline run */
 type PipelineRunSpec struct {
 	// +kubebuilder:validation:Required
@@ -12,10 +81,46 @@ type PipelineRunSpec struct {
 	VersionPattern string `json:"versionPattern"`
 	// +kubebuilder:validation:Optional
 	Description *string `json:"description"`
+	// namespace and name of the parent run (separated by "/") if this run executes a sub-pipeline
 	// +kubebuilder:validation:Optional
 	ParentRun *string `json:"parentRun"`
-	// +kubebuilder:validation:Required
-	InputPipes []*string `json:"inputPipes"`
+	// bindings for the pipes that start at the reserved step "input" of the pipeline structure
+	// +kubebuilder:validation:Optional
+	InputPipes []PipeBinding `json:"inputPipes"`
+	// desired state of the run: pausing stops new steps from being started, terminating also cancels active steps
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Running;Paused;Terminated
+	// +kubebuilder:default=Running
+	Control string `json:"control,omitempty"`
+	// maximum number of steps that are active at the same time, overrides the setting of the pipeline definition
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	MaxParallelSteps *int32 `json:"maxParallelSteps,omitempty"`
+	// the output volumes of these steps are cloned from a previous run, the steps are not executed again
+	// +kubebuilder:validation:Optional
+	ReuseFrom *StepReuse `json:"reuseFrom,omitempty"`
+	// keep the output volumes of all steps after the run has succeeded, so that later runs can reuse them
+	// +kubebuilder:validation:Optional
+	KeepVolumes bool `json:"keepVolumes,omitempty"`
+	// store for the outputs of the job steps, persistent volume claims are used if not set
+	// +kubebuilder:validation:Optional
+	ArtifactStore *ArtifactStore `json:"artifactStore,omitempty"`
+	// retention of the outputs of the job steps, overrides the retention of the pipeline definition
+	// +kubebuilder:validation:Optional
+	Retention *RetentionPolicy `json:"retention,omitempty"`
+	// the run is terminated if it has not finished this many seconds after its start (the scheduled time for runs
+	// created by a schedule)
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	ActiveDeadlineSeconds *int64 `json:"activeDeadlineSeconds,omitempty"`
+	// a warning is emitted and the condition SLAMissed is set if the run has not finished this many seconds after its
+	// start (the scheduled time for runs created by a schedule)
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	SLASeconds *int64 `json:"slaSeconds,omitempty"`
+	// parameters of the run, available to the when clauses of the steps as params (passed on to child runs)
+	// +kubebuilder:validation:Optional
+	Parameters map[string]string `json:"parameters,omitempty"`
 }
 
 // PipelineRunStatus defines the observed state of a pipeline run
@@ -23,6 +128,9 @@ type PipelineRunStatus struct {
 	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
 	// +kubebuilder:validation:Optional
 	PipelineVersion *string `json:"pipelineVersion"`
+	// versions of the pipeline that matched the version pattern (highest first)
+	// +kubebuilder:validation:Optional
+	VersionCandidates []string `json:"versionCandidates,omitempty"`
 	// +kubebuilder:validation:Optional
 	PipelineStructure *PipelineStructure `json:"pipelineStructure"`
 	// +kubebuilder:validation:Required
@@ -31,10 +139,42 @@ type PipelineRunStatus struct {
 	NumStepsSucceeded int `json:"numStepsSucceeded"`
 	// +kubebuilder:validation:Required
 	NumStepsFailed int `json:"numStepsFailed"`
+	// steps that were skipped because of their when clause (or skipped inputs)
+	// +kubebuilder:validation:Optional
+	NumStepsSkipped int `json:"numStepsSkipped,omitempty"`
 	// +kubebuilder:validation:Required
 	NumStepsTotal int `json:"numStepsTotal"`
 	// +kubebuilder:validation:Optional
 	State *string `json:"state"`
+	// bindings for the pipes that end at the reserved step "output" of the pipeline structure (set on success, pipes
+	// from skipped steps are omitted)
+	// +kubebuilder:validation:Optional
+	OutputPipes []PipeBinding `json:"outputPipes,omitempty"`
+	// progress of the batched sub-pipeline steps
+	// +kubebuilder:validation:Optional
+	Batches []BatchStatus `json:"batches,omitempty"`
+	// states of the termination jobs (they do not affect the state of the run)
+	// +kubebuilder:validation:Optional
+	TerminationJobs []TerminationJobStatus `json:"terminationJobs,omitempty"`
+	// cache keys of the started steps that have caching enabled (by step id)
+	// +kubebuilder:validation:Optional
+	StepCacheKeys map[string]string `json:"stepCacheKeys,omitempty"`
+	// hashes of the contents of the input files of the steps that have caching enabled (by step id, empty if the
+	// inputs could not be hashed)
+	// +kubebuilder:validation:Optional
+	InputHashes map[string]string `json:"inputHashes,omitempty"`
+	// ids of the steps whose output was taken from the cache
+	// +kubebuilder:validation:Optional
+	CacheHits []string `json:"cacheHits,omitempty"`
+	// uris of the outputs of the job steps in the artifact store (by step id)
+	// +kubebuilder:validation:Optional
+	Artifacts map[string]string `json:"artifacts,omitempty"`
+	// outputs of the succeeded job steps (available to when clauses as steps.<id>.outputs)
+	// +kubebuilder:validation:Optional
+	StepOutputs []StepOutputs `json:"stepOutputs,omitempty"`
+	// time at which the run has succeeded, failed or was terminated
+	// +kubebuilder:validation:Optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -45,7 +185,9 @@ type PipelineRunStatus struct {
 //+kubebuilder:printcolumn:name="Active",type="integer",JSONPath=`.status.numStepsActive`
 //+kubebuilder:printcolumn:name="Success",type="integer",JSONPath=`.status.numStepsSucceeded`
 //+kubebuilder:printcolumn:name="Failed",type="integer",JSONPath=`.status.numStepsFailed`
+//+kubebuilder:printcolumn:name="Skipped",type="integer",JSONPath=`.status.numStepsSkipped`,priority=1
 //+kubebuilder:printcolumn:name="Total",type="integer",JSONPath=`.status.numStepsTotal`
+//+kubebuilder:printcolumn:name="Control",type="string",JSONPath=`.spec.control`,priority=1
 //+kubebuilder:printcolumn:name="State",type="string",JSONPath=`.status.state`
 
 // PipelineRun is the Schema for the pipelines runs
@@ -28,6 +28,33 @@ type PipelineScheduleSpec struct {
 	PipelineName string `json:"pipelineName"`
 	// +kubebuilder:validation:Required
 	Schedules []*ScheduleInRange `json:"schedules"`
+	// store for the outputs of the job steps of the scheduled runs
+	// +kubebuilder:validation:Optional
+	ArtifactStore *ArtifactStore `json:"artifactStore,omitempty"`
+	// number of succeeded runs of the schedule that are kept (older ones are deleted, all are kept if not set)
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	SuccessfulRunsHistoryLimit *int32 `json:"successfulRunsHistoryLimit,omitempty"`
+	// number of failed (or terminated) runs of the schedule that are kept (older ones are deleted, all are kept if not set)
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	FailedRunsHistoryLimit *int32 `json:"failedRunsHistoryLimit,omitempty"`
+	// runs of the schedule are deleted this many seconds after they have finished
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	TTLSecondsAfterFinished *int32 `json:"ttlSecondsAfterFinished,omitempty"`
+	// runs are terminated if they have not finished this many seconds after their scheduled time
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	ActiveDeadlineSeconds *int64 `json:"activeDeadlineSeconds,omitempty"`
+	// runs that have not finished this many seconds after their scheduled time miss their SLA (e.g. 7200 for runs
+	// scheduled at 04:00 that must finish by 06:00)
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	SLASeconds *int64 `json:"slaSeconds,omitempty"`
+	// parameters of the runs of the schedule
+	// +kubebuilder:validation:Optional
+	Parameters map[string]string `json:"parameters,omitempty"`
 }
 
 // ScheduleStatus defines the observed state of Schedule
@@ -36,6 +63,15 @@ type PipelineScheduleStatus struct {
 	VersionPattern string             `json:"versionPattern"`
 	CronSpec       string             `json:"cronSpec"`
 	TimeZone       string             `json:"timeZone"`
+	// scheduled time of the last created pipeline run
+	// +kubebuilder:validation:Optional
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+	// name of the last created pipeline run
+	// +kubebuilder:validation:Optional
+	LastRun string `json:"lastRun,omitempty"`
+	// scheduled time of the next pipeline run
+	// +kubebuilder:validation:Optional
+	NextScheduleTime *metav1.Time `json:"nextScheduleTime,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -45,6 +81,8 @@ type PipelineScheduleStatus struct {
 //+kubebuilder:printcolumn:name="VersionPattern",type="string",JSONPath=`.status.versionPattern`
 //+kubebuilder:printcolumn:name="Schedule",type="string",JSONPath=`.status.cronSpec`
 //+kubebuilder:printcolumn:name="TimeZone",type="string",JSONPath=`.status.timeZone`
+//+kubebuilder:printcolumn:name="LastRun",type="string",JSONPath=`.status.lastRun`
+//+kubebuilder:printcolumn:name="Next",type="date",JSONPath=`.status.nextScheduleTime`
 
 // Pipeline is the Schema for the pipelines API
 type PipelineSchedule struct {
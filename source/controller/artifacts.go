@@ -0,0 +1,231 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"path"
+	"strings"
+)
+
+const (
+	// kind of input pipes that are downloaded from the artifact store
+	VolumeTypeArtifact = "Artifact"
+
+	DefaultArtifactImage = "amazon/aws-cli"
+)
+
+// ArtifactStore abstracts the storage of the outputs of job steps
+type ArtifactStore interface {
+	// prepare the output of a step before its PipelineJob is created
+	CreateOutput(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, stepId string, storage *storageSettings) error
+	// use the output of a succeeded step of another run as output of a step, returns true when the output is ready. If
+	// this is not possible the reason is returned as message (the error is only set in case of failures when accessing
+	// the api server)
+	CopyOutput(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, stepId string, source *pipelinev1.PipelineRun) (bool, string, error)
+	// remove the output of a step that is not needed anymore
+	DeleteOutput(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, stepId string) error
+	// binding of a file in the output of a step
+	OutputBinding(pr *pipelinev1.PipelineRun, stepId string, file string) *pipelinev1.PipeBinding
+}
+
+// the artifact store of a run
+func (r *PipelineRunReconciler) artifactStore(pr *pipelinev1.PipelineRun) ArtifactStore {
+	if s3 := s3Store(pr.Spec.ArtifactStore); s3 != nil {
+		return &s3ArtifactStore{r: r, spec: s3}
+	}
+	return &volumeArtifactStore{r: r}
+}
+
+func s3Store(store *pipelinev1.ArtifactStore) *pipelinev1.S3ArtifactStore {
+	if store == nil {
+		return nil
+	}
+	return store.S3
+}
+
+// outputs are stored in a persistent volume claim per step (named like the PipelineJob)
+type volumeArtifactStore struct {
+	r *PipelineRunReconciler
+}
+
+func (s *volumeArtifactStore) CreateOutput(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, stepId string, storage *storageSettings) error {
+	if isPVCActive(pr, stepId) {
+		return nil
+	}
+	if _, err := s.r.CreatePersistentVolumeClaim(ctx, log, pr, s.r.ConstructPipelineJobName(pr, stepId), storage); err != nil {
+		return err
+	}
+	return s.r.setPVCStatus(ctx, log, pr, stepId, metav1.ConditionTrue, "pvc was created")
+}
+
+// the output volume is cloned, restored from a snapshot or copied by a job, depending on the storage config map
+func (s *volumeArtifactStore) CopyOutput(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, stepId string, source *pipelinev1.PipelineRun) (bool, string, error) {
+	cm, err := s.r.getStorageConfig(ctx, pr.Namespace)
+	if err != nil {
+		return false, "", err
+	}
+	method, err := reuseMethod(cm)
+	if err != nil {
+		return false, err.Error(), nil
+	}
+	if !isPVCActive(pr, stepId) {
+		sourcePvc, err := s.r.GetPersistentVolumeClaim(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: s.r.ConstructPipelineJobName(source, stepId)})
+		if err != nil {
+			return false, "", err
+		}
+		if (sourcePvc == nil) || !isPVCActive(source, stepId) {
+			return false, "output volume of step " + stepId + " of run " + source.Name + " does not exist anymore (use keepVolumes to retain it)", nil
+		}
+		volumeName := s.r.ConstructPipelineJobName(pr, stepId)
+		var dataSource *corev1.TypedLocalObjectReference
+		switch method {
+		case ReuseMethodClone:
+			dataSource = pvcDataSource(sourcePvc)
+		case ReuseMethodSnapshot:
+			if dataSource, err = s.r.CreateVolumeSnapshot(ctx, log, pr, volumeName, sourcePvc, storageConfig(cm, "volumeSnapshotClassName")); err != nil {
+				return false, "", err
+			}
+		}
+		if _, err := s.r.ClonePersistentVolumeClaim(ctx, log, pr, volumeName, sourcePvc, dataSource); err != nil {
+			return false, "", err
+		}
+		if method == ReuseMethodCopy {
+			if err := s.r.CreateCopyJob(ctx, log, pr, stepId, sourcePvc); err != nil {
+				return false, "", err
+			}
+		}
+		if err := s.r.setPVCStatus(ctx, log, pr, stepId, metav1.ConditionTrue, "pvc was created from "+sourcePvc.Name+" ("+method+")"); err != nil {
+			return false, "", err
+		}
+	}
+	if method == ReuseMethodCopy {
+		return s.r.copyJobCompleted(ctx, log, pr, stepId)
+	}
+	return true, "", nil
+}
+
+func (s *volumeArtifactStore) DeleteOutput(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, stepId string) error {
+	if err := s.r.DeletePersistentVolumeClaim(ctx, log, pr, s.r.ConstructPipelineJobName(pr, stepId)); err != nil {
+		return err
+	}
+	return s.r.setPVCStatus(ctx, log, pr, stepId, metav1.ConditionFalse, "pvc was deleted")
+}
+
+func (s *volumeArtifactStore) OutputBinding(pr *pipelinev1.PipelineRun, stepId string, file string) *pipelinev1.PipeBinding {
+	return &pipelinev1.PipeBinding{
+		Name:       file,
+		Volume:     s.r.ConstructPipelineJobName(pr, stepId),
+		SourceFile: file,
+	}
+}
+
+// outputs are uploaded to a bucket of an S3 compatible object storage, their uris are recorded in the run status
+type s3ArtifactStore struct {
+	r    *PipelineRunReconciler
+	spec *pipelinev1.S3ArtifactStore
+}
+
+// uri of the directory holding the output of a step
+func artifactURI(spec *pipelinev1.S3ArtifactStore, pr *pipelinev1.PipelineRun, stepId string) string {
+	return "s3://" + spec.Bucket + "/" + path.Join(spec.Prefix, pr.Namespace, pr.Name, stepId)
+}
+
+func (s *s3ArtifactStore) CreateOutput(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, stepId string, storage *storageSettings) error {
+	if _, found := pr.Status.Artifacts[stepId]; found {
+		return nil
+	}
+	return s.setArtifact(ctx, pr, stepId, artifactURI(s.spec, pr, stepId))
+}
+
+// outputs in the object storage are not copied, the step refers to the output of the other run
+func (s *s3ArtifactStore) CopyOutput(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, stepId string, source *pipelinev1.PipelineRun) (bool, string, error) {
+	uri, found := source.Status.Artifacts[stepId]
+	if !found {
+		return false, "run " + source.Name + " has no artifact of step " + stepId, nil
+	}
+	return true, "", s.setArtifact(ctx, pr, stepId, uri)
+}
+
+// objects are kept, their expiration is left to the lifecycle rules of the bucket
+func (s *s3ArtifactStore) DeleteOutput(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, stepId string) error {
+	return nil
+}
+
+func (s *s3ArtifactStore) OutputBinding(pr *pipelinev1.PipelineRun, stepId string, file string) *pipelinev1.PipeBinding {
+	uri, found := pr.Status.Artifacts[stepId]
+	if !found {
+		uri = artifactURI(s.spec, pr, stepId)
+	}
+	return &pipelinev1.PipeBinding{
+		Name:       file,
+		Artifact:   uri,
+		SourceFile: file,
+	}
+}
+
+func (s *s3ArtifactStore) setArtifact(ctx context.Context, pr *pipelinev1.PipelineRun, stepId string, uri string) error {
+	if pr.Status.Artifacts == nil {
+		pr.Status.Artifacts = map[string]string{}
+	}
+	pr.Status.Artifacts[stepId] = uri
+	return s.r.Status().Update(ctx, pr)
+}
+
+// quote a string for use in a shell command
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// shell command downloading a file or directory from the artifact store
+func downloadCommand(uri string, target string) string {
+	return "(aws s3 cp " + shellQuote(uri) + " " + shellQuote(target) + " || aws s3 sync " + shellQuote(uri+"/") + " " + shellQuote(target) + ")"
+}
+
+// shell command uploading the contents of a directory to the artifact store (replacing leftovers of earlier uploads)
+func uploadCommand(dir string, uri string) string {
+	return "aws s3 sync --delete " + shellQuote(dir) + " " + shellQuote(uri)
+}
+
+// container running the given shell commands with access to the artifact store
+func transferContainer(name string, store *pipelinev1.ArtifactStore, commands []string, volumeMounts []corev1.VolumeMount) (corev1.Container, error) {
+	s3 := s3Store(store)
+	if s3 == nil {
+		return corev1.Container{}, errors.New("artifacts can only be transferred with an S3 artifact store")
+	}
+	image := s3.Image
+	if len(image) == 0 {
+		image = DefaultArtifactImage
+	}
+	var env []corev1.EnvVar
+	if len(s3.Endpoint) > 0 {
+		env = append(env, corev1.EnvVar{Name: "AWS_ENDPOINT_URL", Value: s3.Endpoint})
+	}
+	if len(s3.Region) > 0 {
+		env = append(env, corev1.EnvVar{Name: "AWS_DEFAULT_REGION", Value: s3.Region})
+	}
+	if len(s3.CredentialsSecret) > 0 {
+		for _, key := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"} {
+			env = append(env, corev1.EnvVar{
+				Name: key,
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: s3.CredentialsSecret},
+						Key:                  key,
+					},
+				},
+			})
+		}
+	}
+	return corev1.Container{
+		Name:            name,
+		Image:           image,
+		Command:         []string{"sh", "-c", strings.Join(commands, " && ")},
+		Env:             env,
+		VolumeMounts:    volumeMounts,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+	}, nil
+}
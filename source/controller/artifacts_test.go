@@ -0,0 +1,69 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Artifact store", func() {
+	s3 := &pipelinev1.S3ArtifactStore{Endpoint: "http://minio:9000", Bucket: "pipelines", Prefix: "runs", CredentialsSecret: "minio-credentials"}
+	pr := &pipelinev1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Name: "run-1", Namespace: "ns"}}
+
+	It("should place the outputs of steps below the prefix", func() {
+		Expect(artifactURI(s3, pr, "stepa")).To(Equal("s3://pipelines/runs/ns/run-1/stepa"))
+		Expect(artifactURI(&pipelinev1.S3ArtifactStore{Bucket: "b"}, pr, "stepa")).To(Equal("s3://b/ns/run-1/stepa"))
+	})
+
+	It("should prefer the recorded uri of a step output", func() {
+		store := &s3ArtifactStore{spec: s3}
+		run := pr.DeepCopy()
+		run.Status.Artifacts = map[string]string{"stepa": "s3://pipelines/runs/ns/run-0/stepa"}
+		Expect(store.OutputBinding(run, "stepa", "out").Artifact).To(Equal("s3://pipelines/runs/ns/run-0/stepa"))
+		Expect(store.OutputBinding(run, "stepb", "out").Artifact).To(Equal("s3://pipelines/runs/ns/run-1/stepb"))
+	})
+
+	It("should turn artifact bindings into artifact inputs", func() {
+		in, err := toInputPipe(&pipelinev1.PipeBinding{Name: "x", Artifact: "s3://b/k", SourceFile: "f"}, "x")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(in.VolumeType).To(Equal(VolumeTypeArtifact))
+		Expect(in.Volume).To(Equal("s3://b/k"))
+		_, err = toInputPipe(&pipelinev1.PipeBinding{Name: "x", Artifact: "s3://b/k", Volume: "v", SourceFile: "f"}, "x")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should quote arguments of transfer commands", func() {
+		Expect(shellQuote("it's")).To(Equal(`'it'\''s'`))
+		Expect(uploadCommand("/workdir/output", "s3://b/k")).To(Equal("aws s3 sync --delete '/workdir/output' 's3://b/k'"))
+	})
+
+	It("should pass endpoint and credentials to transfer containers", func() {
+		c, err := transferContainer("upload", &pipelinev1.ArtifactStore{S3: s3}, []string{"a", "b"}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.Image).To(Equal(DefaultArtifactImage))
+		Expect(c.Command).To(Equal([]string{"sh", "-c", "a && b"}))
+		Expect(c.Env).To(HaveLen(3))
+		Expect(c.Env[0].Value).To(Equal("http://minio:9000"))
+		Expect(c.Env[1].ValueFrom.SecretKeyRef.Name).To(Equal("minio-credentials"))
+		_, err = transferContainer("upload", nil, []string{"a"}, nil)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,431 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultManifestPipe = "manifest"
+	manifestMountPath   = "/manifest"
+	// key of the batch manifest in the config map written by the manifest job
+	ManifestKey = "manifest.json"
+)
+
+func isBatched(sp *pipelinev1.SubPipelineSpec) bool {
+	return (sp.Batched != nil) && *sp.Batched
+}
+
+func manifestPipe(sp *pipelinev1.SubPipelineSpec) string {
+	if sp.ManifestPipe != nil {
+		return *sp.ManifestPipe
+	}
+	return DefaultManifestPipe
+}
+
+// percentage of failed child runs that is tolerated, 0 if not specified
+func maxFailedPercentage(sp *pipelinev1.SubPipelineSpec) int {
+	if sp.MaxFailedPercentage != nil {
+		return int(*sp.MaxFailedPercentage)
+	}
+	return 0
+}
+
+// find the batch status of a step, returns nil if the batch manifest has not been read, yet
+func findBatchStatus(pr *pipelinev1.PipelineRun, stepId string) *pipelinev1.BatchStatus {
+	for i := range pr.Status.Batches {
+		if pr.Status.Batches[i].StepId == stepId {
+			return &pr.Status.Batches[i]
+		}
+	}
+	return nil
+}
+
+func (r *PipelineRunReconciler) constructManifestJobName(pr *pipelinev1.PipelineRun, stepId string) string {
+	return r.ConstructPipelineJobName(pr, stepId) + "-manifest"
+}
+
+func (r *PipelineRunReconciler) constructBatchRunName(pr *pipelinev1.PipelineRun, stepId string, index int) string {
+	return r.ConstructPipelineJobName(pr, stepId) + "-" + strconv.Itoa(index)
+}
+
+// check that the batch items can be used as names of sub-directories of the inputs of the child runs
+func validateBatchItems(items []string) error {
+	for i, item := range items {
+		if (len(item) == 0) || (item == ".") || (item == "..") || strings.Contains(item, "/") {
+			return fmt.Errorf("invalid batch item %d: %q (must be a plain directory name)", i, item)
+		}
+	}
+	return nil
+}
+
+// parse the batch manifest, a JSON array of batch items (null is rejected, since nil items mean "not read, yet")
+func parseBatchManifest(data string) ([]string, error) {
+	var items []string
+	if err := json.Unmarshal([]byte(data), &items); err != nil {
+		return nil, errors.New("batch manifest is not a JSON array of strings: " + err.Error())
+	}
+	if items == nil {
+		return nil, errors.New("batch manifest must be a JSON array")
+	}
+	if err := validateBatchItems(items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+/*
+create the config map receiving the batch manifest together with a service account that may write (only) this config
+map, all are owned by the pipeline run
+*/
+func (r *PipelineRunReconciler) createManifestAccess(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, name string, labels map[string]string) error {
+	objectMeta := func() metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: name, Namespace: pr.Namespace, Labels: labels}
+	}
+	cm := &corev1.ConfigMap{ObjectMeta: objectMeta()}
+	sa := &corev1.ServiceAccount{ObjectMeta: objectMeta()}
+	role := &rbacv1.Role{
+		ObjectMeta: objectMeta(),
+		Rules: []rbacv1.PolicyRule{{
+			APIGroups:     []string{""},
+			Resources:     []string{"configmaps"},
+			ResourceNames: []string{name},
+			Verbs:         []string{"get", "patch"},
+		}},
+	}
+	binding := &rbacv1.RoleBinding{
+		ObjectMeta: objectMeta(),
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: name},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: name, Namespace: pr.Namespace}},
+	}
+	for _, obj := range []struct {
+		obj   client.Object
+		empty client.Object
+	}{
+		{cm, &corev1.ConfigMap{}},
+		{sa, &corev1.ServiceAccount{}},
+		{role, &rbacv1.Role{}},
+		{binding, &rbacv1.RoleBinding{}},
+	} {
+		if err := ctrl.SetControllerReference(pr, obj.obj, r.Scheme); err != nil {
+			return err
+		}
+		if err := CreateOrUpdate(r, r, ctx, log, obj.obj, obj.empty); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+create Job that reads the batch manifest of a sub-pipeline and writes it to the config map named like the job (the
+termination message of a pod would be truncated at 4096 bytes)
+*/
+func (r *PipelineRunReconciler) CreateManifestJob(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, sp *pipelinev1.SubPipelineSpec) error {
+	var manifest *pipelinev1.PipeBinding
+	for _, pipe := range pr.Status.PipelineStructure.Pipes {
+		if (pipe.To.StepId == sp.Id) && (pipe.To.Name == manifestPipe(sp)) {
+			binding, err := r.resolvePipeSource(ctx, pr, pipe.From)
+			if err != nil {
+				return err
+			}
+			manifest = binding
+		}
+	}
+	if manifest == nil {
+		return errors.New("batched sub-pipeline " + sp.Id + " has no input pipe " + manifestPipe(sp))
+	}
+
+	in, err := toInputPipe(manifest, manifest.SourceFile)
+	if err != nil {
+		return err
+	}
+
+	jobName := r.constructManifestJobName(pr, sp.Id)
+	manifestFile := manifestMountPath + "/" + manifest.SourceFile
+	volumes := []corev1.Volume{getInputVolume(in)}
+	volumeMounts := []corev1.VolumeMount{getVolumeMount(inputVolumeName(in), manifestMountPath)}
+	var initContainers []corev1.Container
+	if in.VolumeType == VolumeTypeArtifact {
+		// the manifest is downloaded from the artifact store
+		manifestFile = manifestMountPath + "/" + ManifestKey
+		volumes = []corev1.Volume{{Name: "manifest", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}}
+		volumeMounts = []corev1.VolumeMount{getVolumeMount("manifest", manifestMountPath)}
+		download, err := transferContainer("download", pr.Spec.ArtifactStore, []string{"aws s3 cp " + shellQuote(in.Volume+"/"+in.SourceFile) + " " + shellQuote(manifestFile)}, volumeMounts)
+		if err != nil {
+			return err
+		}
+		initContainers = append(initContainers, download)
+	}
+	container := corev1.Container{
+		Name:    "main",
+		Image:   currentConfig().KubectlImage,
+		Command: []string{currentConfig().Shell},
+		Args: []string{"-c", "kubectl create configmap " + jobName + " --from-file=" + ManifestKey + "=" + shellQuote(manifestFile) +
+			" --dry-run=client -o yaml | kubectl apply -f -"},
+		VolumeMounts:    volumeMounts,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+	}
+
+	// the labels to be attached to job
+	jobLabels := currentConfig().resourceLabels("BatchManifest", jobName)
+	if err := r.createManifestAccess(ctx, log, pr, jobName, jobLabels); err != nil {
+		return err
+	}
+	var backoffLimit int32 = 2
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jobName,
+			Namespace: pr.Namespace,
+			Labels:    jobLabels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: jobLabels,
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy:      corev1.RestartPolicyNever,
+					ServiceAccountName: jobName,
+					Volumes:            volumes,
+					InitContainers:     initContainers,
+					Containers:         []corev1.Container{container},
+				},
+			},
+		},
+	}
+	if err := ctrl.SetControllerReference(pr, job, r.Scheme); err != nil {
+		return err
+	}
+
+	log("Creating a new manifest Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+	return CreateOrUpdate(r, r, ctx, log, job, &batchv1.Job{})
+}
+
+// Reads the batch items from the config map written by the manifest job. Returns nil items if the job does not
+// exist or has not completed, yet. If the manifest can not be read, the reason is returned as message (the error is
+// only set in case of failures when accessing the api server).
+func (r *PipelineRunReconciler) readManifest(ctx context.Context, pr *pipelinev1.PipelineRun, jobName string) ([]string, string, error) {
+	job := &batchv1.Job{}
+	notExists, err := NotExistsResource(r, ctx, job, types.NamespacedName{Namespace: pr.Namespace, Name: jobName})
+	if err != nil {
+		return nil, "", err
+	}
+	if notExists {
+		return nil, "", nil
+	}
+	if isTrueInJob(job, batchv1.JobFailed) {
+		return nil, "manifest job " + jobName + " failed", nil
+	}
+	if !isTrueInJob(job, batchv1.JobComplete) {
+		return nil, "", nil
+	}
+	cm := &corev1.ConfigMap{}
+	notExists, err = NotExistsResource(r, ctx, cm, types.NamespacedName{Namespace: pr.Namespace, Name: jobName})
+	if err != nil {
+		return nil, "", err
+	}
+	data, found := cm.Data[ManifestKey]
+	if notExists || !found {
+		return nil, "manifest job " + jobName + " did not write the batch manifest", nil
+	}
+	items, err := parseBatchManifest(data)
+	if err != nil {
+		return nil, err.Error(), nil
+	}
+	return items, "", nil
+}
+
+// create the child runs of a batched sub-pipeline that do not exist, yet, returns true if any were created
+func (r *PipelineRunReconciler) createBatchRuns(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, sp *pipelinev1.SubPipelineSpec, bs *pipelinev1.BatchStatus) (bool, error) {
+	created := false
+	for i, item := range bs.Items {
+		name := r.constructBatchRunName(pr, sp.Id, i)
+		child, err := r.GetPipelineRun(ctx, types.NamespacedName{Namespace: subPipelineNamespace(pr, sp), Name: name})
+		if err != nil {
+			return created, err
+		}
+		if child != nil {
+			continue
+		}
+		// each child gets its own slice (sub-directory) of all inputs except the manifest
+		var bindings []pipelinev1.PipeBinding
+		for _, pipe := range pr.Status.PipelineStructure.Pipes {
+			if (pipe.To.StepId != sp.Id) || (pipe.To.Name == manifestPipe(sp)) {
+				continue
+			}
+			if subPipelineNamespace(pr, sp) != pr.Namespace {
+				return created, errors.New("pipes into steps in namespace " + subPipelineNamespace(pr, sp) + " are not supported")
+			}
+			binding, err := r.resolvePipeSource(ctx, pr, pipe.From)
+			if err != nil {
+				return created, err
+			}
+			binding.Name = pipe.To.Name
+			binding.SourceFile = binding.SourceFile + "/" + item
+			bindings = append(bindings, *binding)
+		}
+		if err := r.createChildRun(ctx, log, pr, sp, name, bindings, strconv.Itoa(i)); err != nil {
+			return created, err
+		}
+		created = true
+	}
+	return created, nil
+}
+
+// count the child runs of a batched sub-pipeline that have succeeded or failed
+func (r *PipelineRunReconciler) countBatchRuns(ctx context.Context, pr *pipelinev1.PipelineRun, sp *pipelinev1.SubPipelineSpec, bs *pipelinev1.BatchStatus) (int, int, error) {
+	succeeded := 0
+	failed := 0
+	for i := range bs.Items {
+		child, err := r.GetPipelineRun(ctx, types.NamespacedName{Namespace: subPipelineNamespace(pr, sp), Name: r.constructBatchRunName(pr, sp.Id, i)})
+		if err != nil {
+			return 0, 0, err
+		}
+		if (child == nil) || (child.Status.State == nil) {
+			continue
+		}
+		if hasErrorState(child) {
+			// the child run can not continue
+			failed++
+			continue
+		}
+		switch *child.Status.State {
+		case Succeeded:
+			succeeded++
+		case Failed, NoMatchingVersion, Terminated:
+			failed++
+		}
+	}
+	return succeeded, failed, nil
+}
+
+// read manifests, start child runs and aggregate their results for all active batched sub-pipelines
+func (r *PipelineRunReconciler) updateBatchedSubPipelines(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun) (*ctrl.Result, error) {
+	for _, sp := range pr.Status.PipelineStructure.SubPipelines {
+		if !isBatched(sp) || !isActive(pr, sp.Id) || hasSucceeded(pr, sp.Id) || hasFailed(pr, sp.Id) {
+			continue
+		}
+		bs := findBatchStatus(pr, sp.Id)
+		if bs == nil {
+			// manifest not read, yet
+			jobName := r.constructManifestJobName(pr, sp.Id)
+			notExists, err := NotExistsResource(r, ctx, &batchv1.Job{}, types.NamespacedName{Namespace: pr.Namespace, Name: jobName})
+			if err != nil {
+				result := r.failed(ctx, "Failed to get manifest Job", err, pr, r.Recorder)
+				return &result, err
+			}
+			if notExists {
+				if err := r.CreateManifestJob(ctx, log, pr, sp); err != nil {
+					result := r.failed(ctx, "Failed to create manifest Job for sub-pipeline "+sp.Id, err, pr, r.Recorder)
+					return &result, err
+				}
+				r.Recorder.Event(pr, "Normal", "PipelineExecution", "Created manifest Job: "+sp.Id)
+				return &ctrl.Result{}, nil
+			}
+			items, reason, err := r.readManifest(ctx, pr, jobName)
+			if err != nil {
+				result := r.failed(ctx, "Failed to read batch manifest", err, pr, r.Recorder)
+				return &result, err
+			}
+			if len(reason) > 0 {
+				if err := r.SetPipelineRunStatus(ctx, log, pr, StepStatus(sp.Id), metav1.ConditionFalse, reason); err != nil {
+					result := r.failed(ctx, "Failed to set PipelineRun status", err, pr, r.Recorder)
+					return &result, err
+				}
+				r.Recorder.Event(pr, "Warning", "PipelineExecution", "Batched sub-pipeline "+sp.Id+" failed: "+reason)
+				return &ctrl.Result{}, nil
+			}
+			if items == nil {
+				// manifest job still running
+				continue
+			}
+			pr.Status.Batches = append(pr.Status.Batches, pipelinev1.BatchStatus{StepId: sp.Id, Items: items})
+			if err := r.Status().Update(ctx, pr); err != nil {
+				result := r.failed(ctx, "Failed to store batch items", err, pr, r.Recorder)
+				return &result, err
+			}
+			if err := r.deleteManifestJob(ctx, log, pr, jobName); err != nil {
+				result := r.failed(ctx, "Failed to delete manifest Job", err, pr, r.Recorder)
+				return &result, err
+			}
+			r.Recorder.Event(pr, "Normal", "PipelineExecution", fmt.Sprintf("Read batch manifest of %s: %d items", sp.Id, len(items)))
+			// changes to state have been made, return empty result to stop current reconciliation iteration
+			return &ctrl.Result{}, nil
+		}
+
+		// start missing child runs
+		created, err := r.createBatchRuns(ctx, log, pr, sp, bs)
+		if err != nil {
+			result := r.failed(ctx, "Failed to create PipelineRuns for batched sub-pipeline "+sp.Id, err, pr, r.Recorder)
+			return &result, err
+		}
+		if created {
+			r.Recorder.Event(pr, "Normal", "PipelineExecution", "Created batch PipelineRuns: "+sp.Id)
+			return &ctrl.Result{}, nil
+		}
+
+		// aggregate results
+		succeeded, failed, err := r.countBatchRuns(ctx, pr, sp, bs)
+		if err != nil {
+			result := r.failed(ctx, "Failed to get batch PipelineRuns", err, pr, r.Recorder)
+			return &result, err
+		}
+		if (succeeded != bs.NumSucceeded) || (failed != bs.NumFailed) {
+			bs.NumSucceeded = succeeded
+			bs.NumFailed = failed
+			if err := r.Status().Update(ctx, pr); err != nil {
+				result := r.failed(ctx, "Failed to update batch status", err, pr, r.Recorder)
+				return &result, err
+			}
+			return &ctrl.Result{}, nil
+		}
+		if succeeded+failed == len(bs.Items) {
+			message := fmt.Sprintf("Batches terminated: %d succeeded, %d failed (tolerated: %d%%)", succeeded, failed, maxFailedPercentage(sp))
+			status := metav1.ConditionTrue
+			if failed*100 > maxFailedPercentage(sp)*len(bs.Items) {
+				status = metav1.ConditionFalse
+			}
+			if err := r.SetPipelineRunStatus(ctx, log, pr, StepStatus(sp.Id), status, message); err != nil {
+				result := r.failed(ctx, "Failed to set PipelineRun status", err, pr, r.Recorder)
+				return &result, err
+			}
+			r.Recorder.Event(pr, "Normal", "PipelineExecution", sp.Id+": "+message)
+			return &ctrl.Result{}, nil
+		}
+	}
+
+	// return nil result to indicate that reconciliation can proceed
+	return nil, nil
+}
+
+// delete the manifest job together with its config map and service account
+func (r *PipelineRunReconciler) deleteManifestJob(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, jobName string) error {
+	for _, obj := range []client.Object{&batchv1.Job{}, &corev1.ConfigMap{}, &rbacv1.RoleBinding{}, &rbacv1.Role{}, &corev1.ServiceAccount{}} {
+		notExists, err := NotExistsResource(r, ctx, obj, types.NamespacedName{Namespace: pr.Namespace, Name: jobName})
+		if err != nil {
+			return err
+		}
+		if notExists {
+			continue
+		}
+		log("Deleting manifest resource", "Namespace", pr.Namespace, "Name", jobName, "Kind", fmt.Sprintf("%T", obj))
+		if err := r.Delete(ctx, obj, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Batch manifest", func() {
+	It("should accept plain directory names as batch items", func() {
+		Expect(validateBatchItems([]string{"a", "shard-0001", "x.y", "..a"})).To(Succeed())
+		Expect(validateBatchItems([]string{})).To(Succeed())
+	})
+
+	It("should reject batch items escaping the input directories", func() {
+		for _, item := range []string{"", ".", "..", "../other", "a/b", "/abs"} {
+			Expect(validateBatchItems([]string{"ok", item})).NotTo(Succeed(), item)
+		}
+	})
+
+	It("should parse the batch manifest", func() {
+		Expect(parseBatchManifest(`["a","b"]`)).To(Equal([]string{"a", "b"}))
+		items, err := parseBatchManifest(`[]`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(items).NotTo(BeNil())
+		for _, data := range []string{`null`, `{"a":1}`, `["a/b"]`, ``} {
+			_, err := parseBatchManifest(data)
+			Expect(err).To(HaveOccurred(), data)
+		}
+	})
+})
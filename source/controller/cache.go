@@ -0,0 +1,343 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sort"
+	"time"
+)
+
+const (
+	// name of the config map holding the index of cached step outputs of a namespace
+	StepCacheConfigMap = "pipeline-step-cache"
+	// annotation of the cache config map that sets the time to live of cache entries (a go duration, e.g. "72h")
+	CacheTTLAnnotation = "k-pipe.cloud/cache-ttl"
+	DefaultCacheTTL    = 7 * 24 * time.Hour
+
+	// reason of the success condition of steps whose output was taken from the cache
+	CacheHitReason = "CacheHit"
+
+	CACHED_STATUS_PREFIX = "cached-"
+)
+
+// an entry of the cache index
+type cacheEntry struct {
+	Volume  string    `json:"volume"`
+	Run     string    `json:"run"`
+	StepId  string    `json:"stepId"`
+	Created time.Time `json:"created"`
+	// the outputs reported by the step, restored on cache hits (so that when clauses can read them)
+	Outputs json.RawMessage `json:"outputs,omitempty"`
+}
+
+func CachedStatus(stepId string) string {
+	return CACHED_STATUS_PREFIX + stepId
+}
+
+// name of the volume holding a cached step output
+func cacheVolumeName(key string) string {
+	return "step-cache-" + key[:20]
+}
+
+/*
+compute the cache key of a step from the digest of its image, command, arguments, config and the identities of its
+inputs (the hash of the contents of its input files and its literal environment variables)
+*/
+func cacheKey(image string, command []string, args []string, config []byte, inputs []string) string {
+	sorted := append([]string{}, inputs...)
+	sort.Strings(sorted)
+	h := sha256.New()
+	for _, part := range [][]string{{image}, command, args, {string(config)}, sorted} {
+		for _, s := range part {
+			h.Write([]byte(s))
+			h.Write([]byte{0})
+		}
+		h.Write([]byte{1})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// compute the cache key of a job step (the config is taken from the pipeline definition)
+func (r *PipelineRunReconciler) stepCacheKey(ctx context.Context, pr *pipelinev1.PipelineRun, step *pipelinev1.PipelineJobStepSpec, imageDigest string, inputHash string) (string, error) {
+	var config []byte
+	pd, err := GetPipelineDefinition(r, ctx, types.NamespacedName{Name: getPipelineId(*pr), Namespace: pr.Namespace})
+	if err != nil {
+		return "", err
+	}
+	if pd != nil {
+		for _, s := range pd.Spec.PipelineStructure.JobSteps {
+			if s.Id == step.Id {
+				config = s.Config
+			}
+		}
+	}
+	js := step.JobSpec
+	inputs := []string{"inputs=" + inputHash}
+	// literal environment variables are treated like inputs (values taken from config maps or secrets are not)
+	for _, env := range js.Env {
+		if env.ValueFrom == nil {
+			inputs = append(inputs, "env:"+env.Name+"="+env.Value)
+		}
+	}
+	return cacheKey(imageDigest, js.Command, js.Args, config, inputs), nil
+}
+
+/*
+make sure the hash of the contents of the input files of a step is known (it is computed by a job), returns false while
+it is being computed. The hash is empty if it could not be computed, the step is not cached then.
+*/
+func (r *PipelineRunReconciler) ensureInputHash(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, stepId string) (bool, error) {
+	if _, found := pr.Status.InputHashes[stepId]; found {
+		return true, nil
+	}
+	notExists, err := NotExistsResource(r, ctx, &batchv1.Job{}, types.NamespacedName{Namespace: pr.Namespace, Name: r.constructHashJobName(pr, stepId)})
+	if err != nil {
+		return false, err
+	}
+	if notExists {
+		return false, r.CreateHashJob(ctx, log, pr, stepId)
+	}
+	hash, reason, err := r.readInputHash(ctx, log, pr, stepId)
+	if err != nil {
+		return false, err
+	}
+	if (len(hash) == 0) && (len(reason) == 0) {
+		// hash job still running
+		return false, nil
+	}
+	if len(reason) > 0 {
+		r.Recorder.Event(pr, "Warning", "PipelineExecution", "Step "+stepId+" is not cached: "+reason)
+	}
+	if pr.Status.InputHashes == nil {
+		pr.Status.InputHashes = map[string]string{}
+	}
+	pr.Status.InputHashes[stepId] = hash
+	return true, r.Status().Update(ctx, pr)
+}
+
+// the cache index of the namespace of the run, returns nil if it does not exist, yet
+func (r *PipelineRunReconciler) getCacheIndex(ctx context.Context, namespace string) (*corev1.ConfigMap, error) {
+	res := &corev1.ConfigMap{}
+	notexists, err := NotExistsResource(r, ctx, res, types.NamespacedName{Namespace: namespace, Name: StepCacheConfigMap})
+	if notexists {
+		res = nil
+	}
+	return res, err
+}
+
+func cacheTTL(cm *corev1.ConfigMap) time.Duration {
+	if ttl, err := time.ParseDuration(cm.Annotations[CacheTTLAnnotation]); err == nil {
+		return ttl
+	}
+	return DefaultCacheTTL
+}
+
+// look up a cache entry, returns nil if there is none or it has expired
+func lookupCache(cm *corev1.ConfigMap, key string, now time.Time) *cacheEntry {
+	if cm == nil {
+		return nil
+	}
+	value, found := cm.Data[key]
+	if !found {
+		return nil
+	}
+	entry := &cacheEntry{}
+	if err := json.Unmarshal([]byte(value), entry); err != nil {
+		return nil
+	}
+	if now.Sub(entry.Created) > cacheTTL(cm) {
+		return nil
+	}
+	return entry
+}
+
+// remove the expired entries from the cache index (in memory) and delete their volumes, returns true if any were removed
+func (r *PipelineRunReconciler) evictExpired(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, cm *corev1.ConfigMap, now time.Time) (bool, error) {
+	evicted := false
+	for k := range cm.Data {
+		if lookupCache(cm, k, now) == nil {
+			log("Removing expired cache entry " + k)
+			if err := r.DeletePersistentVolumeClaim(ctx, log, pr, cacheVolumeName(k)); err != nil {
+				return evicted, err
+			}
+			delete(cm.Data, k)
+			evicted = true
+		}
+	}
+	return evicted, nil
+}
+
+/*
+take the output of a step from the cache if possible, returns true if the step was completed this way (the key is
+stored in the run status in any case). The second result is false while the inputs of the step are being hashed.
+*/
+func (r *PipelineRunReconciler) startFromCache(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, step *pipelinev1.PipelineJobStepSpec) (bool, bool, error) {
+	hashed, err := r.ensureInputHash(ctx, log, pr, step.Id)
+	if err != nil || !hashed {
+		return false, hashed, err
+	}
+	inputHash := pr.Status.InputHashes[step.Id]
+	if len(inputHash) == 0 {
+		log("Step " + step.Id + " is not cached, its inputs could not be hashed")
+		return false, true, nil
+	}
+	// the tag of an image may be moved, the key refers to the image it currently points to
+	imageDigest, err := resolveImageDigest(ctx, step.JobSpec.Image)
+	if err != nil {
+		log("Step " + step.Id + " is not cached, the digest of its image could not be resolved: " + err.Error())
+		r.Recorder.Event(pr, "Warning", "PipelineExecution", "Step "+step.Id+" is not cached: the digest of image "+step.JobSpec.Image+" could not be resolved")
+		return false, true, nil
+	}
+	key, err := r.stepCacheKey(ctx, pr, step, imageDigest, inputHash)
+	if err != nil {
+		return false, true, err
+	}
+	if pr.Status.StepCacheKeys == nil {
+		pr.Status.StepCacheKeys = map[string]string{}
+	}
+	pr.Status.StepCacheKeys[step.Id] = key
+	cm, err := r.getCacheIndex(ctx, pr.Namespace)
+	if err != nil {
+		return false, true, err
+	}
+	now := time.Now()
+	if cm != nil {
+		evicted, err := r.evictExpired(ctx, log, pr, cm, now)
+		if err != nil {
+			return false, true, err
+		}
+		if evicted {
+			if err := r.Update(ctx, cm); err != nil {
+				return false, true, err
+			}
+		}
+	}
+	entry := lookupCache(cm, key, now)
+	var source *corev1.PersistentVolumeClaim
+	if entry != nil {
+		if source, err = r.GetPersistentVolumeClaim(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: entry.Volume}); err != nil {
+			return false, true, err
+		}
+	}
+	if source == nil {
+		log("Cache miss for step " + step.Id)
+		return false, true, r.Status().Update(ctx, pr)
+	}
+	log("Cache hit for step " + step.Id + " (from run " + entry.Run + ")")
+	if _, err := r.ClonePersistentVolumeClaim(ctx, log, pr, r.ConstructPipelineJobName(pr, step.Id), source, pvcDataSource(source)); err != nil {
+		return false, true, err
+	}
+	for _, condition := range []metav1.Condition{
+		{Type: PVCStatus(step.Id), Status: metav1.ConditionTrue, Reason: "Reconciling", Message: "pvc was cloned from " + source.Name},
+		{Type: CachedStatus(step.Id), Status: metav1.ConditionTrue, Reason: "Reconciling", Message: "Output taken from cache"},
+		{Type: StepStatus(step.Id), Status: metav1.ConditionTrue, Reason: CacheHitReason, Message: "Output taken from cache (run " + entry.Run + ")"},
+	} {
+		meta.SetStatusCondition(&pr.Status.Conditions, condition)
+	}
+	if entry.Outputs != nil {
+		setStepOutputs(pr, step.Id, entry.Outputs)
+	}
+	pr.Status.CacheHits = append(pr.Status.CacheHits, step.Id)
+	return true, true, r.Status().Update(ctx, pr)
+}
+
+// store the outputs of succeeded steps with caching enabled in the cache (before their volumes are deleted)
+func (r *PipelineRunReconciler) storeCachedOutputs(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun) (*ctrl.Result, error) {
+	for _, step := range pr.Status.PipelineStructure.JobSteps {
+		key, found := pr.Status.StepCacheKeys[step.Id]
+		if !step.Cache || !found || !hasSucceeded(pr, step.Id) || !isPVCActive(pr, step.Id) || isTrue(pr, CachedStatus(step.Id)) || isFalse(pr, CachedStatus(step.Id)) {
+			continue
+		}
+		reason, err := r.storeInCache(ctx, log, pr, step.Id, key)
+		if err != nil {
+			result := r.failed(ctx, "Failed to store output of step "+step.Id+" in cache", err, pr, r.Recorder)
+			return &result, err
+		}
+		if len(reason) > 0 {
+			// not retried, the step is simply not cached
+			if err := r.SetPipelineRunStatus(ctx, log, pr, CachedStatus(step.Id), metav1.ConditionFalse, "Output not stored in cache: "+reason); err != nil {
+				result := r.failed(ctx, "Failed to set PipelineRun status", err, pr, r.Recorder)
+				return &result, err
+			}
+			r.Recorder.Event(pr, "Warning", "PipelineExecution", "Output of step "+step.Id+" not stored in cache: "+reason)
+			return &ctrl.Result{}, nil
+		}
+		if err := r.SetPipelineRunStatus(ctx, log, pr, CachedStatus(step.Id), metav1.ConditionTrue, "Output stored in cache"); err != nil {
+			result := r.failed(ctx, "Failed to set PipelineRun status", err, pr, r.Recorder)
+			return &result, err
+		}
+		r.Recorder.Event(pr, "Normal", "PipelineExecution", "Stored output of step "+step.Id+" in cache")
+		// changes to state have been made, return empty result to stop current reconciliation iteration
+		return &ctrl.Result{}, nil
+	}
+	// return nil result to indicate that reconciliation can proceed
+	return nil, nil
+}
+
+/*
+clone the output volume of a step into a cache volume and register it in the cache index, expired entries are removed.
+If the output can not be stored, the reason is returned as message (the error is only set in case of failures when
+accessing the api server).
+*/
+func (r *PipelineRunReconciler) storeInCache(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, stepId string, key string) (string, error) {
+	volumeName := r.ConstructPipelineJobName(pr, stepId)
+	source, err := r.GetPersistentVolumeClaim(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: volumeName})
+	if err != nil {
+		return "", err
+	}
+	if source == nil {
+		return "output volume " + volumeName + " not found", nil
+	}
+	cm, err := r.getCacheIndex(ctx, pr.Namespace)
+	if err != nil {
+		return "", err
+	}
+	if cm == nil {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      StepCacheConfigMap,
+				Namespace: pr.Namespace,
+				Labels:    currentConfig().resourceLabels("Pipeline-StepCache", StepCacheConfigMap),
+			},
+		}
+		log("Creating the step cache index", "ConfigMap.Namespace", cm.Namespace, "ConfigMap.Name", cm.Name)
+		if err := r.Create(ctx, cm); err != nil {
+			return "", err
+		}
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	now := time.Now()
+	if _, err := r.evictExpired(ctx, log, pr, cm, now); err != nil {
+		return "", err
+	}
+	pvc, err := r.ClonePersistentVolumeClaim(ctx, log, pr, cacheVolumeName(key), source, pvcDataSource(source))
+	if err != nil {
+		return "", err
+	}
+	// the cached volume lives as long as the cache index, not as long as the run
+	pvc.OwnerReferences = nil
+	if err := ctrl.SetControllerReference(cm, pvc, r.Scheme); err != nil {
+		return "", err
+	}
+	if err := r.Update(ctx, pvc); err != nil {
+		return "", err
+	}
+	entry, err := json.Marshal(cacheEntry{Volume: pvc.Name, Run: pr.Name, StepId: stepId, Created: now.UTC(), Outputs: findStepOutputs(pr, stepId)})
+	if err != nil {
+		return "", err
+	}
+	cm.Data[key] = string(entry)
+	log("Adding cache entry " + key + " for step " + stepId)
+	return "", r.Update(ctx, cm)
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+)
+
+var _ = Describe("Step cache", func() {
+	key := func(image string, args []string, config string, inputs ...string) string {
+		return cacheKey(image, []string{"run"}, args, []byte(config), inputs)
+	}
+
+	It("should not depend on the order of the inputs", func() {
+		Expect(key("img", nil, "{}", "a=x", "b=y")).To(Equal(key("img", nil, "{}", "b=y", "a=x")))
+	})
+
+	It("should change with image, arguments, config and inputs", func() {
+		base := key("img", []string{"x"}, "{}", "a=x")
+		Expect(key("img2", []string{"x"}, "{}", "a=x")).NotTo(Equal(base))
+		Expect(key("img", []string{"y"}, "{}", "a=x")).NotTo(Equal(base))
+		Expect(key("img", []string{"x"}, `{"p":1}`, "a=x")).NotTo(Equal(base))
+		Expect(key("img", []string{"x"}, "{}", "a=z")).NotTo(Equal(base))
+	})
+
+	It("should not confuse arguments with the image", func() {
+		Expect(key("img", []string{"x"}, "")).NotTo(Equal(key("imgx", nil, "")))
+	})
+
+	It("should take the digest of images pinned by digest without registry lookup", func() {
+		digest := "sha256:" + strings.Repeat("ab", 32)
+		Expect(resolveImageDigest(context.Background(), "registry.example.com/team/tool:1.0@"+digest)).To(Equal("registry.example.com/team/tool@" + digest))
+		_, err := resolveImageDigest(context.Background(), "Invalid Image")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should ignore expired entries", func() {
+		now := time.Now()
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{CacheTTLAnnotation: "1h"}},
+			Data: map[string]string{
+				"fresh": `{"volume":"v1","run":"r1","stepId":"s","created":"` + now.Add(-30*time.Minute).UTC().Format(time.RFC3339) + `"}`,
+				"old":   `{"volume":"v2","run":"r2","stepId":"s","created":"` + now.Add(-2*time.Hour).UTC().Format(time.RFC3339) + `"}`,
+			},
+		}
+		Expect(lookupCache(cm, "fresh", now)).NotTo(BeNil())
+		Expect(lookupCache(cm, "fresh", now).Volume).To(Equal("v1"))
+		Expect(lookupCache(cm, "old", now)).To(BeNil())
+		Expect(lookupCache(cm, "missing", now)).To(BeNil())
+		Expect(lookupCache(nil, "fresh", now)).To(BeNil())
+	})
+
+	It("should keep the outputs of the step in the entry", func() {
+		cm := &corev1.ConfigMap{Data: map[string]string{
+			"k": `{"volume":"v","run":"r","stepId":"s","created":"` + time.Now().UTC().Format(time.RFC3339) + `","outputs":{"count":3}}`,
+		}}
+		Expect(string(lookupCache(cm, "k", time.Now()).Outputs)).To(Equal(`{"count":3}`))
+	})
+
+	It("should not store the output of a step whose volume is gone", func() {
+		testScheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(testScheme)).To(Succeed())
+		Expect(pipelinev1.AddToScheme(testScheme)).To(Succeed())
+		r := &PipelineRunReconciler{Client: fake.NewClientBuilder().WithScheme(testScheme).Build(), Scheme: testScheme}
+		pr := &pipelinev1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Name: "run", Namespace: "default"}}
+		log := func(string, ...interface{}) {}
+		reason, err := r.storeInCache(context.Background(), log, pr, "a", "key")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reason).To(ContainSubstring("output volume run-a not found"))
+		cm, err := r.getCacheIndex(context.Background(), "default")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cm).To(BeNil())
+	})
+})
@@ -25,13 +25,7 @@ create ConfigMap
 func (r *PipelineDefinitionReconciler) CreateConfigMap(ctx context.Context, log func(string, ...interface{}), pd *pipelinev1.PipelineDefinition, configmapName string, data map[string]string) (*corev1.ConfigMap, error) {
 
 	// the labels to be attached to pvc
-	labels := map[string]string{
-		"app.kubernetes.io/name":       "Pipeline-ConfigMap",
-		"app.kubernetes.io/instance":   configmapName,
-		"app.kubernetes.io/version":    "v1",
-		"app.kubernetes.io/part-of":    "pipeline-operator",
-		"app.kubernetes.io/created-by": "controller-manager", // TODO should we change this?
-	}
+	labels := currentConfig().resourceLabels("Pipeline-ConfigMap", configmapName)
 
 	immutable := false
 	cm := corev1.ConfigMap{
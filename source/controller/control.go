@@ -0,0 +1,239 @@
+package controller
+
+import (
+	"context"
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strconv"
+)
+
+const (
+	// desired states of a pipeline run
+	ControlRunning    string = "Running"
+	ControlPaused     string = "Paused"
+	ControlTerminated string = "Terminated"
+
+	CANCELLED_STATUS_PREFIX = "cancelled-"
+)
+
+func CancelledStatus(stepId string) string {
+	return CANCELLED_STATUS_PREFIX + stepId
+}
+
+func isCancelled(pr *pipelinev1.PipelineRun, stepId string) bool {
+	return isTrue(pr, CancelledStatus(stepId))
+}
+
+// the desired state of the run, defaults to running
+func control(pr *pipelinev1.PipelineRun) string {
+	if len(pr.Spec.Control) == 0 {
+		return ControlRunning
+	}
+	return pr.Spec.Control
+}
+
+// align the conditions Paused and Terminated with the desired state of the run
+func (r *PipelineRunReconciler) applyControl(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun) (*ctrl.Result, error) {
+	if isTerminal(pr) {
+		// runs that have ended (including terminated ones) can not be controlled any more
+		return nil, nil
+	}
+	switch control(pr) {
+	case ControlTerminated:
+		return r.terminate(ctx, log, pr)
+	case ControlPaused:
+		if !isTrue(pr, Paused) {
+			return r.setPaused(ctx, log, pr, v1.ConditionTrue, "Run was paused")
+		}
+	case ControlRunning:
+		if isTrue(pr, Paused) {
+			return r.setPaused(ctx, log, pr, v1.ConditionFalse, "Run was resumed")
+		}
+	}
+	// return nil result to indicate that reconciliation can proceed
+	return nil, nil
+}
+
+func (r *PipelineRunReconciler) setPaused(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, status v1.ConditionStatus, message string) (*ctrl.Result, error) {
+	// first pass the control on to child runs
+	if err := r.controlChildRuns(ctx, log, pr, control(pr)); err != nil {
+		result := r.failed(ctx, "Failed to control child PipelineRuns", err, pr, r.Recorder)
+		return &result, err
+	}
+	state := ControlPaused
+	if status == v1.ConditionFalse {
+		state = ControlRunning
+	}
+	pr.Status.State = &state
+	if err := r.SetPipelineRunStatus(ctx, log, pr, Paused, status, message); err != nil {
+		result := r.failed(ctx, "Failed to set PipelineRun status", err, pr, r.Recorder)
+		return &result, err
+	}
+	r.Recorder.Event(pr, "Normal", "PipelineExecution", message)
+	// changes to state have been made, return empty result to stop current reconciliation iteration
+	return &ctrl.Result{}, nil
+}
+
+// cancel all active steps and mark the run as terminated
+func (r *PipelineRunReconciler) terminate(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun) (*ctrl.Result, error) {
+	if err := r.controlChildRuns(ctx, log, pr, ControlTerminated); err != nil {
+		result := r.failed(ctx, "Failed to terminate child PipelineRuns", err, pr, r.Recorder)
+		return &result, err
+	}
+	for _, stepId := range allStepIds(pr.Status.PipelineStructure) {
+		if !meta.IsStatusConditionPresentAndEqual(pr.Status.Conditions, StepStatus(stepId), v1.ConditionUnknown) {
+			continue
+		}
+		if findSubPipeline(pr, stepId) == nil {
+			if err := r.deleteStepJobs(ctx, log, pr, r.ConstructPipelineJobName(pr, stepId)); err != nil {
+				result := r.failed(ctx, "Failed to delete Jobs of step "+stepId, err, pr, r.Recorder)
+				return &result, err
+			}
+		}
+	}
+	// a terminated child run counts as failed step in its parent
+	if err := r.updateParentRun(ctx, log, pr, v1.ConditionFalse, "Sub-pipeline was terminated"); err != nil {
+		result := r.failed(ctx, "Failed to update parent PipelineRun", err, pr, r.Recorder)
+		return &result, err
+	}
+	// results of steps may be reported concurrently, re-read the run on conflicts so that no cancellation gets lost
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &pipelinev1.PipelineRun{}
+		if err := r.Get(ctx, NameSpacedName(pr), latest); err != nil {
+			return err
+		}
+		markTerminated(latest)
+		if err := r.Status().Update(ctx, latest); err != nil {
+			return err
+		}
+		*pr = *latest
+		return nil
+	})
+	if err != nil {
+		result := r.failed(ctx, "Failed to set PipelineRun status", err, pr, r.Recorder)
+		return &result, err
+	}
+	log("Run was terminated", "PipelineRun.Namespace", pr.Namespace, "PipelineRun.Name", pr.Name)
+	r.Recorder.Event(pr, "Normal", "PipelineRunTerminated", "Run was terminated")
+	// changes to state have been made, return empty result to stop current reconciliation iteration
+	return &ctrl.Result{}, nil
+}
+
+// cancel the active steps of a run and set its state to terminated (in memory)
+func markTerminated(pr *pipelinev1.PipelineRun) {
+	for _, stepId := range allStepIds(pr.Status.PipelineStructure) {
+		if !meta.IsStatusConditionPresentAndEqual(pr.Status.Conditions, StepStatus(stepId), v1.ConditionUnknown) {
+			continue
+		}
+		// replace the success condition of the step, cancelled steps count neither as active nor as failed
+		meta.RemoveStatusCondition(&pr.Status.Conditions, StepStatus(stepId))
+		meta.SetStatusCondition(&pr.Status.Conditions, v1.Condition{
+			Type:    CancelledStatus(stepId),
+			Status:  v1.ConditionTrue,
+			Reason:  "Reconciling",
+			Message: "Step was cancelled",
+		})
+	}
+	state := Terminated
+	pr.Status.State = &state
+	now := v1.Now()
+	pr.Status.CompletionTime = &now
+	meta.SetStatusCondition(&pr.Status.Conditions, v1.Condition{
+		Type:    Terminated,
+		Status:  v1.ConditionTrue,
+		Reason:  "Reconciling",
+		Message: "Run was terminated",
+	})
+}
+
+/*
+delete the PipelineJob of a step together with the kubernetes jobs running its attempts (including their pods), the
+jobs of retries (<name>-<attempt>) are found by their owner reference
+*/
+func (r *PipelineRunReconciler) deleteStepJobs(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, jobName string) error {
+	pj, err := r.GetPipelineJob(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: jobName})
+	if err != nil {
+		return err
+	}
+	jobs := &batchv1.JobList{}
+	if err := r.List(ctx, jobs, client.InNamespace(pr.Namespace)); err != nil {
+		return err
+	}
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if (job.Name != jobName) && ((pj == nil) || !v1.IsControlledBy(job, pj)) {
+			continue
+		}
+		log("Deleting the Job", "Job.Namespace", pr.Namespace, "Job.Name", job.Name)
+		if err := r.Delete(ctx, job, client.PropagationPolicy(v1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return r.DeletePipelineJob(ctx, log, pr, jobName)
+}
+
+// the child runs of all sub-pipeline steps that have been started
+func (r *PipelineRunReconciler) childRuns(ctx context.Context, pr *pipelinev1.PipelineRun) ([]*pipelinev1.PipelineRun, error) {
+	var res []*pipelinev1.PipelineRun
+	for _, sp := range pr.Status.PipelineStructure.SubPipelines {
+		if !isActive(pr, sp.Id) {
+			continue
+		}
+		children, err := r.childRunsOfStep(ctx, pr, sp)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, children...)
+	}
+	return res, nil
+}
+
+// the existing child runs of a sub-pipeline step
+func (r *PipelineRunReconciler) childRunsOfStep(ctx context.Context, pr *pipelinev1.PipelineRun, sp *pipelinev1.SubPipelineSpec) ([]*pipelinev1.PipelineRun, error) {
+	var res []*pipelinev1.PipelineRun
+	var names []string
+	if isBatched(sp) {
+		if bs := findBatchStatus(pr, sp.Id); bs != nil {
+			for i := range bs.Items {
+				names = append(names, r.constructBatchRunName(pr, sp.Id, i))
+			}
+		}
+	} else {
+		names = append(names, r.ConstructPipelineJobName(pr, sp.Id))
+	}
+	for _, name := range names {
+		child, err := r.GetPipelineRun(ctx, types.NamespacedName{Namespace: subPipelineNamespace(pr, sp), Name: name})
+		if err != nil {
+			return nil, err
+		}
+		if child != nil {
+			res = append(res, child)
+		}
+	}
+	return res, nil
+}
+
+// pass the desired state on to all child runs
+func (r *PipelineRunReconciler) controlChildRuns(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, desired string) error {
+	children, err := r.childRuns(ctx, pr)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if control(child) == desired {
+			continue
+		}
+		log("Setting control of child run "+child.Name+" to "+desired, "children", strconv.Itoa(len(children)))
+		child.Spec.Control = desired
+		if err := r.Update(ctx, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
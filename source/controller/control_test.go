@@ -0,0 +1,47 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+)
+
+var _ = Describe("Terminating runs", func() {
+	It("should cancel only the steps that are still active", func() {
+		pr := &pipelinev1.PipelineRun{}
+		pr.Status.PipelineStructure = &pipelinev1.PipelineStructure{
+			JobSteps: []*pipelinev1.PipelineJobStepSpec{{Id: "a"}, {Id: "b"}, {Id: "c"}},
+		}
+		for stepId, status := range map[string]metav1.ConditionStatus{"a": metav1.ConditionTrue, "b": metav1.ConditionUnknown, "c": metav1.ConditionFalse} {
+			meta.SetStatusCondition(&pr.Status.Conditions, metav1.Condition{Type: StepStatus(stepId), Status: status, Reason: "Reconciling"})
+		}
+		markTerminated(pr)
+		Expect(hasSucceeded(pr, "a")).To(BeTrue())
+		Expect(isCancelled(pr, "a")).To(BeFalse())
+		Expect(meta.FindStatusCondition(pr.Status.Conditions, StepStatus("b"))).To(BeNil())
+		Expect(isCancelled(pr, "b")).To(BeTrue())
+		Expect(hasFailed(pr, "c")).To(BeTrue())
+		Expect(isTrue(pr, Terminated)).To(BeTrue())
+		Expect(*pr.Status.State).To(Equal(Terminated))
+		Expect(pr.Status.CompletionTime).NotTo(BeNil())
+	})
+})
@@ -0,0 +1,150 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	/*
+		methods of copying the output volumes of reused steps, configured by key reuseMethod of the storage config map:
+		Clone (default) requires a CSI driver that supports volume cloning, Snapshot requires VolumeSnapshot support
+		(the snapshot class is given by key volumeSnapshotClassName), Copy copies the files with a job
+	*/
+	ReuseMethodClone    = "Clone"
+	ReuseMethodSnapshot = "Snapshot"
+	ReuseMethodCopy     = "Copy"
+
+	copySourcePath = "/source"
+	copyTargetPath = "/target"
+)
+
+var volumeSnapshotKind = schema.GroupVersionKind{Group: "snapshot.storage.k8s.io", Version: "v1", Kind: "VolumeSnapshot"}
+
+// the method of copying output volumes configured in the storage config map (cm may be nil)
+func reuseMethod(cm *corev1.ConfigMap) (string, error) {
+	switch method := storageConfig(cm, "reuseMethod"); method {
+	case "":
+		return ReuseMethodClone, nil
+	case ReuseMethodClone, ReuseMethodSnapshot, ReuseMethodCopy:
+		return method, nil
+	default:
+		return "", fmt.Errorf("invalid value of reuseMethod in config map %s: %s (must be Clone, Snapshot or Copy)", StorageConfigMap, method)
+	}
+}
+
+func (r *PipelineRunReconciler) constructCopyJobName(pr *pipelinev1.PipelineRun, stepId string) string {
+	return r.ConstructPipelineJobName(pr, stepId) + "-copy"
+}
+
+/*
+create a VolumeSnapshot (named like the volume to be created from it) of an existing persistent volume claim, returns
+the data source for the new claim
+*/
+func (r *PipelineRunReconciler) CreateVolumeSnapshot(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, volumeName string, source *corev1.PersistentVolumeClaim, snapshotClassName string) (*corev1.TypedLocalObjectReference, error) {
+	snapshot := &unstructured.Unstructured{}
+	snapshot.SetGroupVersionKind(volumeSnapshotKind)
+	snapshot.SetName(volumeName)
+	snapshot.SetNamespace(pr.Namespace)
+	snapshot.SetLabels(currentConfig().resourceLabels("Pipeline-VolumeSnapshot", volumeName))
+	spec := map[string]interface{}{
+		"source": map[string]interface{}{"persistentVolumeClaimName": source.Name},
+	}
+	if len(snapshotClassName) > 0 {
+		spec["volumeSnapshotClassName"] = snapshotClassName
+	}
+	snapshot.Object["spec"] = spec
+	if err := ctrl.SetControllerReference(pr, snapshot, r.Scheme); err != nil {
+		return nil, err
+	}
+	empty := &unstructured.Unstructured{}
+	empty.SetGroupVersionKind(volumeSnapshotKind)
+	if err := CreateOrUpdate(r, r, ctx, log, snapshot, empty); err != nil {
+		return nil, err
+	}
+	apiGroup := volumeSnapshotKind.Group
+	return &corev1.TypedLocalObjectReference{APIGroup: &apiGroup, Kind: volumeSnapshotKind.Kind, Name: volumeName}, nil
+}
+
+/*
+create Job that copies the files of the output volume of a step of another run to the (empty) output volume of the step
+*/
+func (r *PipelineRunReconciler) CreateCopyJob(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, stepId string, source *corev1.PersistentVolumeClaim) error {
+	jobName := r.constructCopyJobName(pr, stepId)
+	target := r.ConstructPipelineJobName(pr, stepId)
+	// the labels to be attached to job
+	jobLabels := currentConfig().resourceLabels("CopyVolume", jobName)
+	var backoffLimit int32 = 2
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jobName,
+			Namespace: pr.Namespace,
+			Labels:    jobLabels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: jobLabels,
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Volumes:       []corev1.Volume{getVolume(source.Name, true), getVolume(target, false)},
+					Containers: []corev1.Container{{
+						Name:    "main",
+						Image:   currentConfig().InitImage,
+						Command: []string{currentConfig().Shell},
+						Args:    []string{"-c", "cp -a " + copySourcePath + "/. " + copyTargetPath + "/"},
+						VolumeMounts: []corev1.VolumeMount{
+							getVolumeMount(source.Name, copySourcePath),
+							getVolumeMount(target, copyTargetPath),
+						},
+						ImagePullPolicy: corev1.PullIfNotPresent,
+					}},
+				},
+			},
+		},
+	}
+	if err := ctrl.SetControllerReference(pr, job, r.Scheme); err != nil {
+		return err
+	}
+	log("Creating a new copy Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+	return CreateOrUpdate(r, r, ctx, log, job, &batchv1.Job{})
+}
+
+/*
+check if the copy job of a step has completed (it is deleted then). If it has failed, the reason is returned as message
+(the error is only set in case of failures when accessing the api server).
+*/
+func (r *PipelineRunReconciler) copyJobCompleted(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, stepId string) (bool, string, error) {
+	jobName := r.constructCopyJobName(pr, stepId)
+	job := &batchv1.Job{}
+	notExists, err := NotExistsResource(r, ctx, job, types.NamespacedName{Namespace: pr.Namespace, Name: jobName})
+	if err != nil {
+		return false, "", err
+	}
+	if notExists {
+		// the job has completed and was deleted in an earlier iteration
+		return true, "", nil
+	}
+	if isTrueInJob(job, batchv1.JobFailed) {
+		return false, "copy job " + jobName + " failed", nil
+	}
+	if !isTrueInJob(job, batchv1.JobComplete) {
+		return false, "", nil
+	}
+	log("Deleting the copy Job", "Job.Namespace", pr.Namespace, "Job.Name", jobName)
+	if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+		return false, "", err
+	}
+	return true, "", nil
+}
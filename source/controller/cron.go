@@ -0,0 +1,182 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	// embed time zone database, the controller image may not provide one
+	_ "time/tzdata"
+)
+
+// CronSchedule is a parsed cron expression with the standard five fields (minute, hour, day of month, month, day of
+// week) or one of the shortcuts @yearly, @annually, @monthly, @weekly, @daily, @midnight and @hourly
+type CronSchedule struct {
+	minute     uint64
+	hour       uint64
+	dayOfMonth uint64
+	month      uint64
+	dayOfWeek  uint64
+	// day of month and day of week are combined with "or" if both are restricted
+	domRestricted bool
+	dowRestricted bool
+	location      *time.Location
+}
+
+type cronField struct {
+	name  string
+	min   int
+	max   int
+	names map[string]int
+}
+
+var (
+	minuteField     = cronField{name: "minute", min: 0, max: 59}
+	hourField       = cronField{name: "hour", min: 0, max: 23}
+	dayOfMonthField = cronField{name: "day of month", min: 1, max: 31}
+	monthField      = cronField{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dayOfWeekField = cronField{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var cronShortcuts = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCronSchedule parses a cron expression, times are evaluated in the given time zone (UTC if empty)
+func ParseCronSchedule(spec string, timeZone string) (*CronSchedule, error) {
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, errors.New("invalid time zone " + timeZone + ": " + err.Error())
+	}
+	expression := strings.TrimSpace(spec)
+	if shortcut, found := cronShortcuts[strings.ToLower(expression)]; found {
+		expression = shortcut
+	}
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, errors.New("cron expression must have 5 fields: " + spec)
+	}
+	res := &CronSchedule{location: location}
+	targets := []*uint64{&res.minute, &res.hour, &res.dayOfMonth, &res.month, &res.dayOfWeek}
+	for i, f := range []cronField{minuteField, hourField, dayOfMonthField, monthField, dayOfWeekField} {
+		bits, err := f.parse(fields[i])
+		if err != nil {
+			return nil, errors.New("invalid cron expression " + spec + ": " + err.Error())
+		}
+		*targets[i] = bits
+	}
+	// 7 is an alias for sunday
+	if res.dayOfWeek&(1<<7) != 0 {
+		res.dayOfWeek = res.dayOfWeek&^(1<<7) | 1
+	}
+	res.domRestricted = !strings.HasPrefix(fields[2], "*")
+	res.dowRestricted = !strings.HasPrefix(fields[4], "*")
+	return res, nil
+}
+
+// parse a comma separated list of values, ranges and steps into a bit set
+func (f cronField) parse(field string) (uint64, error) {
+	var res uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			s, err := strconv.Atoi(stepPart)
+			if err != nil || s <= 0 {
+				return 0, errors.New("invalid step in " + f.name + ": " + part)
+			}
+			step = s
+		}
+		var from, to int
+		if rangePart == "*" {
+			from, to = f.min, f.max
+		} else {
+			fromPart, toPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if from, err = f.value(fromPart); err != nil {
+				return 0, err
+			}
+			to = from
+			if isRange {
+				if to, err = f.value(toPart); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				// "a/n" means "a-max/n"
+				to = f.max
+			}
+		}
+		if from > to {
+			return 0, errors.New("invalid range in " + f.name + ": " + part)
+		}
+		for v := from; v <= to; v += step {
+			res |= 1 << uint(v)
+		}
+	}
+	return res, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	if v, found := f.names[strings.ToLower(s)]; found {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, errors.New("invalid value in " + f.name + ": " + s)
+	}
+	return v, nil
+}
+
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dayOfMonth&(1<<uint(t.Day())) != 0
+	dowMatch := s.dayOfWeek&(1<<uint(t.Weekday())) != 0
+	if s.domRestricted && s.dowRestricted {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+// Next returns the first scheduled time after t, or the zero time if there is none within the next five years
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.In(s.location).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.location)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.location)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.location)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// Latest returns the last scheduled time in the interval (after, until], or the zero time if there is none
+func (s *CronSchedule) Latest(after time.Time, until time.Time) time.Time {
+	var res time.Time
+	for t := s.Next(after); !t.IsZero() && !t.After(until); t = s.Next(t) {
+		res = t
+	}
+	return res
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cron schedules", func() {
+	at := func(value string) time.Time {
+		t, err := time.Parse(time.RFC3339, value)
+		Expect(err).NotTo(HaveOccurred())
+		return t
+	}
+	next := func(spec string, timeZone string, after string) string {
+		s, err := ParseCronSchedule(spec, timeZone)
+		Expect(err).NotTo(HaveOccurred())
+		return s.Next(at(after)).UTC().Format(time.RFC3339)
+	}
+
+	It("should compute the next scheduled time", func() {
+		Expect(next("0 0 * * *", "", "2024-05-30T15:31:00Z")).To(Equal("2024-05-31T00:00:00Z"))
+		Expect(next("*/15 * * * *", "", "2024-05-30T15:31:00Z")).To(Equal("2024-05-30T15:45:00Z"))
+		Expect(next("0 4 * * mon-fri", "", "2024-05-31T05:00:00Z")).To(Equal("2024-06-03T04:00:00Z"))
+		Expect(next("30 6 1 */3 *", "", "2024-05-30T15:31:00Z")).To(Equal("2024-07-01T06:30:00Z"))
+		Expect(next("0 0 29 2 *", "", "2024-03-01T00:00:00Z")).To(Equal("2028-02-29T00:00:00Z"))
+		Expect(next("@hourly", "", "2024-05-30T15:00:00Z")).To(Equal("2024-05-30T16:00:00Z"))
+	})
+
+	It("should combine day of month and day of week with or", func() {
+		Expect(next("0 0 13 * 5", "", "2024-05-01T00:00:00Z")).To(Equal("2024-05-03T00:00:00Z"))
+		Expect(next("0 0 13 * 7", "", "2024-05-06T00:00:00Z")).To(Equal("2024-05-12T00:00:00Z"))
+	})
+
+	It("should evaluate the schedule in the given time zone", func() {
+		Expect(next("0 6 * * *", "Europe/Berlin", "2024-05-30T15:31:00Z")).To(Equal("2024-05-31T04:00:00Z"))
+		Expect(next("0 6 * * *", "Europe/Berlin", "2024-01-30T15:31:00Z")).To(Equal("2024-01-31T05:00:00Z"))
+	})
+
+	It("should find the latest scheduled time in an interval", func() {
+		s, err := ParseCronSchedule("0 * * * *", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.Latest(at("2024-05-30T10:00:00Z"), at("2024-05-30T15:31:00Z"))).To(Equal(at("2024-05-30T15:00:00Z")))
+		Expect(s.Latest(at("2024-05-30T15:00:00Z"), at("2024-05-30T15:31:00Z")).IsZero()).To(BeTrue())
+	})
+
+	It("should reject invalid expressions", func() {
+		for _, invalid := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "5-1 * * * *", "*/0 * * * *", "* * * * abc"} {
+			_, err := ParseCronSchedule(invalid, "")
+			Expect(err).To(HaveOccurred(), invalid)
+		}
+		_, err := ParseCronSchedule("0 0 * * *", "Not/AZone")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"context"
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"strconv"
+	"time"
+)
+
+const (
+	// status flag
+	SLAMissed string = "SLAMissed"
+)
+
+// the time from which deadline and SLA of a run are measured: the scheduled time for runs of schedules, otherwise the
+// creation time
+func runStartTime(pr *pipelinev1.PipelineRun) time.Time {
+	if scheduled, err := time.Parse(time.RFC3339, pr.Annotations[ScheduledTimeAnnotation]); err == nil {
+		return scheduled
+	}
+	return pr.CreationTimestamp.Time
+}
+
+// the point in time given by seconds after the start of the run, nil if seconds is not set
+func dueTime(pr *pipelinev1.PipelineRun, seconds *int64) *time.Time {
+	if seconds == nil {
+		return nil
+	}
+	res := runStartTime(pr).Add(time.Duration(*seconds) * time.Second)
+	return &res
+}
+
+// time until the next deadline or SLA of an unfinished run has to be checked, 0 if there is none
+func nextDeadlineCheck(pr *pipelinev1.PipelineRun, now time.Time) time.Duration {
+	if hasFinished(pr) {
+		return 0
+	}
+	var res time.Duration
+	for _, due := range []*time.Time{dueTime(pr, pr.Spec.ActiveDeadlineSeconds), dueTime(pr, pr.Spec.SLASeconds)} {
+		if (due == nil) || !due.After(now) {
+			continue
+		}
+		if until := due.Sub(now); (res == 0) || (until < res) {
+			res = until
+		}
+	}
+	return res
+}
+
+// set the condition SLAMissed once the SLA has been missed or the run has finished in time, terminate the run when its
+// deadline has been exceeded
+func (r *PipelineRunReconciler) enforceDeadlines(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun) (*ctrl.Result, error) {
+	now := time.Now()
+	finished := hasFinished(pr)
+	if sla := dueTime(pr, pr.Spec.SLASeconds); (sla != nil) && (meta.FindStatusCondition(pr.Status.Conditions, SLAMissed) == nil) {
+		status := v1.ConditionUnknown
+		message := ""
+		if finished && !finishedAt(pr).After(*sla) {
+			status = v1.ConditionFalse
+			message = "Run has finished within SLA"
+		} else if finished || now.After(*sla) {
+			status = v1.ConditionTrue
+			message = "Run has not finished within SLA of " + strconv.FormatInt(*pr.Spec.SLASeconds, 10) + " seconds (due " + sla.UTC().Format(time.RFC3339) + ")"
+		}
+		if status != v1.ConditionUnknown {
+			if err := r.SetPipelineRunStatus(ctx, log, pr, SLAMissed, status, message); err != nil {
+				result := r.failed(ctx, "Failed to set PipelineRun status", err, pr, r.Recorder)
+				return &result, err
+			}
+			if status == v1.ConditionTrue {
+				r.Recorder.Event(pr, "Warning", "SLAMissed", message)
+			}
+			// changes to state have been made, return empty result to stop current reconciliation iteration
+			return &ctrl.Result{}, nil
+		}
+	}
+	if deadline := dueTime(pr, pr.Spec.ActiveDeadlineSeconds); (deadline != nil) && !finished && now.After(*deadline) {
+		message := "Run has exceeded its deadline of " + strconv.FormatInt(*pr.Spec.ActiveDeadlineSeconds, 10) + " seconds"
+		log(message)
+		r.Recorder.Event(pr, "Warning", "DeadlineExceeded", message)
+		return r.terminate(ctx, log, pr)
+	}
+	// return nil result to indicate that reconciliation can proceed
+	return nil, nil
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+)
+
+var _ = Describe("Run deadlines", func() {
+	created := time.Date(2024, 5, 1, 4, 10, 0, 0, time.UTC)
+	int64Ptr := func(i int64) *int64 {
+		return &i
+	}
+	var pr *pipelinev1.PipelineRun
+
+	BeforeEach(func() {
+		state := "Started a"
+		pr = &pipelinev1.PipelineRun{
+			ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(created)},
+			Spec: pipelinev1.PipelineRunSpec{
+				ActiveDeadlineSeconds: int64Ptr(3 * 3600),
+				SLASeconds:            int64Ptr(2 * 3600),
+			},
+			Status: pipelinev1.PipelineRunStatus{State: &state, PipelineStructure: &pipelinev1.PipelineStructure{}},
+		}
+	})
+
+	It("should measure from the scheduled time if there is one", func() {
+		Expect(*dueTime(pr, pr.Spec.SLASeconds)).To(Equal(created.Add(2 * time.Hour)))
+		pr.Annotations = map[string]string{ScheduledTimeAnnotation: "2024-05-01T04:00:00Z"}
+		Expect(*dueTime(pr, pr.Spec.SLASeconds)).To(Equal(time.Date(2024, 5, 1, 6, 0, 0, 0, time.UTC)))
+		Expect(dueTime(pr, nil)).To(BeNil())
+	})
+
+	It("should requeue at the next pending deadline", func() {
+		Expect(nextDeadlineCheck(pr, created.Add(time.Hour))).To(Equal(time.Hour))
+		Expect(nextDeadlineCheck(pr, created.Add(150*time.Minute))).To(Equal(30 * time.Minute))
+		Expect(nextDeadlineCheck(pr, created.Add(4*time.Hour))).To(Equal(time.Duration(0)))
+	})
+
+	It("should not requeue finished runs", func() {
+		state := Succeeded
+		pr.Status.State = &state
+		Expect(nextDeadlineCheck(pr, created.Add(time.Hour))).To(Equal(time.Duration(0)))
+	})
+})
@@ -0,0 +1,215 @@
+package controller
+
+import (
+	"context"
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	/*
+		name of the config map holding the history limits for the standalone runs of a namespace (i.e. runs that were
+		neither created by a schedule nor as child runs), the keys are successfulRunsHistoryLimit,
+		failedRunsHistoryLimit and ttlSecondsAfterFinished
+	*/
+	RunHistoryConfigMap = "pipeline-run-history"
+)
+
+// limits for the finished runs of a schedule or the standalone runs of a namespace (nil if not limited)
+type historyLimits struct {
+	successful *int
+	failed     *int
+	ttl        *time.Duration
+}
+
+func scheduleHistoryLimits(ps *pipelinev1.PipelineSchedule) historyLimits {
+	var res historyLimits
+	if ps.Spec.SuccessfulRunsHistoryLimit != nil {
+		limit := int(*ps.Spec.SuccessfulRunsHistoryLimit)
+		res.successful = &limit
+	}
+	if ps.Spec.FailedRunsHistoryLimit != nil {
+		limit := int(*ps.Spec.FailedRunsHistoryLimit)
+		res.failed = &limit
+	}
+	if ps.Spec.TTLSecondsAfterFinished != nil {
+		ttl := time.Duration(*ps.Spec.TTLSecondsAfterFinished) * time.Second
+		res.ttl = &ttl
+	}
+	return res
+}
+
+// the history limits of the standalone runs given by the config map of the namespace (invalid values are ignored)
+func namespaceHistoryLimits(cm *corev1.ConfigMap) historyLimits {
+	var res historyLimits
+	if cm == nil {
+		return res
+	}
+	value := func(key string) *int {
+		if i, err := strconv.Atoi(cm.Data[key]); (err == nil) && (i >= 0) {
+			return &i
+		}
+		return nil
+	}
+	res.successful = value("successfulRunsHistoryLimit")
+	res.failed = value("failedRunsHistoryLimit")
+	if seconds := value("ttlSecondsAfterFinished"); seconds != nil {
+		ttl := time.Duration(*seconds) * time.Second
+		res.ttl = &ttl
+	}
+	return res
+}
+
+// check if a run has finished (it will not change anymore unless it is resumed)
+func hasFinished(pr *pipelinev1.PipelineRun) bool {
+	if isFalse(pr, VersionDetermined) || isFalse(pr, StepsReused) || isTrue(pr, Terminated) {
+		return true
+	}
+	return (pr.Status.PipelineStructure != nil) && isTerminal(pr)
+}
+
+// the time at which a finished run has completed (falls back to the creation time for runs without completion time)
+func finishedAt(pr *pipelinev1.PipelineRun) time.Time {
+	if pr.Status.CompletionTime != nil {
+		return pr.Status.CompletionTime.Time
+	}
+	return pr.CreationTimestamp.Time
+}
+
+/*
+determine the runs to be deleted (oldest first): finished runs whose TTL has expired and the oldest finished runs
+exceeding the history limits of succeeded and failed runs
+*/
+func runsToPrune(runs []pipelinev1.PipelineRun, limits historyLimits, now time.Time) []pipelinev1.PipelineRun {
+	var finished []pipelinev1.PipelineRun
+	for _, pr := range runs {
+		if hasFinished(&pr) {
+			finished = append(finished, pr)
+		}
+	}
+	sort.SliceStable(finished, func(i, j int) bool {
+		return finishedAt(&finished[i]).Before(finishedAt(&finished[j]))
+	})
+	var succeeded, failed []int
+	for i := range finished {
+		pr := &finished[i]
+		if !isTrue(pr, Terminated) && (pr.Status.State != nil) && (*pr.Status.State == Succeeded) {
+			succeeded = append(succeeded, i)
+		} else {
+			failed = append(failed, i)
+		}
+	}
+	prune := map[int]bool{}
+	exceeding := func(indices []int, limit *int) {
+		if (limit != nil) && (len(indices) > *limit) {
+			for _, i := range indices[:len(indices)-*limit] {
+				prune[i] = true
+			}
+		}
+	}
+	exceeding(succeeded, limits.successful)
+	exceeding(failed, limits.failed)
+	var res []pipelinev1.PipelineRun
+	for i, pr := range finished {
+		if prune[i] || ((limits.ttl != nil) && !finishedAt(&pr).Add(*limits.ttl).After(now)) {
+			res = append(res, pr)
+		}
+	}
+	return res
+}
+
+/*
+RunHistoryCollector periodically deletes finished runs of schedules and standalone runs according to their history
+limits (child runs are deleted together with their parent runs, PipelineJobs together with their runs)
+*/
+type RunHistoryCollector struct {
+	client.Client
+	Recorder record.EventRecorder
+	Interval time.Duration
+}
+
+// Start implements manager.Runnable
+func (c *RunHistoryCollector) Start(ctx context.Context) error {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := c.collect(ctx); err != nil {
+				log.FromContext(ctx).Error(err, "Failed to delete runs exceeding history limits")
+			}
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, only the leader deletes runs
+func (c *RunHistoryCollector) NeedLeaderElection() bool {
+	return true
+}
+
+func (c *RunHistoryCollector) collect(ctx context.Context) error {
+	runs := &pipelinev1.PipelineRunList{}
+	if err := c.List(ctx, runs); err != nil {
+		return err
+	}
+	// group the runs by schedule and the standalone runs by namespace
+	scheduled := map[types.NamespacedName][]pipelinev1.PipelineRun{}
+	standalone := map[string][]pipelinev1.PipelineRun{}
+	for _, pr := range runs.Items {
+		if schedule, found := pr.Labels[createdLabelKeys(&pr).PipelineSchedule]; found {
+			name := types.NamespacedName{Namespace: pr.Namespace, Name: schedule}
+			scheduled[name] = append(scheduled[name], pr)
+		} else if parentRunName(&pr) == nil {
+			standalone[pr.Namespace] = append(standalone[pr.Namespace], pr)
+		}
+	}
+	now := time.Now()
+	for name, prs := range scheduled {
+		ps := &pipelinev1.PipelineSchedule{}
+		notExists, err := NotExistsResource(c, ctx, ps, name)
+		if err != nil {
+			return err
+		}
+		if notExists {
+			// runs are deleted together with their schedule
+			continue
+		}
+		for _, pr := range runsToPrune(prs, scheduleHistoryLimits(ps), now) {
+			if err := c.deleteRun(ctx, &pr); err != nil {
+				return err
+			}
+			c.Recorder.Event(ps, "Normal", "RunHistory", "Deleted finished PipelineRun "+pr.Name)
+		}
+	}
+	for namespace, prs := range standalone {
+		cm := &corev1.ConfigMap{}
+		notExists, err := NotExistsResource(c, ctx, cm, types.NamespacedName{Namespace: namespace, Name: RunHistoryConfigMap})
+		if err != nil {
+			return err
+		}
+		if notExists {
+			continue
+		}
+		for _, pr := range runsToPrune(prs, namespaceHistoryLimits(cm), now) {
+			if err := c.deleteRun(ctx, &pr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *RunHistoryCollector) deleteRun(ctx context.Context, pr *pipelinev1.PipelineRun) error {
+	log.FromContext(ctx).Info("Deleting finished PipelineRun", "PipelineRun.Namespace", pr.Namespace, "PipelineRun.Name", pr.Name)
+	return client.IgnoreNotFound(c.Delete(ctx, pr, client.PropagationPolicy(v1.DeletePropagationBackground)))
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+)
+
+var _ = Describe("Run history", func() {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	intPtr := func(i int) *int {
+		return &i
+	}
+	run := func(name string, state string, hoursAgo int) pipelinev1.PipelineRun {
+		completed := metav1.NewTime(now.Add(-time.Duration(hoursAgo) * time.Hour))
+		return pipelinev1.PipelineRun{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: pipelinev1.PipelineRunStatus{
+				State:             &state,
+				CompletionTime:    &completed,
+				PipelineStructure: &pipelinev1.PipelineStructure{},
+			},
+		}
+	}
+	names := func(runs []pipelinev1.PipelineRun) []string {
+		var res []string
+		for _, pr := range runs {
+			res = append(res, pr.Name)
+		}
+		return res
+	}
+	runs := []pipelinev1.PipelineRun{
+		run("s1", Succeeded, 5),
+		run("f1", Failed, 4),
+		run("s2", Succeeded, 3),
+		run("running", "Started a", 2),
+		run("f2", Failed, 2),
+		run("s3", Succeeded, 1),
+	}
+
+	It("should keep all runs without limits", func() {
+		Expect(runsToPrune(runs, historyLimits{}, now)).To(BeEmpty())
+	})
+
+	It("should delete the oldest runs exceeding the history limits", func() {
+		limits := historyLimits{successful: intPtr(1), failed: intPtr(1)}
+		Expect(names(runsToPrune(runs, limits, now))).To(Equal([]string{"s1", "f1", "s2"}))
+	})
+
+	It("should delete finished runs whose TTL has expired", func() {
+		ttl := 3 * time.Hour
+		Expect(names(runsToPrune(runs, historyLimits{ttl: &ttl}, now))).To(Equal([]string{"s1", "f1", "s2"}))
+	})
+
+	It("should read the namespace defaults from the config map", func() {
+		cm := &corev1.ConfigMap{Data: map[string]string{"successfulRunsHistoryLimit": "2", "failedRunsHistoryLimit": "x", "ttlSecondsAfterFinished": "60"}}
+		limits := namespaceHistoryLimits(cm)
+		Expect(*limits.successful).To(Equal(2))
+		Expect(limits.failed).To(BeNil())
+		Expect(*limits.ttl).To(Equal(time.Minute))
+	})
+})
@@ -0,0 +1,367 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"os"
+	"regexp"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+)
+
+const (
+	/*
+		name of the config map in the namespace of the operator holding the image policy (key policy.yaml), if it does
+		not exist, all images are admitted
+	*/
+	ImagePolicyConfigMap = "pipeline-image-policy"
+	ImagePolicyKey       = "policy.yaml"
+
+	// environment variable with the namespace of the operator (defaults to the namespace of its service account)
+	OperatorNamespaceEnv = "OPERATOR_NAMESPACE"
+
+	// default of the pod label holding the class of the image of the step container (see OperatorConfig)
+	DefaultImageClassLabel = "breuninger.de/image-repo-class"
+	// classes of images of tenants and of trusted images, the former is the class of images not matched by any rule
+	// if the policy does not name another one
+	DefaultImageClass = "tenant"
+	TrustedImageClass = "trusted"
+
+	// actions of image policy rules
+	ImagePolicyAllow = "Allow"
+	ImagePolicyDeny  = "Deny"
+
+	// status condition of pipeline definitions telling whether all images are admitted by the image policy
+	ImagesAdmitted = "ImagesAdmitted"
+)
+
+/*
+ImagePolicy defines which images the steps of pipelines may use and the class label attached to their pods (e.g. to
+select them in network policies). The rules for the namespace of a pipeline are evaluated first, then the general
+rules, the first rule matching an image applies.
+*/
+type ImagePolicy struct {
+	// key of the pod label holding the class of the image (defaults to the imageClassLabel of the operator configuration)
+	ClassLabel string `json:"classLabel,omitempty"`
+	// class of images not matched by any rule or matched by a rule without class (defaults to DefaultImageClass)
+	DefaultClass string `json:"defaultClass,omitempty"`
+	// action for images not matched by any rule, Allow (default) or Deny
+	DefaultAction string `json:"defaultAction,omitempty"`
+	// rules applying to all namespaces
+	Rules []ImagePolicyRule `json:"rules,omitempty"`
+	// rules applying to single namespaces only (by namespace name)
+	Namespaces map[string][]ImagePolicyRule `json:"namespaces,omitempty"`
+}
+
+// ImagePolicyRule matches images by registry prefix or regular expression (exactly one of both must be given)
+type ImagePolicyRule struct {
+	Prefix string `json:"prefix,omitempty"`
+	Regex  string `json:"regex,omitempty"`
+	// class of the matched images, written to the class label of the pods
+	Class string `json:"class,omitempty"`
+	// Allow (default) or Deny
+	Action string `json:"action,omitempty"`
+	// matched images must be pinned by digest (image@sha256:...)
+	RequireDigest bool `json:"requireDigest,omitempty"`
+}
+
+// the namespace the operator runs in, empty if it can not be determined (e.g. when running outside the cluster)
+func operatorNamespace() string {
+	if namespace := env(OperatorNamespaceEnv); namespace != nil {
+		return *namespace
+	}
+	data, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// parse and validate the image policy given in YAML (or JSON)
+func parseImagePolicy(data string) (*ImagePolicy, error) {
+	res := &ImagePolicy{}
+	if err := yaml.UnmarshalStrict([]byte(data), res); err != nil {
+		return nil, err
+	}
+	if err := res.validate(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func validAction(action string) bool {
+	return (action == "") || (action == ImagePolicyAllow) || (action == ImagePolicyDeny)
+}
+
+func (p *ImagePolicy) validate() error {
+	if len(p.ClassLabel) > 0 {
+		if msgs := validation.IsQualifiedName(p.ClassLabel); len(msgs) > 0 {
+			return fmt.Errorf("invalid class label %s: %s", p.ClassLabel, strings.Join(msgs, ", "))
+		}
+	}
+	if msgs := validation.IsValidLabelValue(p.DefaultClass); len(msgs) > 0 {
+		return fmt.Errorf("invalid default class %s: %s", p.DefaultClass, strings.Join(msgs, ", "))
+	}
+	if !validAction(p.DefaultAction) {
+		return errors.New("invalid default action " + p.DefaultAction + " (must be Allow or Deny)")
+	}
+	var namespaces []string
+	for namespace := range p.Namespaces {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	for _, namespace := range append([]string{""}, namespaces...) {
+		rules := p.Rules
+		if len(namespace) > 0 {
+			rules = p.Namespaces[namespace]
+		}
+		for i, rule := range rules {
+			if err := rule.validate(); err != nil {
+				if len(namespace) > 0 {
+					return fmt.Errorf("rule %d of namespace %s: %w", i, namespace, err)
+				}
+				return fmt.Errorf("rule %d: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (rule *ImagePolicyRule) validate() error {
+	if (len(rule.Prefix) > 0) == (len(rule.Regex) > 0) {
+		return errors.New("exactly one of prefix and regex must be given")
+	}
+	if len(rule.Regex) > 0 {
+		if _, err := regexp.Compile(rule.Regex); err != nil {
+			return fmt.Errorf("invalid regex: %w", err)
+		}
+	}
+	if msgs := validation.IsValidLabelValue(rule.Class); len(msgs) > 0 {
+		return fmt.Errorf("invalid class %s: %s", rule.Class, strings.Join(msgs, ", "))
+	}
+	if !validAction(rule.Action) {
+		return errors.New("invalid action " + rule.Action + " (must be Allow or Deny)")
+	}
+	return nil
+}
+
+func (rule *ImagePolicyRule) matches(image string) bool {
+	if len(rule.Prefix) > 0 {
+		return strings.HasPrefix(image, rule.Prefix)
+	}
+	matched, err := regexp.MatchString(rule.Regex, image)
+	return (err == nil) && matched
+}
+
+// key of the pod label holding the class of the image
+func (p *ImagePolicy) classLabel() string {
+	if len(p.ClassLabel) > 0 {
+		return p.ClassLabel
+	}
+	return currentConfig().ImageClassLabel
+}
+
+// class of images matched by no rule (or by a rule without class)
+func (p *ImagePolicy) defaultClass() string {
+	if len(p.DefaultClass) > 0 {
+		return p.DefaultClass
+	}
+	return DefaultImageClass
+}
+
+/*
+determine the class of an image used in a namespace, returns the reason as message if the image is not admitted by the
+policy
+*/
+func (p *ImagePolicy) admit(namespace string, image string) (string, string) {
+	for _, rule := range append(append([]ImagePolicyRule{}, p.Namespaces[namespace]...), p.Rules...) {
+		if !rule.matches(image) {
+			continue
+		}
+		if rule.Action == ImagePolicyDeny {
+			return "", "image " + image + " is denied by the image policy"
+		}
+		if rule.RequireDigest && !strings.Contains(image, "@sha256:") {
+			return "", "image " + image + " must be pinned by digest (image@sha256:...)"
+		}
+		if len(rule.Class) > 0 {
+			return rule.Class, ""
+		}
+		return p.defaultClass(), ""
+	}
+	if p.DefaultAction == ImagePolicyDeny {
+		return "", "image " + image + " is not admitted by any rule of the image policy"
+	}
+	return p.defaultClass(), ""
+}
+
+/*
+the image policy of the operator, all images are admitted if there is none. An error is returned if the policy is
+invalid, so that no images are admitted by mistake.
+*/
+func getImagePolicy(r client.Reader, ctx context.Context) (*ImagePolicy, error) {
+	namespace := operatorNamespace()
+	if len(namespace) == 0 {
+		return &ImagePolicy{}, nil
+	}
+	cm := &corev1.ConfigMap{}
+	notexists, err := NotExistsResource(r, ctx, cm, types.NamespacedName{Namespace: namespace, Name: ImagePolicyConfigMap})
+	if err != nil {
+		return nil, err
+	}
+	if notexists {
+		return &ImagePolicy{}, nil
+	}
+	res, err := parseImagePolicy(cm.Data[ImagePolicyKey])
+	if err != nil {
+		return nil, fmt.Errorf("invalid image policy in config map %s/%s: %w", namespace, ImagePolicyConfigMap, err)
+	}
+	return res, nil
+}
+
+/*
+the containers with image that the specification of a job spec adds to the pod template of the job (invalid
+specifications are reported by the validation of the pipeline definition)
+*/
+func specificationContainers(js *pipelinev1.JobSpec) []corev1.Container {
+	patch, err := pipelinev1.SpecificationPatch(js.Specification)
+	if (err != nil) || (patch == nil) {
+		return nil
+	}
+	template := &corev1.PodTemplateSpec{}
+	if err := json.Unmarshal(patch, template); err != nil {
+		return nil
+	}
+	var res []corev1.Container
+	for _, c := range append(append([]corev1.Container{}, template.Spec.InitContainers...), template.Spec.Containers...) {
+		if len(c.Image) > 0 {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// the containers of a patched pod template that are not contained in the original one
+func addedContainers(original *corev1.PodTemplateSpec, patched *corev1.PodTemplateSpec) []corev1.Container {
+	existing := append(append([]corev1.Container{}, original.Spec.InitContainers...), original.Spec.Containers...)
+	var res []corev1.Container
+	for _, c := range append(append([]corev1.Container{}, patched.Spec.InitContainers...), patched.Spec.Containers...) {
+		if findContainer(existing, c.Name) == nil {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+/*
+check the image of a job spec and the images of the containers added by its specification, returns the class of the
+image of the step container and the first violation as message
+*/
+func (p *ImagePolicy) admitJobSpec(namespace string, js *pipelinev1.JobSpec) (string, string) {
+	class, violation := p.admit(namespace, js.Image)
+	if len(violation) > 0 {
+		return "", violation
+	}
+	for _, c := range specificationContainers(js) {
+		if _, violation := p.admit(namespace, c.Image); len(violation) > 0 {
+			return "", "container " + c.Name + ": " + violation
+		}
+	}
+	return class, ""
+}
+
+// the violations of the image policy by the job steps and termination jobs of a pipeline definition
+func imagePolicyViolations(policy *ImagePolicy, pd *pipelinev1.PipelineDefinition) []string {
+	var res []string
+	for _, step := range pd.Spec.PipelineStructure.JobSteps {
+		if _, violation := policy.admitJobSpec(pd.Namespace, &step.JobSpec); len(violation) > 0 {
+			res = append(res, "step "+step.Id+": "+violation)
+		}
+	}
+	for i := range pd.Spec.TerminationJobs {
+		if _, violation := policy.admitJobSpec(pd.Namespace, &pd.Spec.TerminationJobs[i]); len(violation) > 0 {
+			res = append(res, fmt.Sprintf("termination job %d: %s", i, violation))
+		}
+	}
+	return res
+}
+
+/*
+the check of pipeline definitions by the validating webhook, it reads the image policy with the given reader (the
+cache of the manager does not necessarily hold config maps of the operator namespace)
+*/
+func imageAdmission(r client.Reader) func(context.Context, *pipelinev1.PipelineDefinition) ([]string, error) {
+	return func(ctx context.Context, pd *pipelinev1.PipelineDefinition) ([]string, error) {
+		policy, err := getImagePolicy(r, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return imagePolicyViolations(policy, pd), nil
+	}
+}
+
+/*
+report the violations of the image policy in the status of a pipeline definition (runs of the pipeline fail when they
+start a step with an image that is not admitted)
+*/
+func (r *PipelineDefinitionReconciler) updateImageAdmission(ctx context.Context, log func(string, ...interface{}), pd *pipelinev1.PipelineDefinition) (*ctrl.Result, error) {
+	policy, err := getImagePolicy(r, ctx)
+	if err != nil {
+		res := r.failed(ctx, "Failed to get image policy", err, pd, r.Recorder)
+		return &res, err
+	}
+	condition := metav1.Condition{
+		Type:    ImagesAdmitted,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Admitted",
+		Message: "All images are admitted by the image policy",
+	}
+	if violations := imagePolicyViolations(policy, pd); len(violations) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "PolicyViolation"
+		condition.Message = strings.Join(violations, "; ")
+	}
+	if current := meta.FindStatusCondition(pd.Status.Conditions, ImagesAdmitted); (current != nil) && (current.Status == condition.Status) && (current.Message == condition.Message) {
+		// nothing changed, continue reconciliation
+		return nil, nil
+	}
+	log("Updating status " + ImagesAdmitted + " to " + string(condition.Status))
+	meta.SetStatusCondition(&pd.Status.Conditions, condition)
+	if err := r.Status().Update(ctx, pd); err != nil {
+		res := r.failed(ctx, "Failed to update status "+ImagesAdmitted, err, pd, r.Recorder)
+		return &res, err
+	}
+	if condition.Status == metav1.ConditionFalse {
+		r.Recorder.Event(pd, "Warning", "ImagePolicy", condition.Message)
+	}
+	// changes made: end reconciliation iteration
+	return &ctrl.Result{}, nil
+}
+
+// maps the config map of the image policy to reconcile requests for all pipeline definitions
+func (r *PipelineDefinitionReconciler) imagePolicyRequests(ctx context.Context, obj client.Object) []reconcile.Request {
+	if (obj.GetName() != ImagePolicyConfigMap) || (obj.GetNamespace() != operatorNamespace()) {
+		return nil
+	}
+	pdl := &pipelinev1.PipelineDefinitionList{}
+	if err := r.List(ctx, pdl); err != nil {
+		return nil
+	}
+	var res []reconcile.Request
+	for _, pd := range pdl.Items {
+		res = append(res, reconcile.Request{NamespacedName: NameSpacedName(&pd)})
+	}
+	return res
+}
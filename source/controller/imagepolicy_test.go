@@ -0,0 +1,122 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+)
+
+var _ = Describe("Image policy", func() {
+	It("should admit all images without policy", func() {
+		policy := &ImagePolicy{}
+		Expect(policy.admit("k-pipe", "busybox")).To(Equal(DefaultImageClass))
+		Expect(policy.classLabel()).To(Equal(DefaultImageClassLabel))
+	})
+
+	It("should apply the first matching rule, namespace rules first", func() {
+		policy, err := parseImagePolicy(`
+classLabel: example.com/image-class
+defaultAction: Deny
+rules:
+- prefix: registry.example.com/trusted/
+  class: trusted
+- regex: ^registry\.example\.com/team-[a-z]+/
+  class: tenant
+  requireDigest: true
+- regex: ^busybox(:.*)?$
+namespaces:
+  ns1:
+  - prefix: registry.example.com/team-a/experimental/
+    action: Deny
+`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(policy.classLabel()).To(Equal("example.com/image-class"))
+		class, violation := policy.admit("ns1", "registry.example.com/trusted/app:1.0")
+		Expect(violation).To(BeEmpty())
+		Expect(class).To(Equal("trusted"))
+		class, violation = policy.admit("ns2", "registry.example.com/team-a/experimental/app@sha256:0123")
+		Expect(violation).To(BeEmpty())
+		Expect(class).To(Equal("tenant"))
+		_, violation = policy.admit("ns1", "registry.example.com/team-a/experimental/app@sha256:0123")
+		Expect(violation).To(ContainSubstring("denied"))
+		_, violation = policy.admit("ns2", "registry.example.com/team-a/app:latest")
+		Expect(violation).To(ContainSubstring("pinned by digest"))
+		Expect(policy.admit("ns2", "busybox:1.36")).To(Equal(DefaultImageClass))
+		_, violation = policy.admit("ns2", "docker.io/library/nginx")
+		Expect(violation).To(ContainSubstring("not admitted"))
+	})
+
+	It("should reject invalid policies", func() {
+		for _, data := range []string{
+			"rules:\n- class: x\n",
+			"rules:\n- prefix: a\n  regex: b\n",
+			"rules:\n- regex: '('\n",
+			"rules:\n- prefix: a\n  action: Maybe\n",
+			"rules:\n- prefix: a\n  class: not a label value\n",
+			"classLabel: 'in valid'\n",
+			"namespaces:\n  ns1:\n  - class: x\n",
+			"unknownField: true\n",
+		} {
+			_, err := parseImagePolicy(data)
+			Expect(err).To(HaveOccurred(), data)
+		}
+	})
+
+	It("should report the violations of a pipeline definition", func() {
+		policy := &ImagePolicy{DefaultAction: ImagePolicyDeny, Rules: []ImagePolicyRule{{Prefix: "registry.example.com/"}}}
+		pd := &pipelinev1.PipelineDefinition{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns1"},
+			Spec: pipelinev1.PipelineDefinitionSpec{
+				PipelineStructure: pipelinev1.PipelineStructure{
+					JobSteps: []*pipelinev1.PipelineJobStepSpec{
+						{Id: "a", JobSpec: pipelinev1.JobSpec{Image: "registry.example.com/a"}},
+						{Id: "b", JobSpec: pipelinev1.JobSpec{Image: "busybox"}},
+					},
+				},
+				TerminationJobs: []pipelinev1.JobSpec{
+					{Image: "alpine"},
+					{Image: "registry.example.com/t", Specification: []byte(`{"initContainers":[{"name":"setup","image":"busybox"}]}`)},
+				},
+			},
+		}
+		Expect(imagePolicyViolations(policy, pd)).To(Equal([]string{
+			"step b: image busybox is not admitted by any rule of the image policy",
+			"termination job 0: image alpine is not admitted by any rule of the image policy",
+			"termination job 1: container setup: image busybox is not admitted by any rule of the image policy",
+		}))
+	})
+
+	It("should find the containers added by a specification", func() {
+		original := &corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "init", Image: "bash"}},
+			Containers:     []corev1.Container{{Name: "main", Image: "registry.example.com/a"}},
+		}}
+		patched := original.DeepCopy()
+		patched.Spec.InitContainers = append(patched.Spec.InitContainers, corev1.Container{Name: "setup", Image: "busybox"})
+		patched.Spec.Containers = append(patched.Spec.Containers, corev1.Container{Name: "proxy", Image: "envoy"})
+		var images []string
+		for _, c := range addedContainers(original, patched) {
+			images = append(images, c.Image)
+		}
+		Expect(images).To(Equal([]string{"busybox", "envoy"}))
+	})
+})
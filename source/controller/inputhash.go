@@ -0,0 +1,166 @@
+package controller
+
+import (
+	"context"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/google"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"regexp"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+)
+
+var contentHashPattern = regexp.MustCompile("^[0-9a-f]{64}$")
+
+/*
+resolve an image reference to the digest it currently points to (repository@sha256:...), images pinned by digest are
+returned as given. The registry is accessed with the docker config and the google credentials of the operator.
+*/
+func resolveImageDigest(ctx context.Context, image string) (string, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return "", err
+	}
+	if digest, ok := ref.(name.Digest); ok {
+		return digest.Context().Name() + "@" + digest.DigestStr(), nil
+	}
+	desc, err := remote.Head(ref, remote.WithContext(ctx), remote.WithAuthFromKeychain(authn.NewMultiKeychain(authn.DefaultKeychain, google.Keychain)))
+	if err != nil {
+		return "", err
+	}
+	return ref.Context().Name() + "@" + desc.Digest.String(), nil
+}
+
+func (r *PipelineRunReconciler) constructHashJobName(pr *pipelinev1.PipelineRun, stepId string) string {
+	return r.ConstructPipelineJobName(pr, stepId) + "-hash"
+}
+
+/*
+create Job that computes the hash of the contents of all input files of a step (arranged like in the working directory
+of the step) and reports it as termination message
+*/
+func (r *PipelineRunReconciler) CreateHashJob(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, stepId string) error {
+	inputs, err := r.resolveInputPipes(ctx, pr, stepId, pr.Namespace)
+	if err != nil {
+		return err
+	}
+	config := currentConfig()
+	inputDir := config.WorkdirPath + "/input"
+	workdirMounts := []corev1.VolumeMount{getVolumeMount("workdir", config.WorkdirPath)}
+	volumes := []corev1.Volume{{Name: "workdir", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}}
+	volumeMounts := append([]corev1.VolumeMount{}, workdirMounts...)
+	commands := ""
+	addInitCommand(&commands, "mkdir", "-p", inputDir)
+	var downloads []string
+	for _, in := range inputs {
+		if in.VolumeType == VolumeTypeArtifact {
+			downloads = append(downloads, downloadCommand(in.Volume+"/"+in.SourceFile, inputDir+"/"+in.TargetFile))
+			continue
+		}
+		if !volumePresentAlready(inputVolumeName(in), volumes) {
+			volumes = append(volumes, getInputVolume(in))
+			volumeMounts = append(volumeMounts, getVolumeMount(inputVolumeName(in), in.MountPath))
+		}
+		addInitCommand(&commands, "ln", "-s", shellQuote(in.MountPath+"/"+in.SourceFile), shellQuote(inputDir+"/"+in.TargetFile))
+	}
+	// file names and contents enter the hash, the order of the files is fixed by sorting
+	addInitCommand(&commands, "cd", inputDir)
+	addInitCommand(&commands, "(find -L . -type f -print0 | LC_ALL=C sort -z | xargs -0 -r sha256sum | sha256sum | cut -c1-64 > /dev/termination-log)")
+	var initContainers []corev1.Container
+	if len(downloads) > 0 {
+		download, err := transferContainer("download", pr.Spec.ArtifactStore, append([]string{"mkdir -p " + shellQuote(inputDir)}, downloads...), workdirMounts)
+		if err != nil {
+			return err
+		}
+		initContainers = append(initContainers, download)
+	}
+
+	jobName := r.constructHashJobName(pr, stepId)
+	// the labels to be attached to job
+	jobLabels := currentConfig().resourceLabels("InputHash", jobName)
+	var backoffLimit int32 = 2
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jobName,
+			Namespace: pr.Namespace,
+			Labels:    jobLabels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: jobLabels,
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy:  corev1.RestartPolicyNever,
+					Volumes:        volumes,
+					InitContainers: initContainers,
+					Containers: []corev1.Container{{
+						Name:                     "main",
+						Image:                    config.InitImage,
+						Command:                  []string{currentConfig().Shell},
+						Args:                     []string{"-c", commands},
+						VolumeMounts:             volumeMounts,
+						TerminationMessagePath:   "/dev/termination-log",
+						TerminationMessagePolicy: corev1.TerminationMessageReadFile,
+						ImagePullPolicy:          corev1.PullIfNotPresent,
+					}},
+				},
+			},
+		},
+	}
+	if err := ctrl.SetControllerReference(pr, job, r.Scheme); err != nil {
+		return err
+	}
+	log("Creating a new input hash Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+	return CreateOrUpdate(r, r, ctx, log, job, &batchv1.Job{})
+}
+
+/*
+Reads the hash of the inputs of a step computed by its hash job, the job is deleted afterwards. Returns an empty hash
+while the job has not completed, yet. If the hash can not be computed, the reason is returned as message (the error is
+only set in case of failures when accessing the api server).
+*/
+func (r *PipelineRunReconciler) readInputHash(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, stepId string) (string, string, error) {
+	jobName := r.constructHashJobName(pr, stepId)
+	job := &batchv1.Job{}
+	notExists, err := NotExistsResource(r, ctx, job, types.NamespacedName{Namespace: pr.Namespace, Name: jobName})
+	if err != nil || notExists {
+		return "", "", err
+	}
+	hash := ""
+	reason := ""
+	if isTrueInJob(job, batchv1.JobFailed) {
+		reason = "hash job " + jobName + " failed"
+	} else if !isTrueInJob(job, batchv1.JobComplete) {
+		return "", "", nil
+	} else {
+		pods := &corev1.PodList{}
+		if err := r.List(ctx, pods, client.InNamespace(pr.Namespace), client.MatchingLabels{"job-name": jobName}); err != nil {
+			return "", "", err
+		}
+		for _, pod := range pods.Items {
+			for _, cs := range pod.Status.ContainerStatuses {
+				if (cs.State.Terminated != nil) && (cs.State.Terminated.ExitCode == 0) {
+					hash = strings.TrimSpace(cs.State.Terminated.Message)
+				}
+			}
+		}
+		if !contentHashPattern.MatchString(hash) {
+			hash = ""
+			reason = "hash job " + jobName + " did not report a hash"
+		}
+	}
+	log("Deleting the input hash Job", "Job.Namespace", pr.Namespace, "Job.Name", jobName)
+	if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+		return "", "", err
+	}
+	return hash, reason, nil
+}
@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// kinds of resources that may hold the file of a pipe
+	VolumeTypePVC       = "PersistentVolumeClaim"
+	VolumeTypeConfigMap = "ConfigMap"
+	VolumeTypeSecret    = "Secret"
+)
+
+// the kind and name of the resource that holds the file of a pipe binding
+func bindingVolume(b *pipelinev1.PipeBinding) (string, string, error) {
+	var volumeType, volume string
+	count := 0
+	if len(b.Volume) > 0 {
+		volumeType, volume = VolumeTypePVC, b.Volume
+		count++
+	}
+	if len(b.ConfigMap) > 0 {
+		volumeType, volume = VolumeTypeConfigMap, b.ConfigMap
+		count++
+	}
+	if len(b.Secret) > 0 {
+		volumeType, volume = VolumeTypeSecret, b.Secret
+		count++
+	}
+	if len(b.Artifact) > 0 {
+		volumeType, volume = VolumeTypeArtifact, b.Artifact
+		count++
+	}
+	if count != 1 {
+		return "", "", errors.New("binding of pipe " + b.Name + " must specify exactly one of volume, configMap, secret, artifact or run")
+	}
+	return volumeType, volume, nil
+}
+
+// the input pipe of a job that reads the file of a binding into the given target file
+func toInputPipe(b *pipelinev1.PipeBinding, targetFile string) (pipelinev1.InputPipe, error) {
+	volumeType, volume, err := bindingVolume(b)
+	if err != nil {
+		return pipelinev1.InputPipe{}, err
+	}
+	in := pipelinev1.InputPipe{
+		Volume:     volume,
+		VolumeType: volumeType,
+		SourceFile: b.SourceFile,
+		TargetFile: targetFile,
+	}
+	in.MountPath = getMountPath(inputVolumeName(in))
+	return in, nil
+}
+
+// resolve a binding of the run inputs, bindings to the output of another run are replaced by the binding of that output
+func (r *PipelineRunReconciler) resolveRunInput(ctx context.Context, pr *pipelinev1.PipelineRun, b *pipelinev1.PipeBinding) (*pipelinev1.PipeBinding, error) {
+	if len(b.Run) == 0 {
+		return b.DeepCopy(), nil
+	}
+	if (len(b.Volume) > 0) || (len(b.ConfigMap) > 0) || (len(b.Secret) > 0) || (len(b.Artifact) > 0) {
+		return nil, errors.New("binding of pipe " + b.Name + " must specify exactly one of volume, configMap, secret, artifact or run")
+	}
+	source, err := r.GetPipelineRun(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: b.Run})
+	if err != nil {
+		return nil, err
+	}
+	if source == nil {
+		return nil, errors.New("run " + b.Run + " bound to input pipe " + b.Name + " not found")
+	}
+	if (source.Status.State == nil) || (*source.Status.State != Succeeded) {
+		return nil, errors.New("run " + b.Run + " bound to input pipe " + b.Name + " has not succeeded")
+	}
+	for _, output := range source.Status.OutputPipes {
+		if output.Name == b.SourceFile {
+			res := output.DeepCopy()
+			res.Name = b.Name
+			return res, nil
+		}
+	}
+	return nil, errors.New("run " + b.Run + " has no output pipe " + b.SourceFile)
+}
+
+// name of the pod volume of an input pipe (config maps and secrets get a prefix to avoid clashes with claims)
+func inputVolumeName(in pipelinev1.InputPipe) string {
+	switch in.VolumeType {
+	case VolumeTypeConfigMap:
+		return "configmap-" + in.Volume
+	case VolumeTypeSecret:
+		return "secret-" + in.Volume
+	}
+	return in.Volume
+}
+
+// the read-only pod volume of an input pipe
+func getInputVolume(in pipelinev1.InputPipe) corev1.Volume {
+	name := inputVolumeName(in)
+	switch in.VolumeType {
+	case VolumeTypeConfigMap:
+		return corev1.Volume{
+			Name: name,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: in.Volume},
+				},
+			},
+		}
+	case VolumeTypeSecret:
+		return corev1.Volume{
+			Name: name,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: in.Volume,
+				},
+			},
+		}
+	}
+	return getVolume(in.Volume, true)
+}
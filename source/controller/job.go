@@ -3,6 +3,7 @@ package controller
 import (
 	"context"
 	"errors"
+	"fmt"
 	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -27,9 +28,9 @@ func (r *PipelineJobReconciler) GetJob(ctx context.Context, name types.Namespace
 create Kubernetes Job running a step contaiiner
 */
 func (r *PipelineJobReconciler) CreateJob(ctx context.Context, log func(string, ...interface{}), pj *pipelinev1.PipelineJob) (*batchv1.Job, error) {
-	jobName := pj.Name
-	var resources corev1.ResourceRequirements
-	terminationMessagePath := "/dev/termination-log" // TODO use this
+	jobName := attemptJobName(pj, currentAttempt(pj))
+	config := currentConfig()
+	terminationMessagePath := "/dev/termination-log" // a JSON object written here becomes the outputs of the step
 
 	// variables to collect information about volumes
 	volumes := []corev1.Volume{}
@@ -38,8 +39,8 @@ func (r *PipelineJobReconciler) CreateJob(ctx context.Context, log func(string,
 
 	// add volume for config (points to config map with name of pipeline
 	configVolumeName := "config"
-	configFileName := "config.json"
-	configLocation := "/etc/config"
+	configFileName := config.ConfigFileName
+	configLocation := config.ConfigDirectory
 	configVolume := corev1.Volume{
 		Name: configVolumeName,
 		VolumeSource: corev1.VolumeSource{
@@ -56,20 +57,25 @@ func (r *PipelineJobReconciler) CreateJob(ctx context.Context, log func(string,
 			},
 		},
 	}
-	volumes = append(volumes, configVolume)
-	// add volumemount for config
-	volumeMounts = append(volumeMounts, getVolumeMount(configVolumeName, configLocation))
+	if !pj.Spec.TerminationJob {
+		volumes = append(volumes, configVolume)
+		// add volumemount for config
+		volumeMounts = append(volumeMounts, getVolumeMount(configVolumeName, configLocation))
+	}
 
 	// settings working directory
-	var sizeInGB int64 = 1
-	workdirPath := "/workdir"
+	workdirSizeLimit := resource.MustParse(DefaultWorkdirSizeLimit)
+	if pj.Spec.WorkdirSizeLimit != nil {
+		workdirSizeLimit = pj.Spec.WorkdirSizeLimit.DeepCopy()
+	}
+	workdirPath := config.WorkdirPath
 	workdirVolumeName := "workdir"
 	workdirVolume := corev1.Volume{
 		Name: workdirVolumeName,
 		VolumeSource: corev1.VolumeSource{
 			EmptyDir: &corev1.EmptyDirVolumeSource{
 				Medium:    "",
-				SizeLimit: resource.NewQuantity(sizeInGB*(1<<30), resource.BinarySI),
+				SizeLimit: &workdirSizeLimit,
 			},
 		},
 	}
@@ -77,23 +83,55 @@ func (r *PipelineJobReconciler) CreateJob(ctx context.Context, log func(string,
 	volumeMounts = append(volumeMounts, getVolumeMount(workdirVolumeName, workdirPath))
 	addInitCommand(&initCommands, "mkdir", "input")
 
-	// collect settings for inputs
+	// collect settings for inputs (artifacts are downloaded by a separate init container)
+	var downloads []string
 	for _, in := range pj.Spec.Inputs {
-		if !volumePresentAlready(in.Volume, volumes) {
-			volumes = append(volumes, getVolume(in.Volume, true))
-			volumeMounts = append(volumeMounts, getVolumeMount(in.Volume, in.MountPath))
-			addInitCommand(&initCommands, "ln", "-s", in.MountPath+"/"+in.SourceFile, "/workdir/input/"+in.TargetFile)
+		if in.VolumeType == VolumeTypeArtifact {
+			downloads = append(downloads, downloadCommand(in.Volume+"/"+in.SourceFile, workdirPath+"/input/"+in.TargetFile))
+			continue
 		}
+		if !volumePresentAlready(inputVolumeName(in), volumes) {
+			volumes = append(volumes, getInputVolume(in))
+			volumeMounts = append(volumeMounts, getVolumeMount(inputVolumeName(in), in.MountPath))
+		}
+		// several pipes may read from the same volume
+		addInitCommand(&initCommands, "ln", "-s", in.MountPath+"/"+in.SourceFile, workdirPath+"/input/"+in.TargetFile)
+	}
+	// add output volume for the step (termination jobs have none, outputs of the artifact store are uploaded)
+	if len(pj.Spec.OutputArtifact) > 0 {
+		addInitCommand(&initCommands, "mkdir", "output")
+	} else if !pj.Spec.TerminationJob {
+		stepId := pj.Spec.StepId
+		volume := pj.Name // volume and volume claim get same name as pipeline job from which the data comes
+		volumes = append(volumes, getVolume(volume, false))
+		outMountPath := getMountPath(stepId)
+		volumeMounts = append(volumeMounts, getVolumeMount(volume, outMountPath))
+		// remove leftovers of previous attempts
+		addInitCommand(&initCommands, "find", outMountPath, "-mindepth", "1", "-delete")
+		addInitCommand(&initCommands, "ln", "-s", outMountPath, "output")
 	}
-	// add output volume for the step
-	stepId := pj.Spec.StepId
-	volume := jobName // volume and volume claim get same name as job from which the data comes
-	volumes = append(volumes, getVolume(volume, false))
-	outMountPath := getMountPath(stepId)
-	volumeMounts = append(volumeMounts, getVolumeMount(volume, outMountPath))
-	addInitCommand(&initCommands, "ln", "-s", outMountPath, "output")
 	addInitCommand(&initCommands, "echo", "Initialization", "done")
 
+	// add the secrets mounted into the step container (the init container does not get them)
+	js := pj.Spec.JobSpec
+	stepVolumeMounts := append([]corev1.VolumeMount{}, volumeMounts...)
+	for i, sm := range js.SecretMounts {
+		volumeName := fmt.Sprintf("secret-mount-%d", i)
+		volumes = append(volumes, corev1.Volume{
+			Name: volumeName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: sm.SecretName,
+					Items:      sm.Items,
+					Optional:   sm.Optional,
+				},
+			},
+		})
+		stepVolumeMounts = append(stepVolumeMounts, corev1.VolumeMount{Name: volumeName, MountPath: sm.MountPath, ReadOnly: true})
+	}
+	// environment variables provided by the operator take precedence
+	env := append(append([]corev1.EnvVar{}, js.Env...), pj.Spec.Env...)
+
 	// add local workdir volume
 	//stepId := pj.Spec.StepId
 	//volume := jobName // volume and volume claim get same name as job from which the data comes
@@ -102,33 +140,33 @@ func (r *PipelineJobReconciler) CreateJob(ctx context.Context, log func(string,
 
 	jobContainer := corev1.Container{
 		Name:                     "main",
-		Image:                    pj.Spec.JobSpec.Image,
-		Command:                  pj.Spec.JobSpec.Command,
-		Args:                     pj.Spec.JobSpec.Args,
-		WorkingDir:               pj.Spec.JobSpec.WorkingDir,
+		Image:                    js.Image,
+		Command:                  js.Command,
+		Args:                     js.Args,
+		WorkingDir:               js.WorkingDir,
 		Ports:                    []corev1.ContainerPort{},
-		EnvFrom:                  []corev1.EnvFromSource{},
-		Env:                      []corev1.EnvVar{},
-		Resources:                resources,
+		EnvFrom:                  js.EnvFrom,
+		Env:                      env,
+		Resources:                js.Resources,
 		ResizePolicy:             []corev1.ContainerResizePolicy{},
 		RestartPolicy:            nil, // only for init containers
-		VolumeMounts:             volumeMounts,
+		VolumeMounts:             stepVolumeMounts,
 		VolumeDevices:            []corev1.VolumeDevice{},
 		LivenessProbe:            nil,                    // TODO
 		ReadinessProbe:           nil,                    // TODO
 		StartupProbe:             nil,                    // TODO
 		Lifecycle:                nil,                    //
-		TerminationMessagePath:   terminationMessagePath, // TODO use this!
-		TerminationMessagePolicy: "File",                 // TODO
-		ImagePullPolicy:          pj.Spec.JobSpec.ImagePullPolicy,
+		TerminationMessagePath:   terminationMessagePath, // read back by readStepOutputs
+		TerminationMessagePolicy: "File",                 //
+		ImagePullPolicy:          js.ImagePullPolicy,
 		SecurityContext:          nil,   // TODO !!!
 		Stdin:                    false, // TODO is this security critical?
 		StdinOnce:                false,
 		TTY:                      false, // TODO is this security critical?
 	}
 
-	shellImage := "bash"
-	shellCommand := "bash"
+	shellImage := config.InitImage
+	shellCommand := config.Shell
 	initContainer := corev1.Container{
 		Name:            "init",
 		Image:           shellImage,
@@ -138,17 +176,64 @@ func (r *PipelineJobReconciler) CreateJob(ctx context.Context, log func(string,
 		VolumeMounts:    volumeMounts,
 		ImagePullPolicy: pj.Spec.JobSpec.ImagePullPolicy,
 	}
+	initContainers := []corev1.Container{initContainer}
+	workdirMounts := []corev1.VolumeMount{getVolumeMount(workdirVolumeName, workdirPath)}
+	if len(downloads) > 0 {
+		download, err := transferContainer("download", pj.Spec.ArtifactStore, downloads, workdirMounts)
+		if err != nil {
+			return nil, err
+		}
+		initContainers = append(initContainers, download)
+	}
+	if len(pj.Spec.OutputArtifact) > 0 {
+		// the step container runs as last init container, so the output is only uploaded if it has succeeded
+		upload, err := transferContainer("upload", pj.Spec.ArtifactStore, []string{uploadCommand(workdirPath+"/output", pj.Spec.OutputArtifact)}, workdirMounts)
+		if err != nil {
+			return nil, err
+		}
+		initContainers = append(initContainers, jobContainer)
+		jobContainer = upload
+	}
 	// define the job object
-	js := pj.Spec.JobSpec
-	job, err := defineJob(jobName, pj.Namespace, js.Image,
-		js.ActiveDeadlineSeconds, js.BackoffLimit, js.TTLSecondsAfterFinished, js.TerminationGracePeriodSeconds,
+	backoffLimit := js.BackoffLimit
+	restartPolicy := corev1.RestartPolicyOnFailure
+	if pj.Spec.RetryPolicy != nil {
+		// retries are done with fresh jobs, each job runs exactly one pod
+		var noBackoff int32 = 0
+		backoffLimit = &noBackoff
+		restartPolicy = corev1.RestartPolicyNever
+	}
+	// the image of the step is checked against the image policy, its class is attached to the pod as label
+	policy, err := getImagePolicy(r, ctx)
+	if err != nil {
+		return nil, err
+	}
+	imageClass, violation := policy.admit(pj.Namespace, js.Image)
+	if len(violation) > 0 {
+		return nil, errors.New(violation)
+	}
+	job, err := defineJob(jobName, pj.Namespace, config, policy.classLabel(), imageClass,
+		js.ActiveDeadlineSeconds, backoffLimit, js.TTLSecondsAfterFinished, js.TerminationGracePeriodSeconds,
 		volumes,
-		[]corev1.Container{initContainer},
+		initContainers,
 		jobContainer,
-		js.ServiceAccountName)
+		js.ServiceAccountName,
+		js.ImagePullSecrets,
+		restartPolicy)
 	if err != nil {
 		return nil, err
 	}
+	// tolerations, affinity, security contexts, sidecars etc. given by the specification of the job spec
+	original := job.Spec.Template.DeepCopy()
+	if err := applySpecification(job, js.Specification); err != nil {
+		return nil, err
+	}
+	// the images of sidecars and init containers added by the specification must be admitted as well
+	for _, c := range addedContainers(original, &job.Spec.Template) {
+		if _, violation := policy.admit(pj.Namespace, c.Image); len(violation) > 0 {
+			return nil, errors.New("container " + c.Name + ": " + violation)
+		}
+	}
 
 	// Set the ownerRef for the Job
 	// More info: https://kubernetes.io/docs/concepts/overview/working-with-objects/owners-dependents/
@@ -172,7 +257,9 @@ func (r *PipelineJobReconciler) CreateJob(ctx context.Context, log func(string,
 func defineJob(
 	jobName string,
 	namespace string,
-	image string,
+	config *OperatorConfig,
+	imageClassLabel string,
+	imageClass string,
 	activeDeadlineSeconds *int64,
 	backoffLimit *int32,
 	ttlSecondsAfterFinished *int32,
@@ -181,6 +268,8 @@ func defineJob(
 	initContainers []corev1.Container,
 	jobContainer corev1.Container,
 	serviceAccountName string,
+	imagePullSecrets []corev1.LocalObjectReference,
+	restartPolicy corev1.RestartPolicy,
 ) (*batchv1.Job, error) {
 	var one int32 = 1
 	nonIndexed := batchv1.NonIndexedCompletion
@@ -188,36 +277,21 @@ func defineJob(
 	replaceAfterFailed := batchv1.Failed
 
 	// the labels to be attached to job
-	jobLabels := map[string]string{
-		"app.kubernetes.io/name":       "PipelineSchedule",
-		"app.kubernetes.io/instance":   jobName,
-		"app.kubernetes.io/version":    "v1",
-		"app.kubernetes.io/part-of":    "pipeline-operator",
-		"app.kubernetes.io/created-by": "controller-manager", // TODO should we change this?
-	}
+	jobLabels := config.resourceLabels("PipelineSchedule", jobName)
 
-	// the labels attached to pod
 	// the labels to be attached to the pod
-	imageRepoClassLabel := "breuninger.de/image-repo-class"
-	repoClasses := [][]string{
-		{"europe-west3-docker.pkg.dev/breuni-team-admin-ace/", "trusted"},
-		{"europe-west3-docker.pkg.dev/breuni-team-admin-", "tenant"},
+	podLabels := map[string]string{}
+	for key, value := range config.PodLabels {
+		podLabels[key] = value
 	}
-	imageRepoClass := determineRepoClass(repoClasses, image)
-	if imageRepoClass == nil {
-		return nil, errors.New("Docker repository for payload image was not found in whitelist")
-	}
-	podLabels := map[string]string{
-		"app.kubernetes.io/name":       "PipelineJob",
-		"app.kubernetes.io/instance":   jobName,
-		"app.kubernetes.io/version":    "v1",
-		"app.kubernetes.io/part-of":    "pipeline-operator",
-		"app.kubernetes.io/created-by": "controller-manager", // TODO should we change this?
-		imageRepoClassLabel:            *imageRepoClass,
+	for key, value := range config.resourceLabels("PipelineJob", jobName) {
+		podLabels[key] = value
 	}
+	podLabels[imageClassLabel] = imageClass
 
-	nodeSelector := map[string]string{
-		"topology.kubernetes.io/zone": "europe-west3-b",
+	nodeSelector := map[string]string{}
+	for key, value := range config.NodeSelector {
+		nodeSelector[key] = value
 	}
 
 	res := batchv1.Job{
@@ -244,7 +318,7 @@ func defineJob(
 					InitContainers:                initContainers,
 					Containers:                    []corev1.Container{jobContainer},
 					EphemeralContainers:           nil,
-					RestartPolicy:                 corev1.RestartPolicyOnFailure,
+					RestartPolicy:                 restartPolicy,
 					TerminationGracePeriodSeconds: terminationGracePeriodSeconds,
 					// using same TTL on pod level, needed for ResourceQuota reasons, see also https://stackoverflow.com/questions/53506010/kubernetes-difference-between-activedeadlineseconds-in-job-and-pod
 					ActiveDeadlineSeconds:        activeDeadlineSeconds,
@@ -256,9 +330,9 @@ func defineJob(
 					HostNetwork:                  false,
 					HostPID:                      false,
 					HostIPC:                      false,
-					ShareProcessNamespace:        nil,                             // TODO
-					SecurityContext:              nil,                             // TODO
-					ImagePullSecrets:             []corev1.LocalObjectReference{}, // TODO
+					ShareProcessNamespace:        nil, // TODO
+					SecurityContext:              nil, // TODO
+					ImagePullSecrets:             imagePullSecrets,
 					//Hostname: nil,
 					//Subdomain: nil,
 					HostAliases: nil, // TODO
@@ -285,15 +359,6 @@ func defineJob(
 	return &res, nil
 }
 
-func determineRepoClass(classes [][]string, image string) *string {
-	for _, prefixClass := range classes {
-		if strings.HasPrefix(image, prefixClass[0]) {
-			return &prefixClass[1]
-		}
-	}
-	return nil
-}
-
 func addInitCommand(commands *string, command ...string) {
 	if len(*commands) != 0 {
 		*commands = *commands + " && "
@@ -330,7 +395,7 @@ func getVolumeMount(volume string, mountPath string) corev1.VolumeMount {
 }
 
 func getMountPath(stepId string) string {
-	return "/vol/" + stepId
+	return currentConfig().VolumeMountRoot + "/" + stepId
 }
 
 func isTrueInJob(j *batchv1.Job, conditionType batchv1.JobConditionType) bool {
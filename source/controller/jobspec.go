@@ -0,0 +1,174 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"strings"
+)
+
+const (
+	/*
+		name of the config map holding the job defaults of a namespace, the keys are requests.<resource> and
+		limits.<resource> (e.g. requests.cpu or limits.memory) and imagePullSecrets (comma separated)
+	*/
+	JobDefaultsConfigMap = "pipeline-job-defaults"
+)
+
+// the job defaults config map of a namespace, returns nil if it does not exist
+func (r *PipelineRunReconciler) getJobDefaultsConfig(ctx context.Context, namespace string) (*corev1.ConfigMap, error) {
+	res := &corev1.ConfigMap{}
+	notexists, err := NotExistsResource(r, ctx, res, types.NamespacedName{Namespace: namespace, Name: JobDefaultsConfigMap})
+	if notexists {
+		res = nil
+	}
+	return res, err
+}
+
+// the limit ranges of a namespace
+func (r *PipelineRunReconciler) getLimitRanges(ctx context.Context, namespace string) ([]corev1.LimitRange, error) {
+	list := &corev1.LimitRangeList{}
+	if err := r.List(ctx, list, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// resource names of a resource list in alphabetical order
+func resourceNames(rl corev1.ResourceList) []corev1.ResourceName {
+	var res []corev1.ResourceName
+	for name := range rl {
+		res = append(res, name)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i] < res[j]
+	})
+	return res
+}
+
+// set the requests or limits (given by the key prefix) that are not specified from the config map
+func defaultResources(rl *corev1.ResourceList, cm *corev1.ConfigMap, prefix string) error {
+	var keys []string
+	for key := range cm.Data {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		name := corev1.ResourceName(strings.TrimPrefix(key, prefix))
+		if _, found := (*rl)[name]; found {
+			continue
+		}
+		q, err := resource.ParseQuantity(strings.TrimSpace(cm.Data[key]))
+		if err != nil {
+			return fmt.Errorf("invalid value of %s in config map %s: %s", key, JobDefaultsConfigMap, cm.Data[key])
+		}
+		if *rl == nil {
+			*rl = corev1.ResourceList{}
+		}
+		(*rl)[name] = q
+	}
+	return nil
+}
+
+// check the resources of a container against the container limits of the limit ranges of the namespace
+func checkLimitRanges(resources corev1.ResourceRequirements, limitRanges []corev1.LimitRange) error {
+	kinds := []string{"request", "limit"}
+	for _, lr := range limitRanges {
+		for _, item := range lr.Spec.Limits {
+			if item.Type != corev1.LimitTypeContainer {
+				continue
+			}
+			for _, name := range resourceNames(item.Min) {
+				min := item.Min[name]
+				for kind, rl := range []corev1.ResourceList{resources.Requests, resources.Limits} {
+					if q, found := rl[name]; found && (q.Cmp(min) < 0) {
+						return fmt.Errorf("%s %s %s is below the minimum of %s of limit range %s", name, kinds[kind], q.String(), min.String(), lr.Name)
+					}
+				}
+			}
+			for _, name := range resourceNames(item.Max) {
+				max := item.Max[name]
+				for kind, rl := range []corev1.ResourceList{resources.Requests, resources.Limits} {
+					if q, found := rl[name]; found && (q.Cmp(max) > 0) {
+						return fmt.Errorf("%s %s %s exceeds the maximum of %s of limit range %s", name, kinds[kind], q.String(), max.String(), lr.Name)
+					}
+				}
+			}
+			for _, name := range resourceNames(item.MaxLimitRequestRatio) {
+				ratio := item.MaxLimitRequestRatio[name]
+				request, hasRequest := resources.Requests[name]
+				limit, hasLimit := resources.Limits[name]
+				if hasRequest && hasLimit && !request.IsZero() && (limit.AsApproximateFloat64()/request.AsApproximateFloat64() > ratio.AsApproximateFloat64()) {
+					return fmt.Errorf("%s limit %s exceeds %s times the request %s (limit range %s)", name, limit.String(), ratio.String(), request.String(), lr.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+/*
+determine the job spec of a step from its spec and the defaults of the namespace (cm may be nil), the resources are
+checked against the limit ranges of the namespace, so that the pods of the step are not rejected
+*/
+func resolveJobSpec(js *pipelinev1.JobSpec, cm *corev1.ConfigMap, limitRanges []corev1.LimitRange) (*pipelinev1.JobSpec, error) {
+	res := js.DeepCopy()
+	if cm != nil {
+		if err := defaultResources(&res.Resources.Requests, cm, "requests."); err != nil {
+			return nil, err
+		}
+		if err := defaultResources(&res.Resources.Limits, cm, "limits."); err != nil {
+			return nil, err
+		}
+		if secrets := strings.TrimSpace(cm.Data["imagePullSecrets"]); (len(res.ImagePullSecrets) == 0) && (len(secrets) > 0) {
+			for _, secret := range strings.Split(secrets, ",") {
+				res.ImagePullSecrets = append(res.ImagePullSecrets, corev1.LocalObjectReference{Name: strings.TrimSpace(secret)})
+			}
+		}
+	}
+	for _, name := range resourceNames(res.Resources.Limits) {
+		limit := res.Resources.Limits[name]
+		if request, found := res.Resources.Requests[name]; found && (request.Cmp(limit) > 0) {
+			return nil, fmt.Errorf("%s request %s exceeds the limit of %s", name, request.String(), limit.String())
+		}
+	}
+	if err := checkLimitRanges(res.Resources, limitRanges); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+/*
+the job spec of a step after applying the defaults of the namespace. If the job spec is invalid or its image is not
+admitted by the image policy, the reason is returned as message (the error is only set in case of failures when
+accessing the api server or of an invalid image policy).
+*/
+func (r *PipelineRunReconciler) jobSpecWithDefaults(ctx context.Context, namespace string, js *pipelinev1.JobSpec) (*pipelinev1.JobSpec, string, error) {
+	cm, err := r.getJobDefaultsConfig(ctx, namespace)
+	if err != nil {
+		return nil, "", err
+	}
+	limitRanges, err := r.getLimitRanges(ctx, namespace)
+	if err != nil {
+		return nil, "", err
+	}
+	res, err := resolveJobSpec(js, cm, limitRanges)
+	if err != nil {
+		return nil, err.Error(), nil
+	}
+	policy, err := getImagePolicy(r, ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, violation := policy.admitJobSpec(namespace, res); len(violation) > 0 {
+		return nil, violation, nil
+	}
+	return res, "", nil
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Job spec defaults", func() {
+	resources := func(cpu string, memory string) corev1.ResourceList {
+		res := corev1.ResourceList{}
+		if len(cpu) > 0 {
+			res[corev1.ResourceCPU] = resource.MustParse(cpu)
+		}
+		if len(memory) > 0 {
+			res[corev1.ResourceMemory] = resource.MustParse(memory)
+		}
+		return res
+	}
+	config := func(data map[string]string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{Data: data}
+	}
+	limitRange := func(item corev1.LimitRangeItem) []corev1.LimitRange {
+		item.Type = corev1.LimitTypeContainer
+		return []corev1.LimitRange{{ObjectMeta: metav1.ObjectMeta{Name: "limits"}, Spec: corev1.LimitRangeSpec{Limits: []corev1.LimitRangeItem{item}}}}
+	}
+
+	It("should take unset requests, limits and pull secrets from the namespace defaults", func() {
+		js := &pipelinev1.JobSpec{Resources: corev1.ResourceRequirements{Requests: resources("2", "")}}
+		cm := config(map[string]string{"requests.cpu": "100m", "requests.memory": "256Mi", "limits.memory": "1Gi", "imagePullSecrets": "registry, mirror"})
+		res, err := resolveJobSpec(js, cm, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res.Resources.Requests).To(Equal(resources("2", "256Mi")))
+		Expect(res.Resources.Limits).To(Equal(resources("", "1Gi")))
+		Expect(res.ImagePullSecrets).To(Equal([]corev1.LocalObjectReference{{Name: "registry"}, {Name: "mirror"}}))
+		Expect(js.Resources.Requests).To(HaveLen(1))
+	})
+
+	It("should keep the pull secrets of the job spec", func() {
+		js := &pipelinev1.JobSpec{ImagePullSecrets: []corev1.LocalObjectReference{{Name: "own"}}}
+		res, err := resolveJobSpec(js, config(map[string]string{"imagePullSecrets": "registry"}), nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res.ImagePullSecrets).To(Equal([]corev1.LocalObjectReference{{Name: "own"}}))
+	})
+
+	It("should reject invalid defaults and requests exceeding limits", func() {
+		_, err := resolveJobSpec(&pipelinev1.JobSpec{}, config(map[string]string{"limits.cpu": "lots"}), nil)
+		Expect(err).To(HaveOccurred())
+		js := &pipelinev1.JobSpec{Resources: corev1.ResourceRequirements{Requests: resources("2", "")}}
+		_, err = resolveJobSpec(js, config(map[string]string{"limits.cpu": "1"}), nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should check the resources against the limit ranges of the namespace", func() {
+		js := &pipelinev1.JobSpec{Resources: corev1.ResourceRequirements{Requests: resources("500m", "1Gi"), Limits: resources("4", "1Gi")}}
+		_, err := resolveJobSpec(js, nil, limitRange(corev1.LimitRangeItem{Max: resources("2", "")}))
+		Expect(err).To(MatchError(ContainSubstring("cpu limit 4 exceeds the maximum of 2")))
+		_, err = resolveJobSpec(js, nil, limitRange(corev1.LimitRangeItem{Min: resources("", "2Gi")}))
+		Expect(err).To(MatchError(ContainSubstring("memory request 1Gi is below the minimum")))
+		_, err = resolveJobSpec(js, nil, limitRange(corev1.LimitRangeItem{MaxLimitRequestRatio: resources("4", "")}))
+		Expect(err).To(MatchError(ContainSubstring("exceeds 4 times the request")))
+		_, err = resolveJobSpec(js, nil, limitRange(corev1.LimitRangeItem{Max: resources("4", "2Gi"), MaxLimitRequestRatio: resources("8", "")}))
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
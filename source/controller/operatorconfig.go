@@ -0,0 +1,436 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"os"
+	"path"
+	"reflect"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// environment variable with the path of the operator configuration file (defaults to DefaultOperatorConfigFile)
+	OperatorConfigFileEnv     = "OPERATOR_CONFIG_FILE"
+	DefaultOperatorConfigFile = "/etc/pipeline-operator/config.yaml"
+	/*
+		name of the config map in the namespace of the operator holding the operator configuration (key config.yaml),
+		it is used if the configuration file does not exist
+	*/
+	OperatorConfigMap = "pipeline-operator-config"
+	OperatorConfigKey = "config.yaml"
+	// interval in which changes of the operator configuration are picked up
+	OperatorConfigReloadInterval = 30 * time.Second
+	/*
+		annotation of pipeline runs holding the label keys they were created with (see LabelKeys), labels read back by
+		the operator are looked up with these keys, so changes of the keys do not affect existing runs
+	*/
+	LabelKeysAnnotation = "k-pipe.cloud/label-keys"
+)
+
+/*
+OperatorConfig holds the cluster specific settings of the operator, given in YAML (or JSON). Fields that are not set
+take the defaults of DefaultOperatorConfig, an empty map (e.g. nodeSelector: {}) disables the default.
+*/
+type OperatorConfig struct {
+	// node selector of the pods of job steps (default topology.kubernetes.io/zone: europe-west3-b)
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// additional labels of the pods of job steps
+	PodLabels map[string]string `json:"podLabels,omitempty"`
+	/*
+		annotations of the service accounts created for job steps, {name} and {namespace} in the values are replaced by
+		the name and namespace of the service account (default iam.gke.io/gcp-service-account:
+		{name}@breuni-team-admin-{namespace}.iam.gserviceaccount.com for GKE workload identity)
+	*/
+	ServiceAccountAnnotations map[string]string `json:"serviceAccountAnnotations,omitempty"`
+	// image of the init containers of job steps and of the jobs of the operator, must provide the shell (default bash)
+	InitImage string `json:"initImage,omitempty"`
+	// image of the jobs writing batch manifests to config maps, must provide the shell and kubectl (default bitnami/kubectl)
+	KubectlImage string `json:"kubectlImage,omitempty"`
+	// shell running the scripts of init containers and of the jobs of the operator, called with -c (default bash)
+	Shell string `json:"shell,omitempty"`
+	// working directory of the step container (default /workdir)
+	WorkdirPath string `json:"workdirPath,omitempty"`
+	// directory below which the volumes of pipes are mounted (default /vol)
+	VolumeMountRoot string `json:"volumeMountRoot,omitempty"`
+	// directory holding the config file of the step (default /etc/config)
+	ConfigDirectory string `json:"configDirectory,omitempty"`
+	// name of the config file of the step (default config.json)
+	ConfigFileName string `json:"configFileName,omitempty"`
+	// key of the pod label holding the class of the image, unless given by the image policy (default breuninger.de/image-repo-class)
+	ImageClassLabel string `json:"imageClassLabel,omitempty"`
+	/*
+		keys of the labels attached to the resources created by the operator, changes apply to resources created
+		afterwards (runs keep the keys they were created with, see LabelKeysAnnotation)
+	*/
+	LabelKeys LabelKeys `json:"labelKeys,omitempty"`
+}
+
+// LabelKeys are the keys of the labels the operator attaches to the resources it creates
+type LabelKeys struct {
+	// kind of the resource (default app.kubernetes.io/name)
+	Name string `json:"name,omitempty"`
+	// name of the resource (default app.kubernetes.io/instance)
+	Instance string `json:"instance,omitempty"`
+	// version of the labelling (default app.kubernetes.io/version)
+	Version string `json:"version,omitempty"`
+	// the operator the resource belongs to (default app.kubernetes.io/part-of)
+	PartOf string `json:"partOf,omitempty"`
+	// the component that created the resource (default app.kubernetes.io/created-by)
+	CreatedBy string `json:"createdBy,omitempty"`
+	// id of the step in the parent run that is executed by a child run (default k-pipe.cloud/parent-step)
+	ParentStep string `json:"parentStep,omitempty"`
+	// index of the batch item that is processed by a child run (default k-pipe.cloud/batch-index)
+	BatchIndex string `json:"batchIndex,omitempty"`
+	// name of the schedule that created a run (default k-pipe.cloud/pipeline-schedule)
+	PipelineSchedule string `json:"pipelineSchedule,omitempty"`
+}
+
+// DefaultOperatorConfig returns the configuration used if the operator is not configured
+func DefaultOperatorConfig() *OperatorConfig {
+	return &OperatorConfig{
+		NodeSelector: map[string]string{
+			"topology.kubernetes.io/zone": "europe-west3-b",
+		},
+		PodLabels: map[string]string{},
+		ServiceAccountAnnotations: map[string]string{
+			"iam.gke.io/gcp-service-account": "{name}@breuni-team-admin-{namespace}.iam.gserviceaccount.com",
+		},
+		InitImage:       "bash",
+		KubectlImage:    "bitnami/kubectl",
+		Shell:           "bash",
+		WorkdirPath:     "/workdir",
+		VolumeMountRoot: "/vol",
+		ConfigDirectory: "/etc/config",
+		ConfigFileName:  "config.json",
+		ImageClassLabel: DefaultImageClassLabel,
+		LabelKeys: LabelKeys{
+			Name:             "app.kubernetes.io/name",
+			Instance:         "app.kubernetes.io/instance",
+			Version:          "app.kubernetes.io/version",
+			PartOf:           "app.kubernetes.io/part-of",
+			CreatedBy:        "app.kubernetes.io/created-by",
+			ParentStep:       "k-pipe.cloud/parent-step",
+			BatchIndex:       "k-pipe.cloud/batch-index",
+			PipelineSchedule: "k-pipe.cloud/pipeline-schedule",
+		},
+	}
+}
+
+var (
+	// the current operator configuration, nil until it has been loaded
+	operatorConfig atomic.Pointer[OperatorConfig]
+	// the configuration is loaded once when the first controller is set up
+	operatorConfigOnce  sync.Once
+	operatorConfigError error
+)
+
+// the current operator configuration (the defaults if it has not been loaded)
+func currentConfig() *OperatorConfig {
+	if res := operatorConfig.Load(); res != nil {
+		return res
+	}
+	return DefaultOperatorConfig()
+}
+
+// activate an operator configuration, the webhook is told about the directories used by the operator
+func setOperatorConfig(c *OperatorConfig) {
+	operatorConfig.Store(c)
+	pipelinev1.SetReservedMountPaths(c.WorkdirPath, c.ConfigDirectory, c.VolumeMountRoot)
+}
+
+// the labels attached to a resource created by the operator, kind is the kind of the resource and instance its name
+func (c *OperatorConfig) resourceLabels(kind string, instance string) map[string]string {
+	return map[string]string{
+		c.LabelKeys.Name:      kind,
+		c.LabelKeys.Instance:  instance,
+		c.LabelKeys.Version:   "v1",
+		c.LabelKeys.PartOf:    "pipeline-operator",
+		c.LabelKeys.CreatedBy: "controller-manager", // TODO should we change this?
+	}
+}
+
+// the value of LabelKeysAnnotation for resources created with this configuration
+func (c *OperatorConfig) labelKeysAnnotation() string {
+	data, err := json.Marshal(c.LabelKeys)
+	if err != nil {
+		// can not happen for a struct of strings
+		return ""
+	}
+	return string(data)
+}
+
+/*
+the label keys a resource was created with, the keys missing in its LabelKeysAnnotation (e.g. for resources created
+before the keys were recorded) are the current ones
+*/
+func createdLabelKeys(obj client.Object) LabelKeys {
+	res := currentConfig().LabelKeys
+	if data, found := obj.GetAnnotations()[LabelKeysAnnotation]; found {
+		if err := json.Unmarshal([]byte(data), &res); err != nil {
+			return currentConfig().LabelKeys
+		}
+	}
+	return res
+}
+
+// parse and validate an operator configuration, unset fields take the defaults
+func parseOperatorConfig(data string) (*OperatorConfig, error) {
+	res := &OperatorConfig{}
+	if err := yaml.UnmarshalStrict([]byte(data), res); err != nil {
+		return nil, err
+	}
+	res.applyDefaults()
+	if err := res.validate(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *OperatorConfig) applyDefaults() {
+	defaults := DefaultOperatorConfig()
+	if c.NodeSelector == nil {
+		c.NodeSelector = defaults.NodeSelector
+	}
+	if c.PodLabels == nil {
+		c.PodLabels = defaults.PodLabels
+	}
+	if c.ServiceAccountAnnotations == nil {
+		c.ServiceAccountAnnotations = defaults.ServiceAccountAnnotations
+	}
+	for _, field := range []struct {
+		value        *string
+		defaultValue string
+	}{
+		{&c.InitImage, defaults.InitImage},
+		{&c.KubectlImage, defaults.KubectlImage},
+		{&c.Shell, defaults.Shell},
+		{&c.WorkdirPath, defaults.WorkdirPath},
+		{&c.VolumeMountRoot, defaults.VolumeMountRoot},
+		{&c.ConfigDirectory, defaults.ConfigDirectory},
+		{&c.ConfigFileName, defaults.ConfigFileName},
+		{&c.ImageClassLabel, defaults.ImageClassLabel},
+		{&c.LabelKeys.Name, defaults.LabelKeys.Name},
+		{&c.LabelKeys.Instance, defaults.LabelKeys.Instance},
+		{&c.LabelKeys.Version, defaults.LabelKeys.Version},
+		{&c.LabelKeys.PartOf, defaults.LabelKeys.PartOf},
+		{&c.LabelKeys.CreatedBy, defaults.LabelKeys.CreatedBy},
+		{&c.LabelKeys.ParentStep, defaults.LabelKeys.ParentStep},
+		{&c.LabelKeys.BatchIndex, defaults.LabelKeys.BatchIndex},
+		{&c.LabelKeys.PipelineSchedule, defaults.LabelKeys.PipelineSchedule},
+	} {
+		if len(*field.value) == 0 {
+			*field.value = field.defaultValue
+		}
+	}
+}
+
+// check that the keys of a map are qualified names (and its values label values, if requested)
+func validateKeys(name string, m map[string]string, labelValues bool) error {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if msgs := validation.IsQualifiedName(key); len(msgs) > 0 {
+			return fmt.Errorf("invalid key %s of %s: %s", key, name, strings.Join(msgs, ", "))
+		}
+		if msgs := validation.IsValidLabelValue(m[key]); labelValues && (len(msgs) > 0) {
+			return fmt.Errorf("invalid value %s of %s: %s", m[key], name, strings.Join(msgs, ", "))
+		}
+	}
+	return nil
+}
+
+func (c *OperatorConfig) validate() error {
+	if err := validateKeys("nodeSelector", c.NodeSelector, true); err != nil {
+		return err
+	}
+	if err := validateKeys("podLabels", c.PodLabels, true); err != nil {
+		return err
+	}
+	if err := validateKeys("serviceAccountAnnotations", c.ServiceAccountAnnotations, false); err != nil {
+		return err
+	}
+	// the label keys of the operator must be distinct, pod labels must not override them
+	labelKeys := map[string]string{
+		"labelKeys.name":             c.LabelKeys.Name,
+		"labelKeys.instance":         c.LabelKeys.Instance,
+		"labelKeys.version":          c.LabelKeys.Version,
+		"labelKeys.partOf":           c.LabelKeys.PartOf,
+		"labelKeys.createdBy":        c.LabelKeys.CreatedBy,
+		"labelKeys.parentStep":       c.LabelKeys.ParentStep,
+		"labelKeys.batchIndex":       c.LabelKeys.BatchIndex,
+		"labelKeys.pipelineSchedule": c.LabelKeys.PipelineSchedule,
+		"imageClassLabel":            c.ImageClassLabel,
+	}
+	var names []string
+	for name := range labelKeys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	used := map[string]string{}
+	for _, name := range names {
+		key := labelKeys[name]
+		if msgs := validation.IsQualifiedName(key); len(msgs) > 0 {
+			return fmt.Errorf("invalid %s %s: %s", name, key, strings.Join(msgs, ", "))
+		}
+		if other, found := used[key]; found {
+			return errors.New(name + " " + key + " conflicts with " + other)
+		}
+		used[key] = name
+	}
+	var podLabelKeys []string
+	for key := range c.PodLabels {
+		podLabelKeys = append(podLabelKeys, key)
+	}
+	sort.Strings(podLabelKeys)
+	for _, key := range podLabelKeys {
+		if name, found := used[key]; found {
+			return errors.New("podLabels must not set " + key + " (set by the operator as " + name + ")")
+		}
+	}
+	if strings.ContainsAny(c.Shell, " \t") {
+		return errors.New("shell must be a single command without arguments: " + c.Shell)
+	}
+	dirs := []struct {
+		name  string
+		value string
+	}{
+		{"workdirPath", c.WorkdirPath},
+		{"volumeMountRoot", c.VolumeMountRoot},
+		{"configDirectory", c.ConfigDirectory},
+	}
+	for i, dir := range dirs {
+		if !path.IsAbs(dir.value) || (path.Clean(dir.value) != dir.value) || (dir.value == "/") {
+			return errors.New(dir.name + " must be a clean absolute path below /: " + dir.value)
+		}
+		for _, other := range dirs[:i] {
+			if (dir.value == other.value) || strings.HasPrefix(dir.value, other.value+"/") || strings.HasPrefix(other.value, dir.value+"/") {
+				return errors.New(dir.name + " " + dir.value + " overlaps with " + other.name + " " + other.value)
+			}
+		}
+	}
+	if strings.Contains(c.ConfigFileName, "/") || (c.ConfigFileName == ".") || (c.ConfigFileName == "..") {
+		return errors.New("configFileName must be a plain file name: " + c.ConfigFileName)
+	}
+	return nil
+}
+
+// the annotations of a service account created for job steps
+func (c *OperatorConfig) serviceAccountAnnotations(namespace string, name string) map[string]string {
+	res := map[string]string{}
+	for key, template := range c.ServiceAccountAnnotations {
+		res[key] = resolve(template, namespace, name)
+	}
+	return res
+}
+
+/*
+load the operator configuration from the configuration file or, if it does not exist, from the config map in the
+namespace of the operator, the defaults are used if neither exists. Returns the configuration and where it came from.
+*/
+func loadOperatorConfig(ctx context.Context, r client.Reader) (*OperatorConfig, string, error) {
+	file := DefaultOperatorConfigFile
+	if value := env(OperatorConfigFileEnv); value != nil {
+		file = *value
+	}
+	data, err := os.ReadFile(file)
+	if err == nil {
+		res, err := parseOperatorConfig(string(data))
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid operator configuration in file %s: %w", file, err)
+		}
+		return res, "file " + file, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, "", err
+	}
+	namespace := operatorNamespace()
+	if len(namespace) == 0 {
+		return DefaultOperatorConfig(), "defaults", nil
+	}
+	cm := &corev1.ConfigMap{}
+	notexists, err := NotExistsResource(r, ctx, cm, types.NamespacedName{Namespace: namespace, Name: OperatorConfigMap})
+	if err != nil {
+		return nil, "", err
+	}
+	if notexists {
+		return DefaultOperatorConfig(), "defaults", nil
+	}
+	res, err := parseOperatorConfig(cm.Data[OperatorConfigKey])
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid operator configuration in config map %s/%s: %w", namespace, OperatorConfigMap, err)
+	}
+	return res, "config map " + namespace + "/" + OperatorConfigMap, nil
+}
+
+/*
+load the operator configuration when the first controller is set up (the manager does not start if it is invalid) and
+register the loader that picks up its changes
+*/
+func setupOperatorConfig(mgr ctrl.Manager) error {
+	operatorConfigOnce.Do(func() {
+		// the cache of the manager is not started yet
+		c, source, err := loadOperatorConfig(context.Background(), mgr.GetAPIReader())
+		if err != nil {
+			operatorConfigError = err
+			return
+		}
+		ctrl.Log.Info("Loaded operator configuration from " + source)
+		setOperatorConfig(c)
+		operatorConfigError = mgr.Add(&OperatorConfigLoader{Reader: mgr.GetAPIReader(), Interval: OperatorConfigReloadInterval})
+	})
+	return operatorConfigError
+}
+
+/*
+OperatorConfigLoader periodically reloads the operator configuration, changes apply to the resources created afterwards.
+An invalid configuration is reported and ignored, the previous one stays active.
+*/
+type OperatorConfigLoader struct {
+	Reader   client.Reader
+	Interval time.Duration
+}
+
+// Start implements manager.Runnable
+func (l *OperatorConfigLoader) Start(ctx context.Context) error {
+	ticker := time.NewTicker(l.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			c, source, err := loadOperatorConfig(ctx, l.Reader)
+			if err != nil {
+				log.FromContext(ctx).Error(err, "Failed to reload operator configuration, keeping the current one")
+				continue
+			}
+			if !reflect.DeepEqual(c, currentConfig()) {
+				log.FromContext(ctx).Info("Reloaded operator configuration from " + source)
+				setOperatorConfig(c)
+			}
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, all replicas need the current configuration
+func (l *OperatorConfigLoader) NeedLeaderElection() bool {
+	return false
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+)
+
+var _ = Describe("Operator configuration", func() {
+	It("should use the defaults for unset fields", func() {
+		config, err := parseOperatorConfig("")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config).To(Equal(DefaultOperatorConfig()))
+		Expect(config.serviceAccountAnnotations("team-a", "lister")).To(Equal(map[string]string{
+			"iam.gke.io/gcp-service-account": "lister@breuni-team-admin-team-a.iam.gserviceaccount.com",
+		}))
+	})
+
+	It("should override the defaults", func() {
+		config, err := parseOperatorConfig(`
+nodeSelector: {}
+podLabels:
+  team: data
+serviceAccountAnnotations:
+  eks.amazonaws.com/role-arn: arn:aws:iam::123456789012:role/{namespace}-{name}
+initImage: registry.example.com/tools/bash:5.2
+workdirPath: /work
+volumeMountRoot: /pipes
+configDirectory: /config
+configFileName: step.json
+imageClassLabel: example.com/image-class
+shell: sh
+labelKeys:
+  name: example.com/kind
+  parentStep: example.com/parent-step
+`)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.NodeSelector).To(BeEmpty())
+		Expect(config.PodLabels).To(Equal(map[string]string{"team": "data"}))
+		Expect(config.serviceAccountAnnotations("team-a", "lister")).To(Equal(map[string]string{
+			"eks.amazonaws.com/role-arn": "arn:aws:iam::123456789012:role/team-a-lister",
+		}))
+		Expect(config.InitImage).To(Equal("registry.example.com/tools/bash:5.2"))
+		Expect(config.WorkdirPath).To(Equal("/work"))
+		Expect(config.VolumeMountRoot).To(Equal("/pipes"))
+		Expect(config.ConfigDirectory).To(Equal("/config"))
+		Expect(config.ConfigFileName).To(Equal("step.json"))
+		Expect(config.ImageClassLabel).To(Equal("example.com/image-class"))
+		Expect(config.Shell).To(Equal("sh"))
+		Expect(config.LabelKeys.ParentStep).To(Equal("example.com/parent-step"))
+		Expect(config.resourceLabels("PipelineRun", "run-1")).To(Equal(map[string]string{
+			"example.com/kind":             "PipelineRun",
+			"app.kubernetes.io/instance":   "run-1",
+			"app.kubernetes.io/version":    "v1",
+			"app.kubernetes.io/part-of":    "pipeline-operator",
+			"app.kubernetes.io/created-by": "controller-manager",
+		}))
+	})
+
+	It("should read labels back with the keys a run was created with", func() {
+		created := DefaultOperatorConfig()
+		created.LabelKeys.ParentStep = "example.com/parent-step"
+		pr := &pipelinev1.PipelineRun{ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{LabelKeysAnnotation: created.labelKeysAnnotation()},
+		}}
+		Expect(createdLabelKeys(pr).ParentStep).To(Equal("example.com/parent-step"))
+		Expect(createdLabelKeys(&pipelinev1.PipelineRun{}).ParentStep).To(Equal(currentConfig().LabelKeys.ParentStep))
+		pr.Annotations[LabelKeysAnnotation] = `{"parentStep":"example.com/step"}`
+		Expect(createdLabelKeys(pr).ParentStep).To(Equal("example.com/step"))
+		Expect(createdLabelKeys(pr).BatchIndex).To(Equal(currentConfig().LabelKeys.BatchIndex))
+	})
+
+	It("should reject invalid configurations", func() {
+		for _, data := range []string{
+			"unknownField: x\n",
+			"nodeSelector:\n  'in valid': x\n",
+			"podLabels:\n  app.kubernetes.io/name: x\n",
+			"podLabels:\n  breuninger.de/image-repo-class: x\n",
+			"imageClassLabel: 'in valid'\n",
+			"imageClassLabel: app.kubernetes.io/name\n",
+			"labelKeys:\n  parentStep: app.kubernetes.io/instance\n",
+			"labelKeys:\n  version: 'in valid'\n",
+			"shell: bash -e\n",
+			"workdirPath: work\n",
+			"workdirPath: /work/\n",
+			"volumeMountRoot: /\n",
+			"volumeMountRoot: /workdir/vol\n",
+			"configDirectory: /vol\n",
+			"configFileName: config/config.json\n",
+		} {
+			_, err := parseOperatorConfig(data)
+			Expect(err).To(HaveOccurred(), data)
+		}
+	})
+
+	It("should mount the volumes of pipes below the configured directory", func() {
+		defer operatorConfig.Store(operatorConfig.Load())
+		config := DefaultOperatorConfig()
+		config.VolumeMountRoot = "/pipes"
+		operatorConfig.Store(config)
+		Expect(getMountPath("stepa")).To(Equal("/pipes/stepa"))
+	})
+})
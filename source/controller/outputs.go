@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+)
+
+const (
+	// maximum size of the outputs of a step in bytes (they are kept in the status of the PipelineJob and the run)
+	MaxStepOutputsSize = 1024
+)
+
+/*
+parse the termination message of a step into its outputs (compacted), returns nil if the message is no JSON object. If
+a JSON object can not be used as outputs, the reason is returned as message.
+*/
+func parseStepOutputs(message string) (json.RawMessage, string) {
+	message = strings.TrimSpace(message)
+	if !strings.HasPrefix(message, "{") {
+		// plain termination messages are not meant as outputs
+		return nil, ""
+	}
+	outputs := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(message), &outputs); err != nil {
+		return nil, "termination message is no valid JSON object: " + err.Error()
+	}
+	res, err := json.Marshal(outputs)
+	if err != nil {
+		return nil, "outputs can not be serialized: " + err.Error()
+	}
+	if len(res) > MaxStepOutputsSize {
+		return nil, fmt.Sprintf("outputs of %d bytes exceed the size limit of %d bytes", len(res), MaxStepOutputsSize)
+	}
+	return res, ""
+}
+
+/*
+read the outputs from the termination message of the step container of a succeeded Job. If the outputs can not be used,
+the reason is returned as message (the error is only set in case of failures when accessing the api server).
+*/
+func (r *PipelineJobReconciler) readStepOutputs(ctx context.Context, j *batchv1.Job) (json.RawMessage, string, error) {
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(j.Namespace), client.MatchingLabels{"job-name": j.Name}); err != nil {
+		return nil, "", err
+	}
+	for _, pod := range pods.Items {
+		// with the artifact store, the step container runs as init container
+		for _, cs := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+			if (cs.Name == "main") && (cs.State.Terminated != nil) && (cs.State.Terminated.ExitCode == 0) {
+				outputs, message := parseStepOutputs(cs.State.Terminated.Message)
+				return outputs, message, nil
+			}
+		}
+	}
+	return nil, "", nil
+}
+
+// the outputs of a step recorded in the status of the run, nil if there are none
+func findStepOutputs(pr *pipelinev1.PipelineRun, stepId string) json.RawMessage {
+	for _, so := range pr.Status.StepOutputs {
+		if so.StepId == stepId {
+			return so.Outputs
+		}
+	}
+	return nil
+}
+
+// record the outputs of a step in the status of the run (not persisted)
+func setStepOutputs(pr *pipelinev1.PipelineRun, stepId string, outputs json.RawMessage) {
+	for i := range pr.Status.StepOutputs {
+		if pr.Status.StepOutputs[i].StepId == stepId {
+			pr.Status.StepOutputs[i].Outputs = outputs
+			return
+		}
+	}
+	pr.Status.StepOutputs = append(pr.Status.StepOutputs, pipelinev1.StepOutputs{StepId: stepId, Outputs: outputs})
+}
+
+// the outputs of a step as seen by when clauses (empty if there are none)
+func decodedStepOutputs(pr *pipelinev1.PipelineRun, stepId string) map[string]interface{} {
+	res := map[string]interface{}{}
+	if outputs := findStepOutputs(pr, stepId); outputs != nil {
+		if err := json.Unmarshal(outputs, &res); err != nil {
+			return map[string]interface{}{}
+		}
+	}
+	return res
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+)
+
+var _ = Describe("Step outputs", func() {
+	It("should parse JSON objects in termination messages", func() {
+		outputs, reason := parseStepOutputs(" {\"rows\": 42,\n \"drift\": 0.3, \"decision\": \"retrain\"}\n")
+		Expect(reason).To(BeEmpty())
+		Expect(string(outputs)).To(Equal(`{"decision":"retrain","drift":0.3,"rows":42}`))
+	})
+
+	It("should ignore plain termination messages", func() {
+		outputs, reason := parseStepOutputs("done")
+		Expect(outputs).To(BeNil())
+		Expect(reason).To(BeEmpty())
+		outputs, reason = parseStepOutputs("")
+		Expect(outputs).To(BeNil())
+		Expect(reason).To(BeEmpty())
+	})
+
+	It("should reject invalid and oversized outputs", func() {
+		outputs, reason := parseStepOutputs("{\"rows\": ")
+		Expect(outputs).To(BeNil())
+		Expect(reason).To(ContainSubstring("no valid JSON object"))
+		outputs, reason = parseStepOutputs(`{"x": "` + strings.Repeat("a", MaxStepOutputsSize) + `"}`)
+		Expect(outputs).To(BeNil())
+		Expect(reason).To(ContainSubstring("size limit"))
+	})
+
+	It("should record outputs per step in the run", func() {
+		pr := &pipelinev1.PipelineRun{}
+		Expect(findStepOutputs(pr, "a")).To(BeNil())
+		setStepOutputs(pr, "a", json.RawMessage(`{"rows":1}`))
+		setStepOutputs(pr, "b", json.RawMessage(`{"rows":2}`))
+		setStepOutputs(pr, "a", json.RawMessage(`{"rows":3}`))
+		Expect(pr.Status.StepOutputs).To(HaveLen(2))
+		Expect(string(findStepOutputs(pr, "a"))).To(Equal(`{"rows":3}`))
+		Expect(decodedStepOutputs(pr, "b")).To(Equal(map[string]interface{}{"rows": 2.0}))
+		Expect(decodedStepOutputs(pr, "c")).To(BeEmpty())
+	})
+
+	It("should make outputs available to when clauses", func() {
+		pr := &pipelinev1.PipelineRun{
+			Status: pipelinev1.PipelineRunStatus{
+				PipelineStructure: &pipelinev1.PipelineStructure{
+					JobSteps: []*pipelinev1.PipelineJobStepSpec{{Id: "detect"}, {Id: "retrain"}},
+				},
+			},
+		}
+		meta.SetStatusCondition(&pr.Status.Conditions, metav1.Condition{Type: StepStatus("detect"), Status: metav1.ConditionTrue, Reason: "Reconciling"})
+		setStepOutputs(pr, "detect", json.RawMessage(`{"drift":0.3,"rows":42}`))
+		Expect(evaluateWhen(pr, "steps.detect.outputs.drift > 0.1 && steps.detect.outputs.rows > 0")).To(BeTrue())
+		Expect(evaluateWhen(pr, "'drift' in steps.retrain.outputs")).To(BeFalse())
+	})
+})
@@ -4,7 +4,6 @@ import (
 	"context"
 	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -23,19 +22,11 @@ func (r *PipelineRunReconciler) GetPersistentVolumeClaim(ctx context.Context, na
 /*
 create PersistentVolumeClaim
 */
-func (r *PipelineRunReconciler) CreatePersistentVolumeClaim(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, volumeName string, sizeInGB int64) (*corev1.PersistentVolumeClaim, error) {
+func (r *PipelineRunReconciler) CreatePersistentVolumeClaim(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, volumeName string, storage *storageSettings) (*corev1.PersistentVolumeClaim, error) {
 
 	// the labels to be attached to pvc
-	labels := map[string]string{
-		"app.kubernetes.io/name":       "Pipeline-PVC",
-		"app.kubernetes.io/instance":   volumeName,
-		"app.kubernetes.io/version":    "v1",
-		"app.kubernetes.io/part-of":    "pipeline-operator",
-		"app.kubernetes.io/created-by": "controller-manager", // TODO should we change this?
-	}
+	labels := currentConfig().resourceLabels("Pipeline-PVC", volumeName)
 
-	//storageClass := "standard-rwo"
-	size := resource.NewQuantity(sizeInGB*(1<<30), resource.BinarySI)
 	pvc := corev1.PersistentVolumeClaim{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      volumeName, // claim gets same name as volume it claims
@@ -43,13 +34,52 @@ func (r *PipelineRunReconciler) CreatePersistentVolumeClaim(ctx context.Context,
 			Labels:    labels,
 		},
 		Spec: corev1.PersistentVolumeClaimSpec{
-			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce /*, corev1.ReadOnlyMany*/},
+			AccessModes: []corev1.PersistentVolumeAccessMode{storage.accessMode},
 			Resources: corev1.ResourceRequirements{
 				Requests: corev1.ResourceList{
-					corev1.ResourceStorage: *size,
+					corev1.ResourceStorage: storage.size,
 				},
 			},
-			StorageClassName: env("STORAGE_CLASS"),
+			StorageClassName: storage.storageClassName,
+		},
+	}
+	if err := ctrl.SetControllerReference(pr, &pvc, r.Scheme); err != nil {
+		return nil, err
+	}
+
+	err := CreateOrUpdate(r, r, ctx, log, &pvc, &corev1.PersistentVolumeClaim{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &pvc, nil
+}
+
+// data source for cloning a persistent volume claim
+func pvcDataSource(pvc *corev1.PersistentVolumeClaim) *corev1.TypedLocalObjectReference {
+	return &corev1.TypedLocalObjectReference{Kind: "PersistentVolumeClaim", Name: pvc.Name}
+}
+
+/*
+create PersistentVolumeClaim like an existing one, populated from the given data source: the existing claim itself
+(requires a CSI driver that supports volume cloning), a snapshot of it or none (an empty volume)
+*/
+func (r *PipelineRunReconciler) ClonePersistentVolumeClaim(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, volumeName string, source *corev1.PersistentVolumeClaim, dataSource *corev1.TypedLocalObjectReference) (*corev1.PersistentVolumeClaim, error) {
+
+	// the labels to be attached to pvc
+	labels := currentConfig().resourceLabels("Pipeline-PVC", volumeName)
+
+	pvc := corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      volumeName, // claim gets same name as volume it claims
+			Namespace: pr.Namespace,
+			Labels:    labels,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      source.Spec.AccessModes,
+			Resources:        *source.Spec.Resources.DeepCopy(),
+			StorageClassName: source.Spec.StorageClassName,
+			DataSource:       dataSource,
 		},
 	}
 	if err := ctrl.SetControllerReference(pr, &pvc, r.Scheme); err != nil {
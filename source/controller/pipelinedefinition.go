@@ -10,8 +10,59 @@ import (
 
 const (
 	ConfigMapCreated string = "ConfigMapCreated"
+
+	// lifecycle states of a pipeline definition
+	LifecycleActive     string = "Active"
+	LifecycleDeprecated string = "Deprecated"
+	LifecycleRetired    string = "Retired"
+
+	// finalizer that blocks deletion of a pipeline definition while runs are using it
+	PipelineDefinitionFinalizer = "k-pipe.cloud/pipeline-definition-in-use"
 )
 
+// check if a version pattern may resolve to the pipeline definition: deprecated versions must be pinned exactly,
+// retired versions are never resolved
+func isResolvable(pd *pipelinev1.PipelineDefinition, versionPattern string) bool {
+	switch pd.Spec.Lifecycle {
+	case LifecycleDeprecated:
+		return versionPattern == pd.Spec.Version
+	case LifecycleRetired:
+		return false
+	}
+	return true
+}
+
+/*
+check if a run still needs its pipeline definition. Runs that have started their termination jobs, have stopped with an
+error state or are paused do not block the deletion of the definition (the definition is not needed any more to finish
+termination jobs, and stuck runs would block it forever).
+*/
+func needsDefinition(pr *pipelinev1.PipelineRun) bool {
+	if isTrue(pr, TerminationJobsStarted) || isFalse(pr, VersionDetermined) || isFalse(pr, StepsReused) {
+		return false
+	}
+	return !hasErrorState(pr) && !isTrue(pr, Paused)
+}
+
+// names of the runs that use the pipeline definition and still need it (see needsDefinition)
+func (r *PipelineDefinitionReconciler) activeRuns(ctx context.Context, pd *pipelinev1.PipelineDefinition) ([]string, error) {
+	prl := &pipelinev1.PipelineRunList{}
+	if err := r.List(ctx, prl, client.InNamespace(pd.Namespace)); err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, pr := range prl.Items {
+		if (pr.Spec.PipelineName != pd.Spec.Name) || (pr.Status.PipelineVersion == nil) || (*pr.Status.PipelineVersion != pd.Spec.Version) {
+			continue
+		}
+		if !needsDefinition(&pr) {
+			continue
+		}
+		res = append(res, pr.Name)
+	}
+	return res, nil
+}
+
 // Gets a pipeline schedule object by name from api server, returns nil,nil if not found
 func GetPipelineDefinition(r client.Reader, ctx context.Context, name types.NamespacedName) (*pipelinev1.PipelineDefinition, error) {
 	res := &pipelinev1.PipelineDefinition{}
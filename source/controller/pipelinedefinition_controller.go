@@ -23,13 +23,16 @@ import (
 	"k8s.io/apimachinery/pkg/util/json"
 	"k8s.io/client-go/tools/record"
 	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"strings"
+	"time"
 
 	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 )
 
 // PipelineDefinitionReconciler reconciles a PipelineDefinition object
@@ -42,6 +45,7 @@ type PipelineDefinitionReconciler struct {
 //+kubebuilder:rbac:groups=pipeline.k-pipe.cloud,resources=pipelinedefinitions,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=pipeline.k-pipe.cloud,resources=pipelinedefinitions/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=pipeline.k-pipe.cloud,resources=pipelinedefinitions/finalizers,verbs=update
+//+kubebuilder:rbac:groups=pipeline.k-pipe.cloud,resources=pipelineruns,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -62,6 +66,11 @@ func (r *PipelineDefinitionReconciler) Reconcile(ctx context.Context, req ctrl.R
 		return *result, err
 	}
 
+	// block deletion while runs are using the definition
+	if result, err := r.updateFinalizer(ctx, log, pd); result != nil {
+		return *result, err
+	}
+
 	// extract desired configmap from definition
 	conf, err := extractConfigAsMap(pd.Spec.PipelineStructure)
 	if err != nil {
@@ -73,6 +82,11 @@ func (r *PipelineDefinitionReconciler) Reconcile(ctx context.Context, req ctrl.R
 		return *result, err
 	}
 
+	// report violations of the image policy
+	if result, err := r.updateImageAdmission(ctx, log, pd); result != nil {
+		return *result, err
+	}
+
 	// create service accounts
 	if result, err := r.updateServiceAccount(ctx, log, pd); result != nil {
 		return *result, err
@@ -127,10 +141,17 @@ func (r *PipelineDefinitionReconciler) failed(ctx context.Context, errormessage
 // SetupWithManager sets up the controller with the Manager.
 func (r *PipelineDefinitionReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.Recorder = mgr.GetEventRecorderFor("pipeline-controller")
+	if err := setupOperatorConfig(mgr); err != nil {
+		return err
+	}
+	// the validating webhook checks the images of new definitions against the image policy
+	pipelinev1.SetImageAdmission(imageAdmission(mgr.GetAPIReader()))
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&pipelinev1.PipelineDefinition{}).
 		Owns(&corev1.ConfigMap{}).
 		Owns(&corev1.ServiceAccount{}).
+		// changes of the image policy are reported in the status of all definitions
+		Watches(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.imagePolicyRequests)).
 		Complete(r)
 }
 
@@ -168,6 +189,44 @@ func (r *PipelineDefinitionReconciler) updateConfigMap(ctx context.Context, log
 	}
 }
 
+func (r *PipelineDefinitionReconciler) updateFinalizer(ctx context.Context, log func(string, ...interface{}), pd *pipelinev1.PipelineDefinition) (*ctrl.Result, error) {
+	if pd.DeletionTimestamp.IsZero() {
+		if controllerutil.AddFinalizer(pd, PipelineDefinitionFinalizer) {
+			if err := r.Update(ctx, pd); err != nil {
+				res := r.failed(ctx, "Failed to add finalizer", err, pd, r.Recorder)
+				return &res, err
+			}
+			// changes made: end reconciliation iteration
+			return &ctrl.Result{}, nil
+		}
+		// nothing done, continue reconciliation
+		return nil, nil
+	}
+	if !controllerutil.ContainsFinalizer(pd, PipelineDefinitionFinalizer) {
+		// deletion is not blocked by us, nothing else to be done
+		return &ctrl.Result{}, nil
+	}
+	runs, err := r.activeRuns(ctx, pd)
+	if err != nil {
+		res := r.failed(ctx, "Failed to list PipelineRuns", err, pd, r.Recorder)
+		return &res, err
+	}
+	if len(runs) > 0 {
+		message := "Deletion blocked by active runs: " + strings.Join(runs, ", ")
+		log(message)
+		r.Recorder.Event(pd, "Warning", "Reconciliation", message)
+		// check again later
+		return &ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+	controllerutil.RemoveFinalizer(pd, PipelineDefinitionFinalizer)
+	if err := r.Update(ctx, pd); err != nil {
+		res := r.failed(ctx, "Failed to remove finalizer", err, pd, r.Recorder)
+		return &res, err
+	}
+	r.Recorder.Event(pd, "Normal", "Reconciliation", "No active runs, deletion unblocked")
+	return &ctrl.Result{}, nil
+}
+
 func (r *PipelineDefinitionReconciler) updateServiceAccount(ctx context.Context, log func(string, ...interface{}), pd *pipelinev1.PipelineDefinition) (*ctrl.Result, error) {
 	// check all service accounts defined in job steps
 	for _, step := range pd.Spec.PipelineStructure.JobSteps {
@@ -182,10 +241,8 @@ func (r *PipelineDefinitionReconciler) updateServiceAccount(ctx context.Context,
 			}
 			// if sa does not exist, create it
 			if sa == nil {
-				annotationLabel := "iam.gke.io/gcp-service-account"                                  // TODO in config
-				annotationTemplate := "{name}@breuni-team-admin-{namespace}.iam.gserviceaccount.com" // TODO in config
-				//annotationTemplate := "bucket-lister-sa@breuninger-pipeline-processing.iam.gserviceaccount.com"
-				if _, err := r.CreateServiceAccount(ctx, log, pd, namespacedName, annotationLabel, resolve(annotationTemplate, pd.Namespace, name)); err != nil {
+				annotations := currentConfig().serviceAccountAnnotations(pd.Namespace, name)
+				if _, err := r.CreateServiceAccount(ctx, log, pd, namespacedName, annotations); err != nil {
 					res := r.failed(ctx, "Failed to create service account", err, pd, r.Recorder)
 					return &res, err
 				}
@@ -0,0 +1,44 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+)
+
+var _ = Describe("Deleting pipeline definitions", func() {
+	run := func(state string, conditions ...string) *pipelinev1.PipelineRun {
+		pr := &pipelinev1.PipelineRun{}
+		pr.Status.State = &state
+		for _, condition := range conditions {
+			meta.SetStatusCondition(&pr.Status.Conditions, metav1.Condition{Type: condition, Status: metav1.ConditionTrue, Reason: "Reconciling"})
+		}
+		return pr
+	}
+
+	It("should only be blocked by runs that can still make progress", func() {
+		Expect(needsDefinition(run(ControlRunning))).To(BeTrue())
+		Expect(needsDefinition(run(ControlRunning, TerminationJobsStarted))).To(BeFalse())
+		Expect(needsDefinition(run(ControlPaused, Paused))).To(BeFalse())
+		Expect(needsDefinition(run(ErrorStatePrefix + "Failed to create Job)"))).To(BeFalse())
+	})
+})
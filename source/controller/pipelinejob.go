@@ -53,29 +53,15 @@ func (r *PipelineJobReconciler) SetPipelineJobStatus(ctx context.Context, log fu
 /*
 create PipelineJob provided spec
 */
-func (r *PipelineRunReconciler) CreatePipelineJob(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, jobName string, spec *pipelinev1.PipelineJobStepSpec) error {
-	stepId := spec.Id
+func (r *PipelineRunReconciler) CreatePipelineJob(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, jobName string, spec *pipelinev1.PipelineJobStepSpec, jobSpec *pipelinev1.JobSpec, storage *storageSettings) error {
 	// create the input volume names
-	var inputs []pipelinev1.InputPipe
-	for _, pipe := range pr.Status.PipelineStructure.Pipes {
-		if pipe.To.StepId == stepId {
-			inputs = append(inputs, pipelinev1.InputPipe{
-				Volume:     r.ConstructPipelineJobName(pr, pipe.From.StepId),
-				MountPath:  getMountPath(pipe.From.StepId),
-				SourceFile: pipe.From.Name,
-				TargetFile: pipe.To.Name,
-			})
-		}
+	inputs, err := r.resolveInputPipes(ctx, pr, spec.Id, pr.Namespace)
+	if err != nil {
+		return err
 	}
 
 	// the labels to be attached to job
-	jobLabels := map[string]string{
-		"app.kubernetes.io/name":       "PipelineSchedule",
-		"app.kubernetes.io/instance":   spec.Id,
-		"app.kubernetes.io/version":    "v1",
-		"app.kubernetes.io/part-of":    "pipeline-operator",
-		"app.kubernetes.io/created-by": "controller-manager", // TODO should we change this?
-	}
+	jobLabels := currentConfig().resourceLabels("PipelineSchedule", spec.Id)
 	// define the job object
 	pj := &pipelinev1.PipelineJob{
 		ObjectMeta: metav1.ObjectMeta{
@@ -87,10 +73,14 @@ func (r *PipelineRunReconciler) CreatePipelineJob(ctx context.Context, log func(
 			Id:                 jobName,
 			Description:        spec.Description,
 			Inputs:             inputs,
-			JobSpec:            spec.JobSpec.DeepCopy(),
+			JobSpec:            jobSpec,
 			PipelineRun:        pr.Name,
 			PipelineDefinition: getPipelineId(*pr),
 			StepId:             spec.Id,
+			RetryPolicy:        spec.RetryPolicy.DeepCopy(),
+			WorkdirSizeLimit:   &storage.workdirSizeLimit,
+			ArtifactStore:      pr.Spec.ArtifactStore.DeepCopy(),
+			OutputArtifact:     pr.Status.Artifacts[spec.Id],
 		},
 	}
 	// Set the ownerRef for the PipelineJob
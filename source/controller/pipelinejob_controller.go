@@ -18,11 +18,13 @@ package controller
 
 import (
 	"context"
+	"fmt"
 	batchv1 "k8s.io/api/batch/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
@@ -62,12 +64,22 @@ func (r *PipelineJobReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return *result, err
 	}
 
-	// create job if it does not exist, yet
-	j, err := r.GetJob(ctx, req.NamespacedName)
+	// nothing to be done once the final result is known
+	if isFinished(pj) {
+		log("PipelineJob has finished")
+		return ctrl.Result{}, nil
+	}
+
+	// create job for the current attempt if it does not exist, yet
+	j, err := r.GetJob(ctx, types.NamespacedName{Namespace: pj.Namespace, Name: attemptJobName(pj, currentAttempt(pj))})
 	if err != nil {
 		return r.failed(ctx, "Failed to get PipelineJob", err, pj, r.Recorder), err
 	}
 	if j == nil {
+		if wait := remainingBackoff(pj, time.Now()); wait > 0 {
+			log("Waiting before next attempt", "backoff", wait.String())
+			return ctrl.Result{RequeueAfter: wait}, nil
+		}
 		return r.createJob(ctx, log, pj)
 	}
 
@@ -115,6 +127,9 @@ func (r *PipelineJobReconciler) loadResource(ctx context.Context, log func(strin
 // SetupWithManager sets up the controller with the Manager.
 func (r *PipelineJobReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.Recorder = mgr.GetEventRecorderFor("pipeline-controller")
+	if err := setupOperatorConfig(mgr); err != nil {
+		return err
+	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&pipelinev1.PipelineJob{}).
 		Owns(&batchv1.Job{}).
@@ -159,18 +174,39 @@ func (r *PipelineJobReconciler) updatedJobStatus(ctx context.Context, log func(s
 	if newSucceededState != oldSucceededState {
 		message := "JobSucceeded state has changed: " + string(oldSucceededState) + " -> " + string(newSucceededState)
 
-		// first set on PipelineRun (to make sure to retry this in case it fails)
-		pr, err := r.GetPipelineRun(ctx, types.NamespacedName{Name: pj.Spec.PipelineRun, Namespace: pj.Namespace})
-		if err != nil || pr == nil {
-			res := r.failed(ctx, "Failed to get PipelineRun resource for updating JobSucceeded status", err, pj, r.Recorder)
-			return &res, err
+		/*
+			record the outcome of the attempt, failed attempts may be retried. The attempt is only written together with
+			the final condition (or the retry state), otherwise the next reconciliation would start another attempt.
+		*/
+		attempts := pj.Status.Attempts
+		if newSucceededState != metav1.ConditionUnknown {
+			attempt, err := r.jobAttempt(ctx, pj, j)
+			if err != nil {
+				res := r.failed(ctx, "Failed to determine outcome of Job", err, pj, r.Recorder)
+				return &res, err
+			}
+			attempts = append(append([]pipelinev1.JobAttempt{}, pj.Status.Attempts...), attempt)
+			if (newSucceededState == metav1.ConditionFalse) && shouldRetry(pj.Spec.RetryPolicy, attempt) {
+				pj.Status.Attempts = attempts
+				return r.retry(ctx, log, pj, j, attempt)
+			}
 		}
-		if err := r.SetPipelineRunStatus(ctx, log, pr, StepStatus(pj.Spec.StepId), newSucceededState, message); err != nil {
-			res := r.failed(ctx, "Failed to set PipelineRun status", err, pj, r.Recorder)
-			return &res, err
+
+		// the outputs published by a succeeded step through its termination message
+		outputs := pj.Status.Outputs
+		if (newSucceededState == metav1.ConditionTrue) && !pj.Spec.TerminationJob {
+			var reason string
+			var err error
+			outputs, reason, err = r.readStepOutputs(ctx, j)
+			if err != nil {
+				res := r.failed(ctx, "Failed to read outputs of Job", err, pj, r.Recorder)
+				return &res, err
+			}
+			if len(reason) > 0 {
+				r.Recorder.Event(pj, "Warning", "StepOutputs", "Ignoring outputs of step "+pj.Spec.StepId+": "+reason)
+			}
 		}
 
-		// then set it on PipelineJob
 		var state string
 		switch newSucceededState {
 		case metav1.ConditionTrue:
@@ -180,7 +216,37 @@ func (r *PipelineJobReconciler) updatedJobStatus(ctx context.Context, log func(s
 		case metav1.ConditionUnknown:
 			state = "Created"
 		}
+
+		// first set on PipelineRun (to make sure to retry this in case it fails)
+		pr, err := r.GetPipelineRun(ctx, types.NamespacedName{Name: pj.Spec.PipelineRun, Namespace: pj.Namespace})
+		if err != nil || pr == nil {
+			res := r.failed(ctx, "Failed to get PipelineRun resource for updating JobSucceeded status", err, pj, r.Recorder)
+			return &res, err
+		}
+		if pj.Spec.TerminationJob {
+			// termination jobs are reported separately, they do not affect the result of the run
+			if err := r.SetTerminationJobState(ctx, log, pr, pj.Name, state); err != nil {
+				res := r.failed(ctx, "Failed to set termination job state in PipelineRun", err, pj, r.Recorder)
+				return &res, err
+			}
+		} else if isTrue(pr, Terminated) || isCancelled(pr, pj.Spec.StepId) {
+			// the step has been cancelled, its result must not be reported as success or failure
+			log("Step " + pj.Spec.StepId + " was cancelled, not updating PipelineRun")
+		} else {
+			if outputs != nil {
+				// mirrored into the run, the status of the run is written together with the step status
+				setStepOutputs(pr, pj.Spec.StepId, outputs)
+			}
+			if err := r.SetPipelineRunStatus(ctx, log, pr, StepStatus(pj.Spec.StepId), newSucceededState, message); err != nil {
+				res := r.failed(ctx, "Failed to set PipelineRun status", err, pj, r.Recorder)
+				return &res, err
+			}
+		}
+
+		// then set it on PipelineJob, together with the attempt and the outputs
 		pj.Status.State = &state
+		pj.Status.Attempts = attempts
+		pj.Status.Outputs = outputs
 		if r.SetPipelineJobStatus(ctx, log, pj, JobSucceeded, newSucceededState, message) != nil {
 			res := r.failed(ctx, "Failed to set PipelineJob succeeded status", err, pj, r.Recorder)
 			return &res, err
@@ -196,3 +262,21 @@ func (r *PipelineJobReconciler) updatedJobStatus(ctx context.Context, log func(s
 	// return nil result to indicate that reconciliation can proceed
 	return nil, nil
 }
+
+// start another attempt after a failed one: the failed Job is deleted, the next one is created after the backoff delay
+func (r *PipelineJobReconciler) retry(ctx context.Context, log func(string, ...interface{}), pj *pipelinev1.PipelineJob, j *batchv1.Job, attempt pipelinev1.JobAttempt) (*ctrl.Result, error) {
+	message := fmt.Sprintf("Attempt %d failed (%s), retrying", attempt.Attempt, attempt.Reason)
+	state := fmt.Sprintf("Retrying (attempt %d of %d)", attempt.Attempt+1, pj.Spec.RetryPolicy.MaxAttempts)
+	pj.Status.State = &state
+	if err := r.Status().Update(ctx, pj); err != nil {
+		res := r.failed(ctx, "Failed to record attempt", err, pj, r.Recorder)
+		return &res, err
+	}
+	log("Deleting the failed Job", "Job.Namespace", j.Namespace, "Job.Name", j.Name)
+	if err := r.Delete(ctx, j, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil {
+		res := r.failed(ctx, "Failed to delete failed Job", err, pj, r.Recorder)
+		return &res, err
+	}
+	r.Recorder.Event(pj, "Warning", "Reconciliation", message)
+	return &ctrl.Result{RequeueAfter: remainingBackoff(pj, time.Now())}, nil
+}
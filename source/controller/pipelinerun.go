@@ -5,6 +5,11 @@ import (
 	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"strings"
+	"time"
 )
 
 const (
@@ -15,6 +20,16 @@ const (
 	Terminated        string = "Terminated"
 	Failed            string = "Failed"
 	Succeeded         string = "Succeeded"
+	NoMatchingVersion string = "NoMatchingVersion"
+
+	/*
+		prefix of the state of runs whose reconciliation failed (see failed), they are not reconciled again unless they
+		are changed, so the state is terminal and counts as failure
+	*/
+	ErrorStatePrefix = "Error ("
+
+	// annotation holding the scheduled time of a pipeline run
+	ScheduledTimeAnnotation = "k-pipe.cloud/scheduled-time"
 )
 
 // Gets a pipeline schedule object by name from api server, returns nil,nil if not found
@@ -27,6 +42,11 @@ func (r *PipelineRunReconciler) GetPipelineRun(ctx context.Context, name types.N
 	return res, err
 }
 
+// check if the reconciliation of a run has failed (see ErrorStatePrefix)
+func hasErrorState(pr *pipelinev1.PipelineRun) bool {
+	return (pr.Status.State != nil) && strings.HasPrefix(*pr.Status.State, ErrorStatePrefix)
+}
+
 // Sets status condition of the pipeline run (from PipelineRun reconciliation)
 func (r *PipelineRunReconciler) SetPipelineRunStatus(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, statusType string, status metav1.ConditionStatus, message string) error {
 	return SetStatusCondition(r.Status(), ctx, log, pr, &pr.Status.Conditions, statusType, status, message)
@@ -37,13 +57,99 @@ func (r *PipelineJobReconciler) SetPipelineRunStatus(ctx context.Context, log fu
 	return SetStatusCondition(r.Status(), ctx, log, pr, &pr.Status.Conditions, statusType, status, message)
 }
 
-func (r *PipelineRunReconciler) DeterminePipelineVersion(ctx context.Context, pr *pipelinev1.PipelineRun) error {
-	version := "1.0.0"
+// Determines the highest version of the installed pipeline definitions that matches the version pattern of the run.
+// Sets pipeline version and version candidates in the status of the run. If no version can be determined, the reason
+// is returned as message (the error is only set in case of failures when accessing the api server).
+func (r *PipelineRunReconciler) DeterminePipelineVersion(ctx context.Context, pr *pipelinev1.PipelineRun) (string, error) {
+	pattern, err := ParseVersionPattern(pr.Spec.VersionPattern)
+	if err != nil {
+		return err.Error(), nil
+	}
+	pdl := &pipelinev1.PipelineDefinitionList{}
+	if err := r.List(ctx, pdl, client.InNamespace(pr.Namespace)); err != nil {
+		return "", err
+	}
+	type candidate struct {
+		name    string
+		version *Version
+	}
+	var candidates []candidate
+	for _, pd := range pdl.Items {
+		if (pd.Spec.Name != pr.Spec.PipelineName) || !isResolvable(&pd, pr.Spec.VersionPattern) {
+			continue
+		}
+		version, err := ParseVersion(pd.Spec.Version)
+		if err != nil {
+			// definitions with invalid versions can not be matched
+			continue
+		}
+		if pattern.Matches(version) {
+			candidates = append(candidates, candidate{name: pd.Spec.Version, version: version})
+		}
+	}
+	if len(candidates) == 0 {
+		return "No definition of pipeline " + pr.Spec.PipelineName + " matches version pattern " + pr.Spec.VersionPattern, nil
+	}
+	// highest version first
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].version.Compare(candidates[j].version) > 0
+	})
+	pr.Status.VersionCandidates = nil
+	for _, c := range candidates {
+		pr.Status.VersionCandidates = append(pr.Status.VersionCandidates, c.name)
+	}
+	version := candidates[0].name
 	pr.Status.PipelineVersion = &version
-	// TODO
-	return nil
+	return "", nil
 }
 
 func getPipelineId(pr pipelinev1.PipelineRun) string {
 	return pr.Spec.PipelineName + "-" + *pr.Status.PipelineVersion
 }
+
+/*
+create PipelineRun for a scheduled time, the name is derived from the scheduled time so that repeated creation
+attempts for the same time are idempotent
+*/
+func (r *PipelineScheduleReconciler) CreateScheduledPipelineRun(ctx context.Context, log func(string, ...interface{}), ps *pipelinev1.PipelineSchedule, sir *pipelinev1.ScheduleInRange, scheduledTime time.Time) (string, error) {
+	name := ps.Name + "-" + scheduledTime.UTC().Format("20060102-1504")
+	notExists, err := NotExistsResource(r, ctx, &pipelinev1.PipelineRun{}, types.NamespacedName{Namespace: ps.Namespace, Name: name})
+	if err != nil {
+		return "", err
+	}
+	if !notExists {
+		log("PipelineRun for scheduled time exists already", "PipelineRun.Namespace", ps.Namespace, "PipelineRun.Name", name)
+		return name, nil
+	}
+
+	// the labels to be attached to the run, the label keys are recorded since they are read back
+	config := currentConfig()
+	runLabels := config.resourceLabels("PipelineRun", name)
+	runLabels[config.LabelKeys.PipelineSchedule] = ps.Name
+	pr := &pipelinev1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ps.Namespace,
+			Labels:    runLabels,
+			Annotations: map[string]string{
+				ScheduledTimeAnnotation: scheduledTime.Format(time.RFC3339),
+				LabelKeysAnnotation:     config.labelKeysAnnotation(),
+			},
+		},
+		Spec: pipelinev1.PipelineRunSpec{
+			PipelineName:          ps.Spec.PipelineName,
+			VersionPattern:        sir.VersionPattern,
+			ArtifactStore:         ps.Spec.ArtifactStore.DeepCopy(),
+			ActiveDeadlineSeconds: ps.Spec.ActiveDeadlineSeconds,
+			SLASeconds:            ps.Spec.SLASeconds,
+			Parameters:            ps.Spec.Parameters,
+		},
+	}
+	// Set the ownerRef for the PipelineRun
+	if err := ctrl.SetControllerReference(ps, pr, r.Scheme); err != nil {
+		return "", err
+	}
+
+	log("Creating a new scheduled PipelineRun", "PipelineRun.Namespace", pr.Namespace, "PipelineRun.Name", pr.Name)
+	return name, r.Create(ctx, pr)
+}
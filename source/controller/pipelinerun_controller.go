@@ -19,17 +19,21 @@ package controller
 import (
 	"context"
 	"fmt"
+	batchv1 "k8s.io/api/batch/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	"strconv"
 	"strings"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
 )
@@ -47,6 +51,14 @@ type PipelineRunReconciler struct {
 //+kubebuilder:rbac:groups=pipeline.k-pipe.cloud,resources=pipelineruns,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=pipeline.k-pipe.cloud,resources=pipelineruns/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=pipeline.k-pipe.cloud,resources=pipelineruns/finalizers,verbs=update
+//+kubebuilder:rbac:groups=pipeline.k-pipe.cloud,resources=pipelinedefinitions,verbs=get;list;watch
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=limitranges,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshots,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -67,6 +79,15 @@ func (r *PipelineRunReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return *result, err
 	}
 
+	// no matching pipeline version was found, this is a terminal state
+	if isFalse(pr, VersionDetermined) {
+		log("No matching pipeline version, nothing to be done")
+		if err := r.updateParentRun(ctx, log, pr, v1.ConditionFalse, "Sub-pipeline has no matching version"); err != nil {
+			return r.failed(ctx, "Failed to update parent PipelineRun", err, pr, r.Recorder), err
+		}
+		return ctrl.Result{}, nil
+	}
+
 	// determine pipeline version if not set, yet
 	if (pr.Status.PipelineVersion == nil) || !isTrue(pr, VersionDetermined) {
 		return r.determinePipelineVersion(ctx, log, pr)
@@ -77,15 +98,47 @@ func (r *PipelineRunReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return r.storePipelineStructure(ctx, log, pr)
 	}
 
-	// if not paused nor terminated ...
+	// steps of a previous run could not be reused, this is a terminal state
+	if isFalse(pr, StepsReused) {
+		log("Steps could not be reused, nothing to be done")
+		return ctrl.Result{}, nil
+	}
+
+	// clone outputs of steps reused from a previous run
+	if result, err = r.reuseSteps(ctx, log, pr); result != nil || err != nil {
+		return *result, err
+	}
+
+	// pause, resume or terminate the run as requested in the spec
+	if result, err = r.applyControl(ctx, log, pr); result != nil || err != nil {
+		return *result, err
+	}
+
+	// report missed SLA and terminate the run once its deadline has been exceeded
+	if result, err = r.enforceDeadlines(ctx, log, pr); result != nil || err != nil {
+		return *result, err
+	}
+
+	// retry failed steps if requested
+	if result, err = r.resumeFailedSteps(ctx, log, pr); result != nil || err != nil {
+		return *result, err
+	}
+
+	// fail sub-pipeline steps whose child run has ended in an error state
+	if result, err = r.failErroredSubPipelines(ctx, log, pr); result != nil || err != nil {
+		return *result, err
+	}
+
+	// if not paused nor terminated, start all steps that are ready
 	if !(isTrue(pr, Paused) || isTrue(pr, Terminated)) {
-		if result, err := r.startStartableStep(ctx, log, pr); result != nil || err != nil {
+		if result, err := r.startStartableSteps(ctx, log, pr); result != nil || err != nil {
 			return *result, err
 		}
+	}
 
-		if result, err = r.startStartableStep(ctx, log, pr); result != nil || err != nil {
-			return *result, err
-		}
+	// process batched sub-pipelines
+	if result, err = r.updateBatchedSubPipelines(ctx, log, pr); result != nil || err != nil {
+		return *result, err
 	}
 
 	// update step statistics
@@ -93,17 +146,33 @@ func (r *PipelineRunReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return *result, err
 	}
 
+	// store outputs of cached steps before their volumes are deleted
+	if result, err = r.storeCachedOutputs(ctx, log, pr); result != nil || err != nil {
+		return *result, err
+	}
+
 	// delete unneeded volumes
 	if result, err = r.removeUnneededPipelineJob(ctx, log, pr); result != nil || err != nil {
 		return *result, err
 	}
 
 	// set state to succeeded or failed
-	if result, err = r.determineTerminalRunState(ctx, pr); result != nil || err != nil {
+	if result, err = r.determineTerminalRunState(ctx, log, pr); result != nil || err != nil {
 		return *result, err
 	}
 
-	return ctrl.Result{}, nil
+	// start termination jobs once the run has terminated
+	if result, err = r.startTerminationJobs(ctx, log, pr); result != nil || err != nil {
+		return *result, err
+	}
+
+	// delete the remaining outputs once the retention of the run has expired
+	if result, err = r.collectGarbage(ctx, log, pr); result != nil || err != nil {
+		return *result, err
+	}
+
+	// check again when the deadline or SLA is due
+	return ctrl.Result{RequeueAfter: nextDeadlineCheck(pr, time.Now())}, nil
 }
 
 func (r *PipelineRunReconciler) loadResource(ctx context.Context, log func(string, ...interface{}), name types.NamespacedName) (*pipelinev1.PipelineRun, *ctrl.Result, error) {
@@ -124,17 +193,27 @@ func (r *PipelineRunReconciler) loadResource(ctx context.Context, log func(strin
 
 func (r *PipelineRunReconciler) determinePipelineVersion(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun) (ctrl.Result, error) {
 	log("Determining pipeline version")
-	err := r.DeterminePipelineVersion(ctx, pr)
+	reason, err := r.DeterminePipelineVersion(ctx, pr)
 	if err != nil {
 		// any other error will be logged
 		return r.failed(ctx, "Failed to determine which pipeline version to run", err, pr, r.Recorder), err
 	}
+	if len(reason) > 0 {
+		// no version matches, the run ends here
+		state := NoMatchingVersion
+		pr.Status.State = &state
+		if err = r.SetPipelineRunStatus(ctx, log, pr, VersionDetermined, v1.ConditionFalse, reason); err != nil {
+			return r.failed(ctx, "Failed to set PipelineRun status", err, pr, r.Recorder), err
+		}
+		r.Recorder.Event(pr, "Warning", "PipelineRunTerminated", reason)
+		return ctrl.Result{}, nil
+	}
 	state := VersionDetermined
 	pr.Status.State = &state
 	if err = r.SetPipelineRunStatus(ctx, log, pr, state, v1.ConditionTrue, "Pipeline version used for run: "+*pr.Status.PipelineVersion); err != nil {
 		return r.failed(ctx, "Failed to set PipelineRun status", err, pr, r.Recorder), err
 	}
-	r.Recorder.Event(pr, "Normal", "Reconciliation", "Pipeline version determined: "+*pr.Status.PipelineVersion)
+	r.Recorder.Event(pr, "Normal", "Reconciliation", "Pipeline version determined: "+*pr.Status.PipelineVersion+" (candidates: "+strings.Join(pr.Status.VersionCandidates, ", ")+")")
 	// changes to state have been made, return empty result to stop current reconciliation iteration
 	return ctrl.Result{}, nil
 }
@@ -167,45 +246,143 @@ func (r *PipelineRunReconciler) storePipelineStructure(ctx context.Context, log
 	return ctrl.Result{}, nil
 }
 
-func (r *PipelineRunReconciler) startStartableStep(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun) (*ctrl.Result, error) {
-	if step := findNextStartableStep(pr); step != nil {
-		log("Starting step: " + step.Id)
-		jobName := r.ConstructPipelineJobName(pr, step.Id)
-		if !isPVCActive(pr, step.Id) {
-			// create pvc
-			var volumeSize int64 = 10 // TODO replace by resource specs
-			if _, err := r.CreatePersistentVolumeClaim(ctx, log, pr, jobName, volumeSize); err != nil {
-				result := r.failed(ctx, "Failed to create PersistentVolume", err, pr, r.Recorder)
-				return &result, err
-			}
-			// set volume flag to true
-			if err := r.setPVCStatus(ctx, log, pr, step.Id, v1.ConditionTrue, "pvc was created"); err != nil {
-				result := r.failed(ctx, "Failed to set pvc flag active", err, pr, r.Recorder)
-				return &result, err
+func (r *PipelineRunReconciler) startStartableSteps(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun) (*ctrl.Result, error) {
+	stepIds := findStartableSteps(pr)
+	if len(stepIds) == 0 {
+		// return nil result to indicate that reconciliation can proceed
+		return nil, nil
+	}
+	res := ctrl.Result{}
+	for _, stepId := range stepIds {
+		skip, err := r.skipStep(ctx, log, pr, stepId)
+		if err != nil {
+			result := r.failed(ctx, "Failed to update PipelineRunStatus for step "+stepId, err, pr, r.Recorder)
+			return &result, err
+		}
+		if skip {
+			continue
+		}
+		if sp := findSubPipeline(pr, stepId); sp != nil {
+			if result, err := r.startSubPipeline(ctx, log, pr, sp); result != nil || err != nil {
+				return result, err
 			}
+			continue
 		}
-		state := "Started " + step.Id
-		pr.Status.State = &state
-		if err := r.SetPipelineRunStatus(ctx, log, pr, StepStatus(step.Id), v1.ConditionUnknown, "Started step: "+step.Id); err != nil {
+		// a PipelineJob of a previous execution of the step (before resuming the run) must be gone first
+		wait, err := r.pipelineJobInDeletion(ctx, pr, stepId)
+		if err != nil {
+			result := r.failed(ctx, "Failed to get PipelineJob of step "+stepId, err, pr, r.Recorder)
+			return &result, err
+		}
+		if wait > 0 {
+			log("Waiting for deletion of previous PipelineJob of step " + stepId)
+			res.RequeueAfter = wait
+			continue
+		}
+		if result, err := r.startStep(ctx, log, pr, findJobStep(pr, stepId)); result != nil || err != nil {
+			return result, err
+		}
+	}
+	// changes to state have been made (or steps are waiting), return result to stop current reconciliation iteration
+	return &res, nil
+}
+
+// start a job step, returns nil result if the step was started successfully
+func (r *PipelineRunReconciler) startStep(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, step *pipelinev1.PipelineJobStepSpec) (*ctrl.Result, error) {
+	log("Starting step: " + step.Id)
+	if step.Cache && (s3Store(pr.Spec.ArtifactStore) != nil) {
+		// outputs in the artifact store are not cached, the step is executed
+		log("Step " + step.Id + " is not cached, caching is not supported with the S3 artifact store")
+		r.Recorder.Event(pr, "Warning", "PipelineExecution", "Step "+step.Id+" is not cached: caching is not supported with the S3 artifact store")
+	} else if step.Cache {
+		hit, hashed, err := r.startFromCache(ctx, log, pr, step)
+		if err != nil {
+			result := r.failed(ctx, "Failed to look up step "+step.Id+" in cache", err, pr, r.Recorder)
+			return &result, err
+		}
+		if !hashed {
+			// the hash job of the inputs triggers the next reconciliation when it has completed
+			log("Waiting for the inputs of step " + step.Id + " to be hashed")
+			return &ctrl.Result{}, nil
+		}
+		if hit {
+			r.Recorder.Event(pr, "Normal", "PipelineExecution", "Took output of step "+step.Id+" from cache")
+			return nil, nil
+		}
+	}
+	cm, err := r.getStorageConfig(ctx, pr.Namespace)
+	if err != nil {
+		result := r.failed(ctx, "Failed to get storage config of namespace "+pr.Namespace, err, pr, r.Recorder)
+		return &result, err
+	}
+	storage, err := resolveStorage(step.Storage, cm)
+	if err != nil {
+		// the step can not be run with the requested storage, it fails without being started
+		if err := r.SetPipelineRunStatus(ctx, log, pr, StepStatus(step.Id), v1.ConditionFalse, "Invalid storage: "+err.Error()); err != nil {
 			result := r.failed(ctx, "Failed to update PipelineRunStatus for job step "+step.Id, err, pr, r.Recorder)
 			return &result, err
 		}
-		if err := r.CreatePipelineJob(ctx, log, pr, jobName, step); err != nil {
-			result := r.failed(ctx, "Failed to create PipelineJob for step "+step.Id, err, pr, r.Recorder)
+		r.Recorder.Event(pr, "Warning", "PipelineExecution", "Invalid storage of step "+step.Id+": "+err.Error())
+		return nil, nil
+	}
+	jobSpec, reason, err := r.jobSpecWithDefaults(ctx, pr.Namespace, &step.JobSpec)
+	if err != nil {
+		result := r.failed(ctx, "Failed to get job defaults of namespace "+pr.Namespace, err, pr, r.Recorder)
+		return &result, err
+	}
+	if len(reason) > 0 {
+		// the pods of the step would be rejected, it fails without being started
+		if err := r.SetPipelineRunStatus(ctx, log, pr, StepStatus(step.Id), v1.ConditionFalse, "Invalid job spec: "+reason); err != nil {
+			result := r.failed(ctx, "Failed to update PipelineRunStatus for job step "+step.Id, err, pr, r.Recorder)
 			return &result, err
 		}
-		r.Recorder.Event(pr, "Normal", "PipelineExecution", "Created PipelineJob: "+step.Id)
-		// changes to state have been made, return empty result to stop current reconciliation iteration
-		return &ctrl.Result{}, nil
+		r.Recorder.Event(pr, "Warning", "PipelineExecution", "Invalid job spec of step "+step.Id+": "+reason)
+		return nil, nil
 	}
-	// return nil result to indicate that reconciliation can proceed
+	jobName := r.ConstructPipelineJobName(pr, step.Id)
+	if err := r.artifactStore(pr).CreateOutput(ctx, log, pr, step.Id, storage); err != nil {
+		result := r.failed(ctx, "Failed to create output of step "+step.Id, err, pr, r.Recorder)
+		return &result, err
+	}
+	state := "Started " + step.Id
+	pr.Status.State = &state
+	if err := r.SetPipelineRunStatus(ctx, log, pr, StepStatus(step.Id), v1.ConditionUnknown, "Started step: "+step.Id); err != nil {
+		result := r.failed(ctx, "Failed to update PipelineRunStatus for job step "+step.Id, err, pr, r.Recorder)
+		return &result, err
+	}
+	if err := r.CreatePipelineJob(ctx, log, pr, jobName, step, jobSpec, storage); err != nil {
+		result := r.failed(ctx, "Failed to create PipelineJob for step "+step.Id, err, pr, r.Recorder)
+		return &result, err
+	}
+	r.Recorder.Event(pr, "Normal", "PipelineExecution", "Created PipelineJob: "+step.Id)
+	return nil, nil
+}
+
+// start a sub-pipeline, returns nil result if the sub-pipeline was started successfully
+func (r *PipelineRunReconciler) startSubPipeline(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, sp *pipelinev1.SubPipelineSpec) (*ctrl.Result, error) {
+	log("Starting sub-pipeline: " + sp.Id)
+	state := "Started " + sp.Id
+	pr.Status.State = &state
+	if err := r.SetPipelineRunStatus(ctx, log, pr, StepStatus(sp.Id), v1.ConditionUnknown, "Started sub-pipeline: "+sp.Id); err != nil {
+		result := r.failed(ctx, "Failed to update PipelineRunStatus for sub-pipeline "+sp.Id, err, pr, r.Recorder)
+		return &result, err
+	}
+	if isBatched(sp) {
+		// manifest job and child runs will be created by updateBatchedSubPipelines
+		return nil, nil
+	}
+	if err := r.CreateChildPipelineRun(ctx, log, pr, sp); err != nil {
+		result := r.failed(ctx, "Failed to create PipelineRun for sub-pipeline "+sp.Id, err, pr, r.Recorder)
+		return &result, err
+	}
+	r.Recorder.Event(pr, "Normal", "PipelineExecution", "Created child PipelineRun: "+sp.Id)
 	return nil, nil
 }
 
-// find a job step that is startable (i.e. not active yet and all input steps have succeeded)
-func findNextStartableStep(pr *pipelinev1.PipelineRun) *pipelinev1.PipelineJobStepSpec {
+// find the job step with given step id, returns nil if the step is not a job step
+func findJobStep(pr *pipelinev1.PipelineRun, stepId string) *pipelinev1.PipelineJobStepSpec {
 	for _, step := range pr.Status.PipelineStructure.JobSteps {
-		if !isActive(pr, step.Id) && allInputsSucceeded(pr, step.Id) {
+		if step.Id == stepId {
 			return step
 		}
 	}
@@ -213,9 +390,10 @@ func findNextStartableStep(pr *pipelinev1.PipelineRun) *pipelinev1.PipelineJobSt
 }
 
 // check all input steps (those at the other end of a pipe that has the given step as target), whether they succeeded
+// (inputs of the pipeline are always available)
 func allInputsSucceeded(pr *pipelinev1.PipelineRun, step string) bool {
 	for _, pipe := range pr.Status.PipelineStructure.Pipes {
-		if (pipe.To.StepId == step) && !hasSucceeded(pr, pipe.From.StepId) {
+		if (pipe.To.StepId == step) && (pipe.From.StepId != pipelinev1.InputStepId) && !hasSucceeded(pr, pipe.From.StepId) {
 			return false
 		}
 	}
@@ -233,19 +411,25 @@ func allOutputsSucceeded(pr *pipelinev1.PipelineRun, step string) bool {
 }
 
 func (r *PipelineRunReconciler) updateStepStatistics(ctx context.Context, pr *pipelinev1.PipelineRun) (*ctrl.Result, error) {
-	// count steps per success status
+	// count steps per success status (skipped steps are counted separately)
 	count := map[v1.ConditionStatus]int{}
+	skipped := 0
 	for _, condition := range pr.Status.Conditions {
 		if strings.HasPrefix(condition.Type, SUCCESS_STATUS_PREFIX) {
-			count[condition.Status]++
+			if (condition.Status == v1.ConditionTrue) && (condition.Reason == SkippedReason) {
+				skipped++
+			} else {
+				count[condition.Status]++
+			}
 		}
 	}
 	// update counts if needed
-	if (pr.Status.NumStepsActive != count[v1.ConditionUnknown]) || (pr.Status.NumStepsSucceeded != count[v1.ConditionTrue]) || (pr.Status.NumStepsFailed != count[v1.ConditionFalse]) {
-		message := "Step statistics has changed: " + strconv.Itoa(pr.Status.NumStepsActive) + "/" + strconv.Itoa(pr.Status.NumStepsSucceeded) + "/" + strconv.Itoa(pr.Status.NumStepsFailed) + " --> " + strconv.Itoa(count[v1.ConditionUnknown]) + "/" + strconv.Itoa(count[v1.ConditionTrue]) + "/" + strconv.Itoa(count[v1.ConditionFalse])
+	if (pr.Status.NumStepsActive != count[v1.ConditionUnknown]) || (pr.Status.NumStepsSucceeded != count[v1.ConditionTrue]) || (pr.Status.NumStepsFailed != count[v1.ConditionFalse]) || (pr.Status.NumStepsSkipped != skipped) {
+		message := "Step statistics has changed: " + strconv.Itoa(pr.Status.NumStepsActive) + "/" + strconv.Itoa(pr.Status.NumStepsSucceeded) + "/" + strconv.Itoa(pr.Status.NumStepsFailed) + "/" + strconv.Itoa(pr.Status.NumStepsSkipped) + " --> " + strconv.Itoa(count[v1.ConditionUnknown]) + "/" + strconv.Itoa(count[v1.ConditionTrue]) + "/" + strconv.Itoa(count[v1.ConditionFalse]) + "/" + strconv.Itoa(skipped)
 		pr.Status.NumStepsActive = count[v1.ConditionUnknown]
 		pr.Status.NumStepsSucceeded = count[v1.ConditionTrue]
 		pr.Status.NumStepsFailed = count[v1.ConditionFalse]
+		pr.Status.NumStepsSkipped = skipped
 		if err := r.Status().Update(ctx, pr); err != nil {
 			result := r.failed(ctx, "Failed to update step statistics of PipelineRun", err, pr, r.Recorder)
 			return &result, err
@@ -260,8 +444,12 @@ func (r *PipelineRunReconciler) updateStepStatistics(ctx context.Context, pr *pi
 }
 
 func (r *PipelineRunReconciler) removeUnneededPipelineJob(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun) (*ctrl.Result, error) {
+	if pr.Spec.KeepVolumes || (len(failedSteps(pr)) > 0) {
+		// keep the outputs of succeeded steps, they are needed when the failed steps are retried (or by later runs)
+		return nil, nil
+	}
 	for _, step := range pr.Status.PipelineStructure.JobSteps {
-		if hasSucceeded(pr, step.Id) && isPVCActive(pr, step.Id) && allOutputsSucceeded(pr, step.Id) {
+		if hasSucceeded(pr, step.Id) && isPVCActive(pr, step.Id) && isOutputUnneeded(pr, step.Id) {
 			jobName := r.ConstructPipelineJobName(pr, step.Id)
 
 			// delete the job, otherwise pvc will still be bound
@@ -270,15 +458,9 @@ func (r *PipelineRunReconciler) removeUnneededPipelineJob(ctx context.Context, l
 				return &result, err
 			}
 
-			// delete the volume, it will not be needed anymore
-			if err := r.DeletePersistentVolumeClaim(ctx, log, pr, jobName); err != nil {
-				result := r.failed(ctx, "Failed to delete PersistentVolumeClaim", err, pr, r.Recorder)
-				return &result, err
-			}
-
-			// set volume flag to false
-			if err := r.setPVCStatus(ctx, log, pr, step.Id, v1.ConditionFalse, "pvc was deleted"); err != nil {
-				result := r.failed(ctx, "Failed to set pvc flag inactive", err, pr, r.Recorder)
+			// delete the output, it will not be needed anymore
+			if err := r.artifactStore(pr).DeleteOutput(ctx, log, pr, step.Id); err != nil {
+				result := r.failed(ctx, "Failed to delete output of step "+step.Id, err, pr, r.Recorder)
 				return &result, err
 			}
 
@@ -291,14 +473,18 @@ func (r *PipelineRunReconciler) removeUnneededPipelineJob(ctx context.Context, l
 	return nil, nil
 }
 
-func (r *PipelineRunReconciler) determineTerminalRunState(ctx context.Context, pr *pipelinev1.PipelineRun) (*ctrl.Result, error) {
+func (r *PipelineRunReconciler) determineTerminalRunState(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun) (*ctrl.Result, error) {
+	if isTrue(pr, Terminated) {
+		// the state of a terminated run is final
+		return nil, nil
+	}
 	allSucceeded := true
 	someFailed := false
-	for _, step := range pr.Status.PipelineStructure.JobSteps {
-		if !hasSucceeded(pr, step.Id) {
+	for _, stepId := range allStepIds(pr.Status.PipelineStructure) {
+		if !hasSucceeded(pr, stepId) {
 			allSucceeded = false
 		}
-		if hasFailed(pr, step.Id) {
+		if hasFailed(pr, stepId) {
 			someFailed = true
 		}
 	}
@@ -310,8 +496,31 @@ func (r *PipelineRunReconciler) determineTerminalRunState(ctx context.Context, p
 	if someFailed {
 		newState = Failed
 	}
+	// report terminal state to parent run first (to make sure to retry this in case it fails)
+	if (newState == Succeeded) || (newState == Failed) {
+		status := v1.ConditionFalse
+		if newState == Succeeded {
+			status = v1.ConditionTrue
+		}
+		if err := r.updateParentRun(ctx, log, pr, status, "Sub-pipeline has terminated with result: "+newState); err != nil {
+			result := r.failed(ctx, "Failed to update parent PipelineRun", err, pr, r.Recorder)
+			return &result, err
+		}
+	}
 	if oldState != newState {
+		if newState == Succeeded {
+			outputs, err := r.outputPipes(ctx, pr)
+			if err != nil {
+				result := r.failed(ctx, "Failed to determine output pipes of PipelineRun", err, pr, r.Recorder)
+				return &result, err
+			}
+			pr.Status.OutputPipes = outputs
+		}
 		pr.Status.State = &newState
+		if (newState == Succeeded) || (newState == Failed) {
+			now := v1.Now()
+			pr.Status.CompletionTime = &now
+		}
 		if err := r.Status().Update(ctx, pr); err != nil {
 			result := r.failed(ctx, "Failed to update state of PipelineRun", err, pr, r.Recorder)
 			return &result, err
@@ -327,6 +536,18 @@ func (r *PipelineRunReconciler) determineTerminalRunState(ctx context.Context, p
 	return nil, nil
 }
 
+// ids of all job steps and sub-pipelines
+func allStepIds(structure *pipelinev1.PipelineStructure) []string {
+	var res []string
+	for _, step := range structure.JobSteps {
+		res = append(res, step.Id)
+	}
+	for _, sp := range structure.SubPipelines {
+		res = append(res, sp.Id)
+	}
+	return res
+}
+
 func isTrue(pr *pipelinev1.PipelineRun, condition string) bool {
 	return meta.IsStatusConditionPresentAndEqual(pr.Status.Conditions, condition, v1.ConditionTrue)
 }
@@ -339,6 +560,7 @@ func isActive(pr *pipelinev1.PipelineRun, stepId string) bool {
 	return meta.FindStatusCondition(pr.Status.Conditions, StepStatus(stepId)) != nil
 }
 
+// skipped steps count as succeeded (their consumers are skipped as well)
 func hasSucceeded(pr *pipelinev1.PipelineRun, stepId string) bool {
 	return isTrue(pr, StepStatus(stepId))
 }
@@ -366,19 +588,38 @@ func (r *PipelineRunReconciler) setPVCStatus(ctx context.Context, log func(strin
 // SetupWithManager sets up the controller with the Manager.
 func (r *PipelineRunReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.Recorder = mgr.GetEventRecorderFor("pipeline-controller")
+	if err := setupOperatorConfig(mgr); err != nil {
+		return err
+	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&pipelinev1.PipelineRun{}).
+		// child runs trigger reconciliation of their parent run (possibly in another namespace)
+		Watches(&pipelinev1.PipelineRun{}, handler.EnqueueRequestsFromMapFunc(parentRunRequest)).
+		// manifest jobs of batched sub-pipelines
+		Owns(&batchv1.Job{}).
 		//Owns(&pipelinev1.PipelineJob{}).
 		//Owns(&corev1.PersistentVolumeClaim{}).
 		Complete(r)
 }
 
+// maps a child run to a reconcile request for its parent run
+func parentRunRequest(ctx context.Context, obj client.Object) []reconcile.Request {
+	pr, ok := obj.(*pipelinev1.PipelineRun)
+	if !ok {
+		return nil
+	}
+	if parent := parentRunName(pr); parent != nil {
+		return []reconcile.Request{{NamespacedName: *parent}}
+	}
+	return nil
+}
+
 // called whenever an error occurred, to create an error event
 func (r *PipelineRunReconciler) failed(ctx context.Context, errormessage string, err error, pr *pipelinev1.PipelineRun, recorder record.EventRecorder) ctrl.Result {
 	if err != nil {
 		errormessage = errormessage + ": " + err.Error()
 	}
-	errState := "Error (" + errormessage + ")"
+	errState := ErrorStatePrefix + errormessage + ")"
 	pr.Status.State = &errState
 	if err := r.Status().Update(ctx, pr); err != nil {
 		log.FromContext(ctx).Error(err, "Failed to update state to "+errormessage)
@@ -6,7 +6,6 @@ import (
 	"time"
 
 	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const (
@@ -23,11 +22,6 @@ func (r *PipelineScheduleReconciler) GetPipelineSchedule(ctx context.Context, na
 	return res, err
 }
 
-// Sets the status condition of the pipeline schedule to available initially, i.e. if no condition exists yet.
-func (r *PipelineScheduleReconciler) SetUpToDateStatus(ctx context.Context, log func(string, ...interface{}), ps *pipelinev1.PipelineSchedule, status metav1.ConditionStatus, message string) error {
-	return SetStatusCondition(r.Status(), ctx, log, ps, &ps.Status.Conditions, UpToDate, status, message)
-}
-
 // Get the expected ScheduleInRange depending on the current time, returns nil if no ScheduleRange matches
 func (r *PipelineScheduleReconciler) GetExpectedScheduleInRange(ctx context.Context, ps pipelinev1.PipelineSchedule) (*pipelinev1.ScheduleInRange, error) {
 	if ps.Spec.Schedules != nil && len(ps.Spec.Schedules) != 0 {
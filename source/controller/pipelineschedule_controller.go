@@ -18,7 +18,7 @@ package controller
 
 import (
 	"context"
-	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/meta"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -44,6 +44,9 @@ type PipelineScheduleReconciler struct {
 //+kubebuilder:rbac:groups=pipeline.k-pipe.cloud,resources=pipelineschedules,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=pipeline.k-pipe.cloud,resources=pipelineschedules/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=pipeline.k-pipe.cloud,resources=pipelineschedules/finalizers,verbs=update
+//+kubebuilder:rbac:groups=batch,resources=cronjobs,verbs=get;list;watch;delete
+//+kubebuilder:rbac:groups=pipeline.k-pipe.cloud,resources=pipelineruns,verbs=get;list;watch;delete
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -66,6 +69,12 @@ func (r *PipelineScheduleReconciler) Reconcile(ctx context.Context, req ctrl.Req
 	if result != nil {
 		return *result, err
 	}
+	oldStatus := ps.Status.DeepCopy()
+
+	// remove cronjob created by earlier versions of the operator
+	if result, err := r.removeLegacyCronJob(ctx, log, ps, req.NamespacedName); result != nil {
+		return *result, err
+	}
 
 	// determine which schedule is expected (this depends on current time)
 	sir, err := r.GetExpectedScheduleInRange(ctx, *ps)
@@ -73,126 +82,104 @@ func (r *PipelineScheduleReconciler) Reconcile(ctx context.Context, req ctrl.Req
 		return r.failed(ctx, "Failed to determine expected schedule", err, ps, r.Recorder), err
 	}
 
-	// get the cronjob with identical name
-	cj, err := r.GetCronJob(ctx, req.NamespacedName)
-	if err != nil {
-		return r.failed(ctx, "Failed to get cronjob from API", err, ps, r.Recorder), err
-	}
-
-	// check consistency between actual and desired state
-	consistent, message := stateConsistent(sir, cj)
-
-	// if upToDate is set, check if that is still justified
-	if meta.IsStatusConditionTrue(ps.Status.Conditions, UpToDate) {
-		// if expected and actual cron job match
-		if consistent {
-			// end reconciliation, returning specified reschedule interval
-			return requeue, nil
-		} else {
-			log("Not any longer up to date: " + message)
-			// set UpToDate to false
-			if err := r.SetUpToDateStatus(ctx, log, ps, v1.ConditionFalse, message); err != nil {
-				return r.failed(ctx, "Failed to clear UpToDateStatus", err, ps, r.Recorder), err
-			}
-			// register an event for the executed action
-			r.Recorder.Event(ps, "Normal", "Reconciliation", message)
-
-			// update state will trigger immediate reschedule, so return empty result
-			return ctrl.Result{}, nil
-		}
+	// copy selected schedule in range data to status (for additionalPrinterColumns)
+	r.SetStatus(ps, sir)
+	if sir == nil {
+		ps.Status.NextScheduleTime = nil
+		return requeue, r.updateStatus(ctx, log, ps, oldStatus, v1.ConditionTrue, "No schedule in current time range")
 	}
 
-	// upToDate is not set, synchronize
-	if message, err := r.reconileCronJob(ctx, log, ps, sir, cj); err != nil {
-		return r.failed(ctx, message, err, ps, r.Recorder), err
-	} else {
-		// after successful reconiliation, reschedule with specified interval
-		return requeue, nil
+	timeZone := ""
+	if sir.TimeZone != nil {
+		timeZone = *sir.TimeZone
 	}
-}
-
-func (r *PipelineScheduleReconciler) reconileCronJob(ctx context.Context, log func(string, ...interface{}), ps *pipelinev1.PipelineSchedule, sir *pipelinev1.ScheduleInRange, cj *batchv1.CronJob) (string, error) {
-	log("State is marked as not up to date, reconciling")
-
-	// copy selected schedule in range data to status (for additionalPrinterColumns), this will be written together with UpToDateStatus
-	r.SetStatus(ps, sir)
-
-	// if no schedule in range, delete cronjob
-	var event string
-	if sir == nil {
-		log("No schedule in current time range expected, deleting CronJob")
-		if err := r.DeleteCronJob(ctx, log, cj); err != nil {
-			return "Failed to delete CronJob", err
+	schedule, err := ParseCronSchedule(sir.CronSpec, timeZone)
+	if err != nil {
+		// invalid schedules are reported, retrying will not help
+		r.Recorder.Event(ps, "Warning", "Reconciliation", err.Error())
+		ps.Status.NextScheduleTime = nil
+		return requeue, r.updateStatus(ctx, log, ps, oldStatus, v1.ConditionFalse, err.Error())
+	}
+
+	// create a run for the latest scheduled time that has passed since the last run (missed times are skipped)
+	now := time.Now()
+	last := ps.CreationTimestamp.Time
+	if ps.Status.LastScheduleTime != nil {
+		last = ps.Status.LastScheduleTime.Time
+	}
+	if scheduledTime := schedule.Latest(last, now); !scheduledTime.IsZero() {
+		name, err := r.CreateScheduledPipelineRun(ctx, log, ps, sir, scheduledTime)
+		if err != nil {
+			return r.failed(ctx, "Failed to create PipelineRun", err, ps, r.Recorder), err
 		}
-		event = "CronJob has been deleted"
+		ps.Status.LastScheduleTime = &v1.Time{Time: scheduledTime}
+		ps.Status.LastRun = name
+		r.Recorder.Event(ps, "Normal", "PipelineExecution", "Created PipelineRun "+name)
+	}
+
+	// reschedule at next scheduled time, but at least every requeue interval (schedules may change over time)
+	next := schedule.Next(now)
+	if next.IsZero() {
+		ps.Status.NextScheduleTime = nil
 	} else {
-		// if no cronjob exists, create one
-		if cj == nil {
-			if err := r.CreateCronJob(ctx, log, ps, *sir); err != nil {
-				return "Failed to create CronJob", err
-			}
-			event = "CronJob has been created"
-		} else {
-			// update existing cronjob with new schedule in range
-			if err := r.UpdateCronJob(ctx, *sir, cj); err != nil {
-				return "Failed to update CronJob", err
-			}
-			event = "CronJob has been updated"
+		ps.Status.NextScheduleTime = &v1.Time{Time: next}
+		if until := next.Sub(now); until < requeue.RequeueAfter {
+			requeue.RequeueAfter = until
 		}
 	}
-
-	// register an event for the executed action
-	r.Recorder.Event(ps, "Normal", "Reconciliation", event)
-
-	// set UpToDate status
-	if err := r.SetUpToDateStatus(ctx, log, ps, v1.ConditionTrue, event); err != nil {
-		return "Failed to set UpToDateStatus", err
+	if err := r.updateStatus(ctx, log, ps, oldStatus, v1.ConditionTrue, "Schedule active: "+sir.CronSpec); err != nil {
+		return r.failed(ctx, "Failed to update PipelineSchedule status", err, ps, r.Recorder), err
 	}
+	return requeue, nil
+}
 
-	// successful reconciliation
-	return "", nil
+// sets the UpToDate condition and writes the status if it has changed
+func (r *PipelineScheduleReconciler) updateStatus(ctx context.Context, log func(string, ...interface{}), ps *pipelinev1.PipelineSchedule, oldStatus *pipelinev1.PipelineScheduleStatus, status v1.ConditionStatus, message string) error {
+	meta.SetStatusCondition(&ps.Status.Conditions, v1.Condition{
+		Type:    UpToDate,
+		Status:  status,
+		Reason:  "Reconciling",
+		Message: message,
+	})
+	if equality.Semantic.DeepEqual(oldStatus, &ps.Status) {
+		// no change in status
+		return nil
+	}
+	log("Updating status: " + message)
+	return r.Status().Update(ctx, ps)
 }
 
-// determine if given ScheduleInRange is consistent with CronJob
-func stateConsistent(sir *pipelinev1.ScheduleInRange, cj *batchv1.CronJob) (bool, string) {
-	if (sir == nil) && (cj != nil) {
-		// one is nil, the other isn't --> inconsistent state
-		return false, "CronJob exists, but shouldn't"
-	}
-	if (sir != nil) && (cj == nil) {
-		// one is nil, the other isn't --> inconsistent state
-		return false, "CronJob should exist, but doesn't"
-	}
-	if sir == nil {
-		// both are nil --> consistent state
-		return true, "no CronJob exists - as expected"
-	}
-	// check time zone
-	if (sir.TimeZone == nil) != (cj.Spec.TimeZone == nil) {
-		// one is nil, the other isn't --> inconsistent state
-		return false, "time zone specs existence has changed"
-	}
-	if (sir.TimeZone != nil) && (*sir.TimeZone != *cj.Spec.TimeZone) {
-		// inconsistent timezone strings
-		return false, "time zone value has changed"
+func (r *PipelineScheduleReconciler) removeLegacyCronJob(ctx context.Context, log func(string, ...interface{}), ps *pipelinev1.PipelineSchedule, name types.NamespacedName) (*ctrl.Result, error) {
+	cj, err := r.GetCronJob(ctx, name)
+	if err != nil {
+		res := r.failed(ctx, "Failed to get cronjob from API", err, ps, r.Recorder)
+		return &res, err
 	}
-	if sir.CronSpec != cj.Spec.Schedule {
-		// inconsistent schedule strings
-		return false, "schedule value has changed"
+	if (cj == nil) || !isLegacyCronJob(cj, ps) {
+		// return nil result to indicate that reconciliation can proceed
+		return nil, nil
 	}
-	if sir.VersionPattern != cj.ObjectMeta.Labels[PipeLineVersionPatternLabel] {
-		// inconsistent version pattern strings
-		return false, "version pattern has changed"
+	if err := r.DeleteCronJob(ctx, log, cj); err != nil {
+		res := r.failed(ctx, "Failed to delete CronJob", err, ps, r.Recorder)
+		return &res, err
 	}
-	return true, "CronJob parameters match expectation"
+	r.Recorder.Event(ps, "Normal", "Reconciliation", "CronJob of earlier operator version has been deleted")
+	// changes made: end reconciliation iteration
+	return &ctrl.Result{}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *PipelineScheduleReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.Recorder = mgr.GetEventRecorderFor("pipeline-controller")
+	if err := setupOperatorConfig(mgr); err != nil {
+		return err
+	}
+	// finished runs of schedules (and standalone runs) are deleted in the background according to their history limits
+	if err := mgr.Add(&RunHistoryCollector{Client: mgr.GetClient(), Recorder: r.Recorder, Interval: time.Minute}); err != nil {
+		return err
+	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&pipelinev1.PipelineSchedule{}).
-		Owns(&batchv1.CronJob{}).
 		Complete(r)
 }
 
@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"context"
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+	"time"
+)
+
+const (
+	// annotation requesting to retry the failed steps of a run (the value is ignored, the annotation is removed
+	// once the request has been processed)
+	RetryFailedAnnotation = "k-pipe.cloud/retry-failed"
+
+	Resuming string = "Resuming"
+)
+
+// the given steps and all steps that depend on them directly or indirectly
+func withDownstreamSteps(structure *pipelinev1.PipelineStructure, stepIds []string) []string {
+	var res []string
+	found := map[string]bool{}
+	var add func(stepId string)
+	add = func(stepId string) {
+		if found[stepId] || (stepId == pipelinev1.OutputStepId) {
+			return
+		}
+		found[stepId] = true
+		res = append(res, stepId)
+		for _, pipe := range structure.Pipes {
+			if pipe.From.StepId == stepId {
+				add(pipe.To.StepId)
+			}
+		}
+	}
+	for _, stepId := range stepIds {
+		add(stepId)
+	}
+	return res
+}
+
+/*
+process a request to retry the failed steps: the failed steps and their downstream steps are reset, failed child runs
+of sub-pipelines are resumed themselves, succeeded steps are kept (including their volumes)
+*/
+func (r *PipelineRunReconciler) resumeFailedSteps(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun) (*ctrl.Result, error) {
+	if _, requested := pr.Annotations[RetryFailedAnnotation]; !requested {
+		// return nil result to indicate that reconciliation can proceed
+		return nil, nil
+	}
+	failed := failedSteps(pr)
+	if isTrue(pr, OutputsDeleted) {
+		r.Recorder.Event(pr, "Warning", "PipelineExecution", "Retry of failed steps was requested, but the outputs of the run have been deleted")
+	} else if (len(failed) > 0) && !isTrue(pr, Terminated) {
+		log("Retrying failed steps: " + strings.Join(failed, ", "))
+		for _, stepId := range withDownstreamSteps(pr.Status.PipelineStructure, failed) {
+			if !isActive(pr, stepId) {
+				continue
+			}
+			if sp := findSubPipeline(pr, stepId); sp != nil {
+				// the child runs continue with their own failed steps
+				resumed, err := r.resumeChildRuns(ctx, log, pr, sp)
+				if err != nil {
+					result := r.failed(ctx, "Failed to resume child PipelineRuns of "+stepId, err, pr, r.Recorder)
+					return &result, err
+				}
+				if isBatched(sp) && (findBatchStatus(pr, sp.Id) == nil) {
+					// the batch manifest could not be read, the manifest job is run again
+					if err := r.deleteManifestJob(ctx, log, pr, r.constructManifestJobName(pr, sp.Id)); err != nil {
+						result := r.failed(ctx, "Failed to delete manifest Job", err, pr, r.Recorder)
+						return &result, err
+					}
+					resumed = true
+				}
+				if !resumed {
+					// e.g. no matching version for the child run, the step stays failed
+					continue
+				}
+				meta.SetStatusCondition(&pr.Status.Conditions, v1.Condition{
+					Type:    StepStatus(stepId),
+					Status:  v1.ConditionUnknown,
+					Reason:  "Reconciling",
+					Message: "Resumed sub-pipeline: " + stepId,
+				})
+				continue
+			}
+			// the PipelineJob is recreated once it is gone, its output volume is reused
+			if err := r.deletePipelineJobForeground(ctx, log, pr, r.ConstructPipelineJobName(pr, stepId)); err != nil {
+				result := r.failed(ctx, "Failed to delete PipelineJob", err, pr, r.Recorder)
+				return &result, err
+			}
+			meta.RemoveStatusCondition(&pr.Status.Conditions, StepStatus(stepId))
+			// the inputs may have changed, they are hashed again
+			delete(pr.Status.InputHashes, stepId)
+		}
+		// the termination jobs are run again when the resumed run has ended
+		meta.RemoveStatusCondition(&pr.Status.Conditions, TerminationJobsStarted)
+		pr.Status.TerminationJobs = nil
+		pr.Status.CompletionTime = nil
+		if isFalse(pr, SLAMissed) {
+			// the SLA is evaluated again when the resumed run has finished
+			meta.RemoveStatusCondition(&pr.Status.Conditions, SLAMissed)
+		}
+		state := Resuming
+		pr.Status.State = &state
+		if err := r.Status().Update(ctx, pr); err != nil {
+			result := r.failed(ctx, "Failed to reset failed steps", err, pr, r.Recorder)
+			return &result, err
+		}
+		r.Recorder.Event(pr, "Normal", "PipelineExecution", "Retrying failed steps: "+strings.Join(failed, ", "))
+	} else {
+		r.Recorder.Event(pr, "Warning", "PipelineExecution", "Retry of failed steps was requested, but there are none")
+	}
+	delete(pr.Annotations, RetryFailedAnnotation)
+	if err := r.Update(ctx, pr); err != nil {
+		result := r.failed(ctx, "Failed to remove annotation "+RetryFailedAnnotation, err, pr, r.Recorder)
+		return &result, err
+	}
+	// changes to state have been made, return empty result to stop current reconciliation iteration
+	return &ctrl.Result{}, nil
+}
+
+// request retrying the failed steps of the failed child runs of a sub-pipeline step, returns true if any child run
+// was resumed
+func (r *PipelineRunReconciler) resumeChildRuns(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, sp *pipelinev1.SubPipelineSpec) (bool, error) {
+	children, err := r.childRunsOfStep(ctx, pr, sp)
+	if err != nil {
+		return false, err
+	}
+	resumed := false
+	for _, child := range children {
+		if (child.Status.State == nil) || (*child.Status.State != Failed) {
+			continue
+		}
+		log("Requesting retry of failed steps of child run " + child.Name)
+		if child.Annotations == nil {
+			child.Annotations = map[string]string{}
+		}
+		child.Annotations[RetryFailedAnnotation] = "true"
+		if err := r.Update(ctx, child); err != nil {
+			return resumed, err
+		}
+		resumed = true
+	}
+	return resumed, nil
+}
+
+// delete a PipelineJob together with its Jobs, the PipelineJob is only gone once the Jobs have been deleted
+func (r *PipelineRunReconciler) deletePipelineJobForeground(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, jobName string) error {
+	pj, err := r.GetPipelineJob(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: jobName})
+	if err != nil || pj == nil {
+		return err
+	}
+	log("Deleting the PipelineJob (foreground)", "PipelineJob.Namespace", pr.Namespace, "PipelineJob.Name", jobName)
+	return r.Delete(ctx, pj, client.PropagationPolicy(v1.DeletePropagationForeground))
+}
+
+// check if the PipelineJob of a previous execution of a step is still being deleted, returns the time to wait
+func (r *PipelineRunReconciler) pipelineJobInDeletion(ctx context.Context, pr *pipelinev1.PipelineRun, stepId string) (time.Duration, error) {
+	pj, err := r.GetPipelineJob(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: r.ConstructPipelineJobName(pr, stepId)})
+	if err != nil || pj == nil || pj.DeletionTimestamp.IsZero() {
+		return 0, err
+	}
+	return 5 * time.Second, nil
+}
@@ -0,0 +1,41 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+)
+
+var _ = Describe("Resuming runs", func() {
+	pipe := func(from string, to string) *pipelinev1.PipelinePipe {
+		return &pipelinev1.PipelinePipe{From: pipelinev1.PipeConnector{StepId: from, Name: "x"}, To: pipelinev1.PipeConnector{StepId: to, Name: from}}
+	}
+
+	It("should reset failed steps and everything downstream of them", func() {
+		structure := &pipelinev1.PipelineStructure{
+			Pipes: []*pipelinev1.PipelinePipe{
+				pipe("input", "a"), pipe("a", "b"), pipe("a", "c"), pipe("b", "d"), pipe("c", "d"), pipe("d", "output"), pipe("e", "f"),
+			},
+		}
+		Expect(withDownstreamSteps(structure, []string{"b"})).To(Equal([]string{"b", "d"}))
+		Expect(withDownstreamSteps(structure, []string{"a", "e"})).To(Equal([]string{"a", "b", "d", "c", "e", "f"}))
+		Expect(withDownstreamSteps(structure, []string{"d"})).To(Equal([]string{"d"}))
+	})
+})
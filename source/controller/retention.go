@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"context"
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"strings"
+	"time"
+)
+
+const (
+	// status flag
+	OutputsDeleted string = "OutputsDeleted"
+)
+
+// the retention policy of a run (the one of the run spec overrides the one of the pipeline definition)
+func retention(pr *pipelinev1.PipelineRun) *pipelinev1.RetentionPolicy {
+	if pr.Spec.Retention != nil {
+		return pr.Spec.Retention
+	}
+	if (pr.Status.PipelineStructure != nil) && (pr.Status.PipelineStructure.Retention != nil) {
+		return pr.Status.PipelineStructure.Retention
+	}
+	return &pipelinev1.RetentionPolicy{}
+}
+
+// final outputs are deleted once their step has succeeded, unless they are kept explicitly
+func keepFinalOutputs(policy *pipelinev1.RetentionPolicy) bool {
+	return (policy.KeepFinalOutputs != nil) && *policy.KeepFinalOutputs
+}
+
+// check if the output of a step is read by other steps of the run
+func hasConsumers(pr *pipelinev1.PipelineRun, stepId string) bool {
+	for _, pipe := range pr.Status.PipelineStructure.Pipes {
+		if (pipe.From.StepId == stepId) && (pipe.To.StepId != pipelinev1.OutputStepId) {
+			return true
+		}
+	}
+	return false
+}
+
+// check if the output of a step is bound to an output pipe of the run
+func isExported(pr *pipelinev1.PipelineRun, stepId string) bool {
+	for _, pipe := range pr.Status.PipelineStructure.Pipes {
+		if (pipe.From.StepId == stepId) && (pipe.To.StepId == pipelinev1.OutputStepId) {
+			return true
+		}
+	}
+	return false
+}
+
+// check if the output of a succeeded step may be deleted before the TTL of the run has expired
+func isOutputUnneeded(pr *pipelinev1.PipelineRun, stepId string) bool {
+	policy := retention(pr)
+	if isExported(pr, stepId) {
+		return false
+	}
+	if hasConsumers(pr, stepId) {
+		return !policy.KeepIntermediateOutputs && allOutputsSucceeded(pr, stepId)
+	}
+	return !keepFinalOutputs(policy)
+}
+
+// the time after completion until the outputs of a terminal run are deleted, nil if they are kept forever
+func retentionTTL(pr *pipelinev1.PipelineRun) *time.Duration {
+	policy := retention(pr)
+	ttl := policy.FailedTTL
+	if !isTrue(pr, Terminated) && (pr.Status.State != nil) && (*pr.Status.State == Succeeded) {
+		ttl = policy.SucceededTTL
+	}
+	if ttl == nil {
+		return nil
+	}
+	return &ttl.Duration
+}
+
+/*
+delete the remaining outputs and PipelineJobs of the job steps of a terminal run once its TTL has expired, requeues
+until then
+*/
+func (r *PipelineRunReconciler) collectGarbage(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun) (*ctrl.Result, error) {
+	if !isTerminal(pr) || isTrue(pr, OutputsDeleted) {
+		// return nil result to indicate that reconciliation can proceed
+		return nil, nil
+	}
+	if pr.Status.CompletionTime == nil {
+		// runs that have completed before completion times were recorded
+		now := v1.Now()
+		pr.Status.CompletionTime = &now
+		if err := r.Status().Update(ctx, pr); err != nil {
+			result := r.failed(ctx, "Failed to set completion time of PipelineRun", err, pr, r.Recorder)
+			return &result, err
+		}
+		return &ctrl.Result{}, nil
+	}
+	ttl := retentionTTL(pr)
+	if ttl == nil {
+		return nil, nil
+	}
+	if remaining := pr.Status.CompletionTime.Add(*ttl).Sub(time.Now()); remaining > 0 {
+		return &ctrl.Result{RequeueAfter: remaining}, nil
+	}
+	var deleted []string
+	for _, step := range pr.Status.PipelineStructure.JobSteps {
+		if err := r.DeletePipelineJob(ctx, log, pr, r.ConstructPipelineJobName(pr, step.Id)); err != nil {
+			result := r.failed(ctx, "Failed to delete PipelineJob", err, pr, r.Recorder)
+			return &result, err
+		}
+		if isPVCActive(pr, step.Id) {
+			if err := r.artifactStore(pr).DeleteOutput(ctx, log, pr, step.Id); err != nil {
+				result := r.failed(ctx, "Failed to delete output of step "+step.Id, err, pr, r.Recorder)
+				return &result, err
+			}
+			deleted = append(deleted, step.Id)
+		}
+	}
+	message := "Retention of run has expired, deleted outputs of steps: " + strings.Join(deleted, ", ")
+	if err := r.SetPipelineRunStatus(ctx, log, pr, OutputsDeleted, v1.ConditionTrue, message); err != nil {
+		result := r.failed(ctx, "Failed to set PipelineRun status", err, pr, r.Recorder)
+		return &result, err
+	}
+	r.Recorder.Event(pr, "Normal", "RetentionExpired", message)
+	// changes to state have been made, return empty result to stop current reconciliation iteration
+	return &ctrl.Result{}, nil
+}
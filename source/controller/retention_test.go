@@ -0,0 +1,90 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+)
+
+var _ = Describe("Output retention", func() {
+	var pr *pipelinev1.PipelineRun
+	boolPtr := func(b bool) *bool {
+		return &b
+	}
+	setStatus := func(stepId string, status metav1.ConditionStatus) {
+		meta.SetStatusCondition(&pr.Status.Conditions, metav1.Condition{Type: StepStatus(stepId), Status: status, Reason: "Test"})
+	}
+	pipe := func(from string, to string) *pipelinev1.PipelinePipe {
+		return &pipelinev1.PipelinePipe{From: pipelinev1.PipeConnector{StepId: from, Name: "x"}, To: pipelinev1.PipeConnector{StepId: to, Name: from}}
+	}
+
+	BeforeEach(func() {
+		// a -> b -> output, c has no consumers
+		pr = &pipelinev1.PipelineRun{
+			Status: pipelinev1.PipelineRunStatus{
+				PipelineStructure: &pipelinev1.PipelineStructure{
+					JobSteps: []*pipelinev1.PipelineJobStepSpec{{Id: "a"}, {Id: "b"}, {Id: "c"}},
+					Pipes:    []*pipelinev1.PipelinePipe{pipe("a", "b"), pipe("b", pipelinev1.OutputStepId)},
+				},
+			},
+		}
+		for _, stepId := range []string{"a", "b", "c"} {
+			setStatus(stepId, metav1.ConditionTrue)
+		}
+	})
+
+	It("should delete intermediate outputs once their consumers have succeeded", func() {
+		Expect(isOutputUnneeded(pr, "a")).To(BeTrue())
+		setStatus("b", metav1.ConditionUnknown)
+		Expect(isOutputUnneeded(pr, "a")).To(BeFalse())
+	})
+
+	It("should keep outputs bound to output pipes and delete final outputs by default", func() {
+		Expect(isOutputUnneeded(pr, "b")).To(BeFalse())
+		Expect(isOutputUnneeded(pr, "c")).To(BeTrue())
+	})
+
+	It("should follow the retention of the definition unless the run overrides it", func() {
+		pr.Status.PipelineStructure.Retention = &pipelinev1.RetentionPolicy{KeepIntermediateOutputs: true, KeepFinalOutputs: boolPtr(true)}
+		Expect(isOutputUnneeded(pr, "a")).To(BeFalse())
+		Expect(isOutputUnneeded(pr, "b")).To(BeFalse())
+		Expect(isOutputUnneeded(pr, "c")).To(BeFalse())
+		pr.Spec.Retention = &pipelinev1.RetentionPolicy{}
+		Expect(isOutputUnneeded(pr, "a")).To(BeTrue())
+		Expect(isOutputUnneeded(pr, "c")).To(BeTrue())
+	})
+
+	It("should choose the TTL by the result of the run", func() {
+		Expect(retentionTTL(pr)).To(BeNil())
+		pr.Spec.Retention = &pipelinev1.RetentionPolicy{
+			SucceededTTL: &metav1.Duration{Duration: time.Hour},
+			FailedTTL:    &metav1.Duration{Duration: 7 * 24 * time.Hour},
+		}
+		state := Succeeded
+		pr.Status.State = &state
+		Expect(*retentionTTL(pr)).To(Equal(time.Hour))
+		state = Failed
+		Expect(*retentionTTL(pr)).To(Equal(7 * 24 * time.Hour))
+	})
+})
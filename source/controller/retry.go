@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"context"
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strconv"
+	"time"
+)
+
+const (
+	// failure reasons of an attempt
+	RetryOnError            pipelinev1.RetryCondition = "Error"
+	RetryOnOOMKilled        pipelinev1.RetryCondition = "OOMKilled"
+	RetryOnEvicted          pipelinev1.RetryCondition = "Evicted"
+	RetryOnDeadlineExceeded pipelinev1.RetryCondition = "DeadlineExceeded"
+
+	defaultBackoffSeconds    = 10
+	defaultMaxBackoffSeconds = 600
+)
+
+// number of the attempt that is currently executed (starting with 1)
+func currentAttempt(pj *pipelinev1.PipelineJob) int {
+	return len(pj.Status.Attempts) + 1
+}
+
+// the first attempt runs in a Job with the name of the PipelineJob, further attempts get a suffix
+func attemptJobName(pj *pipelinev1.PipelineJob, attempt int) string {
+	if attempt <= 1 {
+		return pj.Name
+	}
+	return pj.Name + "-" + strconv.Itoa(attempt)
+}
+
+// check if the final result of the PipelineJob has been determined
+func isFinished(pj *pipelinev1.PipelineJob) bool {
+	return meta.IsStatusConditionPresentAndEqual(pj.Status.Conditions, JobSucceeded, metav1.ConditionTrue) ||
+		meta.IsStatusConditionPresentAndEqual(pj.Status.Conditions, JobSucceeded, metav1.ConditionFalse)
+}
+
+// delay before the given attempt (starting with the second one), doubled for each attempt up to the maximum
+func backoffDelay(policy *pipelinev1.RetryPolicy, attempt int) time.Duration {
+	backoff := int64(defaultBackoffSeconds)
+	if policy.BackoffSeconds != nil {
+		backoff = int64(*policy.BackoffSeconds)
+	}
+	maxBackoff := int64(defaultMaxBackoffSeconds)
+	if policy.MaxBackoffSeconds != nil {
+		maxBackoff = int64(*policy.MaxBackoffSeconds)
+	}
+	for i := 2; (i < attempt) && (backoff < maxBackoff); i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return time.Duration(backoff) * time.Second
+}
+
+// time to wait before the next attempt may be started
+func remainingBackoff(pj *pipelinev1.PipelineJob, now time.Time) time.Duration {
+	if (pj.Spec.RetryPolicy == nil) || (len(pj.Status.Attempts) == 0) {
+		return 0
+	}
+	last := pj.Status.Attempts[len(pj.Status.Attempts)-1]
+	if last.FinishedAt == nil {
+		return 0
+	}
+	return last.FinishedAt.Add(backoffDelay(pj.Spec.RetryPolicy, currentAttempt(pj))).Sub(now)
+}
+
+// check if a failed attempt is to be retried
+func shouldRetry(policy *pipelinev1.RetryPolicy, attempt pipelinev1.JobAttempt) bool {
+	if (policy == nil) || (attempt.Attempt >= policy.MaxAttempts) {
+		return false
+	}
+	if len(policy.RetryOn) == 0 {
+		return true
+	}
+	for _, condition := range policy.RetryOn {
+		if condition != pipelinev1.RetryCondition(attempt.Reason) {
+			continue
+		}
+		if (condition != RetryOnError) || (len(policy.ExitCodes) == 0) {
+			return true
+		}
+		for _, exitCode := range policy.ExitCodes {
+			if (attempt.ExitCode != nil) && (*attempt.ExitCode == exitCode) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// determine the outcome of a finished Job from its condition and the state of its pods
+func (r *PipelineJobReconciler) jobAttempt(ctx context.Context, pj *pipelinev1.PipelineJob, j *batchv1.Job) (pipelinev1.JobAttempt, error) {
+	now := metav1.Now()
+	res := pipelinev1.JobAttempt{
+		Attempt:    int32(currentAttempt(pj)),
+		JobName:    j.Name,
+		Succeeded:  isTrueInJob(j, batchv1.JobComplete),
+		FinishedAt: &now,
+	}
+	for _, condition := range j.Status.Conditions {
+		if (condition.Type == batchv1.JobFailed) && (condition.Status == corev1.ConditionTrue) && (condition.Reason == string(RetryOnDeadlineExceeded)) {
+			res.Reason = string(RetryOnDeadlineExceeded)
+		}
+	}
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(j.Namespace), client.MatchingLabels{"job-name": j.Name}); err != nil {
+		return res, err
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.Reason == string(RetryOnEvicted) {
+			res.Reason = string(RetryOnEvicted)
+		}
+		// with the artifact store, the step container runs as init container
+		for _, cs := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+			if (cs.Name == "main") && (cs.State.Terminated != nil) {
+				exitCode := cs.State.Terminated.ExitCode
+				res.ExitCode = &exitCode
+				if len(res.Reason) == 0 {
+					res.Reason = cs.State.Terminated.Reason
+				}
+			}
+		}
+	}
+	if !res.Succeeded && (len(res.Reason) == 0) {
+		res.Reason = string(RetryOnError)
+	}
+	return res, nil
+}
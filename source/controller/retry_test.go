@@ -0,0 +1,112 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+)
+
+var _ = Describe("Retry policy", func() {
+	int32Ptr := func(i int32) *int32 {
+		return &i
+	}
+	failed := func(attempt int32, reason string, exitCode int32) pipelinev1.JobAttempt {
+		return pipelinev1.JobAttempt{Attempt: attempt, Reason: reason, ExitCode: &exitCode}
+	}
+
+	It("should double the backoff up to the maximum", func() {
+		policy := &pipelinev1.RetryPolicy{MaxAttempts: 10, BackoffSeconds: int32Ptr(5), MaxBackoffSeconds: int32Ptr(30)}
+		Expect(backoffDelay(policy, 2)).To(Equal(5 * time.Second))
+		Expect(backoffDelay(policy, 3)).To(Equal(10 * time.Second))
+		Expect(backoffDelay(policy, 4)).To(Equal(20 * time.Second))
+		Expect(backoffDelay(policy, 5)).To(Equal(30 * time.Second))
+		Expect(backoffDelay(&pipelinev1.RetryPolicy{MaxAttempts: 2}, 2)).To(Equal(10 * time.Second))
+	})
+
+	It("should retry until the attempts are exhausted", func() {
+		policy := &pipelinev1.RetryPolicy{MaxAttempts: 3}
+		Expect(shouldRetry(nil, failed(1, "Error", 1))).To(BeFalse())
+		Expect(shouldRetry(policy, failed(1, "Error", 1))).To(BeTrue())
+		Expect(shouldRetry(policy, failed(2, "OOMKilled", 137))).To(BeTrue())
+		Expect(shouldRetry(policy, failed(3, "Error", 1))).To(BeFalse())
+	})
+
+	It("should only retry on the given conditions and exit codes", func() {
+		policy := &pipelinev1.RetryPolicy{MaxAttempts: 3, RetryOn: []pipelinev1.RetryCondition{"OOMKilled", "Error"}, ExitCodes: []int32{42}}
+		Expect(shouldRetry(policy, failed(1, "OOMKilled", 137))).To(BeTrue())
+		Expect(shouldRetry(policy, failed(1, "Error", 42))).To(BeTrue())
+		Expect(shouldRetry(policy, failed(1, "Error", 1))).To(BeFalse())
+		Expect(shouldRetry(policy, failed(1, "Evicted", 0))).To(BeFalse())
+	})
+
+	It("should not record the attempt if the run can not be updated", func() {
+		testScheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(testScheme)).To(Succeed())
+		Expect(pipelinev1.AddToScheme(testScheme)).To(Succeed())
+		pr := &pipelinev1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Name: "run", Namespace: "default"}}
+		pj := &pipelinev1.PipelineJob{
+			ObjectMeta: metav1.ObjectMeta{Name: "run-a", Namespace: "default"},
+			Spec:       pipelinev1.PipelineJobSpec{PipelineRun: "run", StepId: "a", RetryPolicy: &pipelinev1.RetryPolicy{MaxAttempts: 3}},
+		}
+		j := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "run-a", Namespace: "default"},
+			Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+			}},
+		}
+		c := fake.NewClientBuilder().
+			WithScheme(testScheme).
+			WithObjects(pr, pj, j).
+			WithStatusSubresource(pr, pj).
+			WithInterceptorFuncs(interceptor.Funcs{
+				SubResourceUpdate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+					if _, isRun := obj.(*pipelinev1.PipelineRun); isRun {
+						return apierrors.NewConflict(schema.GroupResource{Resource: "pipelineruns"}, obj.GetName(), nil)
+					}
+					return c.SubResource(subResourceName).Update(ctx, obj, opts...)
+				},
+			}).
+			Build()
+		r := &PipelineJobReconciler{Client: c, Scheme: testScheme, Recorder: record.NewFakeRecorder(100)}
+		log := func(string, ...interface{}) {}
+
+		_, err := r.updatedJobStatus(context.Background(), log, pj, j)
+		Expect(err).To(HaveOccurred())
+		stored := &pipelinev1.PipelineJob{}
+		Expect(c.Get(context.Background(), client.ObjectKeyFromObject(pj), stored)).To(Succeed())
+		Expect(stored.Status.Attempts).To(BeEmpty())
+		Expect(meta.FindStatusCondition(stored.Status.Conditions, JobSucceeded)).To(BeNil())
+	})
+})
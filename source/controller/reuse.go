@@ -0,0 +1,168 @@
+package controller
+
+import (
+	"context"
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"strings"
+)
+
+const (
+	// status flag
+	StepsReused string = "StepsReused"
+
+	// reason of the success condition of steps whose outputs were reused from a previous run
+	ReusedReason = "ReusedFromRun"
+
+	// annotation holding the name of the run from which step outputs were reused
+	ReusedFromAnnotation = "k-pipe.cloud/reused-from"
+)
+
+/*
+the steps (other than the reused ones) whose outputs are only consumed by reused steps or by other such steps, they do
+not need to be executed. Steps without consumers are kept, as they may have side effects.
+*/
+func prunedSteps(structure *pipelinev1.PipelineStructure, reused []string) []string {
+	needless := map[string]bool{}
+	for _, stepId := range reused {
+		needless[stepId] = true
+	}
+	var res []string
+	for changed := true; changed; {
+		changed = false
+		for _, stepId := range allStepIds(structure) {
+			if needless[stepId] {
+				continue
+			}
+			consumed := false
+			needed := false
+			for _, pipe := range structure.Pipes {
+				if pipe.From.StepId == stepId {
+					consumed = true
+					needed = needed || !needless[pipe.To.StepId]
+				}
+			}
+			if consumed && !needed {
+				needless[stepId] = true
+				res = append(res, stepId)
+				changed = true
+			}
+		}
+	}
+	return res
+}
+
+/*
+clone the output volumes of the reused steps from the previous run and mark the steps as succeeded, steps only feeding
+reused steps are skipped. Returns false while outputs are still being copied. If this is not possible, the reason is
+returned as message (the error is only set in case of failures when accessing the api server).
+*/
+func (r *PipelineRunReconciler) cloneReusedSteps(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun) (bool, string, error) {
+	reuse := pr.Spec.ReuseFrom
+	source, err := r.GetPipelineRun(ctx, types.NamespacedName{Namespace: pr.Namespace, Name: reuse.Run})
+	if err != nil {
+		return false, "", err
+	}
+	if source == nil {
+		return false, "run " + reuse.Run + " not found", nil
+	}
+	done := true
+	for _, stepId := range reuse.Steps {
+		if findJobStep(pr, stepId) == nil {
+			return false, "run has no job step " + stepId, nil
+		}
+		if !hasSucceeded(source, stepId) {
+			return false, "step " + stepId + " has not succeeded in run " + reuse.Run, nil
+		}
+		if isSkipped(source, stepId) {
+			// there is no output to reuse, the step is skipped again
+			meta.SetStatusCondition(&pr.Status.Conditions, v1.Condition{
+				Type:    StepStatus(stepId),
+				Status:  v1.ConditionTrue,
+				Reason:  SkippedReason,
+				Message: "Step was skipped in run " + reuse.Run,
+			})
+			continue
+		}
+		ready, reason, err := r.artifactStore(pr).CopyOutput(ctx, log, pr, stepId, source)
+		if (len(reason) > 0) || (err != nil) {
+			return false, reason, err
+		}
+		if !ready {
+			done = false
+			continue
+		}
+		meta.SetStatusCondition(&pr.Status.Conditions, v1.Condition{
+			Type:    StepStatus(stepId),
+			Status:  v1.ConditionTrue,
+			Reason:  ReusedReason,
+			Message: "Output reused from run " + reuse.Run,
+		})
+		if outputs := findStepOutputs(source, stepId); outputs != nil {
+			setStepOutputs(pr, stepId, outputs)
+		}
+	}
+	if !done {
+		return false, "", nil
+	}
+	for _, stepId := range prunedSteps(pr.Status.PipelineStructure, reuse.Steps) {
+		meta.SetStatusCondition(&pr.Status.Conditions, v1.Condition{
+			Type:    StepStatus(stepId),
+			Status:  v1.ConditionTrue,
+			Reason:  SkippedReason,
+			Message: "Step only feeds steps reused from run " + reuse.Run,
+		})
+	}
+	return true, "", nil
+}
+
+func (r *PipelineRunReconciler) reuseSteps(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun) (*ctrl.Result, error) {
+	if (pr.Spec.ReuseFrom == nil) || isTrue(pr, StepsReused) {
+		// return nil result to indicate that reconciliation can proceed
+		return nil, nil
+	}
+	if pr.Annotations[ReusedFromAnnotation] != pr.Spec.ReuseFrom.Run {
+		// annotate first, the status of the run is written afterwards
+		if pr.Annotations == nil {
+			pr.Annotations = map[string]string{}
+		}
+		pr.Annotations[ReusedFromAnnotation] = pr.Spec.ReuseFrom.Run
+		if err := r.Update(ctx, pr); err != nil {
+			result := r.failed(ctx, "Failed to annotate PipelineRun", err, pr, r.Recorder)
+			return &result, err
+		}
+		return &ctrl.Result{}, nil
+	}
+	done, reason, err := r.cloneReusedSteps(ctx, log, pr)
+	if err != nil {
+		result := r.failed(ctx, "Failed to reuse steps from run "+pr.Spec.ReuseFrom.Run, err, pr, r.Recorder)
+		return &result, err
+	}
+	if len(reason) > 0 {
+		// the steps can not be reused, the run ends here
+		state := Failed
+		pr.Status.State = &state
+		if err := r.SetPipelineRunStatus(ctx, log, pr, StepsReused, v1.ConditionFalse, reason); err != nil {
+			result := r.failed(ctx, "Failed to set PipelineRun status", err, pr, r.Recorder)
+			return &result, err
+		}
+		r.Recorder.Event(pr, "Warning", "PipelineRunTerminated", reason)
+		return &ctrl.Result{}, nil
+	}
+	if !done {
+		// outputs are still being copied, the copy jobs trigger the next reconciliation
+		log("Waiting for outputs of reused steps to be copied")
+		return &ctrl.Result{}, nil
+	}
+	message := "Reused steps from run " + pr.Spec.ReuseFrom.Run + ": " + strings.Join(pr.Spec.ReuseFrom.Steps, ", ")
+	if err := r.SetPipelineRunStatus(ctx, log, pr, StepsReused, v1.ConditionTrue, message); err != nil {
+		result := r.failed(ctx, "Failed to set PipelineRun status", err, pr, r.Recorder)
+		return &result, err
+	}
+	r.Recorder.Event(pr, "Normal", "PipelineExecution", message)
+	// changes to state have been made, return empty result to stop current reconciliation iteration
+	return &ctrl.Result{}, nil
+}
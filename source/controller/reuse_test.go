@@ -0,0 +1,57 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+)
+
+var _ = Describe("Reusing steps", func() {
+	pipe := func(from string, to string) *pipelinev1.PipelinePipe {
+		return &pipelinev1.PipelinePipe{From: pipelinev1.PipeConnector{StepId: from, Name: "x"}, To: pipelinev1.PipeConnector{StepId: to, Name: from}}
+	}
+	structure := func(pipes ...*pipelinev1.PipelinePipe) *pipelinev1.PipelineStructure {
+		res := &pipelinev1.PipelineStructure{Pipes: pipes}
+		for _, id := range []string{"a", "b", "c", "d", "e", "f"} {
+			res.JobSteps = append(res.JobSteps, &pipelinev1.PipelineJobStepSpec{Id: id})
+		}
+		return res
+	}
+
+	It("should prune steps that only feed reused steps", func() {
+		// a -> b -> c -> output, e -> c, f without consumers, d -> b and d -> output
+		s := structure(pipe("input", "a"), pipe("a", "b"), pipe("b", "c"), pipe("e", "c"), pipe("c", "output"), pipe("d", "b"), pipe("d", "output"))
+		Expect(prunedSteps(s, []string{"b"})).To(Equal([]string{"a"}))
+		Expect(prunedSteps(s, []string{"c"})).To(ConsistOf("a", "b", "e"))
+		Expect(prunedSteps(s, []string{"a"})).To(BeEmpty())
+	})
+
+	It("should read the reuse method from the storage config map", func() {
+		cm := func(method string) *corev1.ConfigMap {
+			return &corev1.ConfigMap{Data: map[string]string{"reuseMethod": method}}
+		}
+		Expect(reuseMethod(nil)).To(Equal(ReuseMethodClone))
+		Expect(reuseMethod(cm(ReuseMethodSnapshot))).To(Equal(ReuseMethodSnapshot))
+		Expect(reuseMethod(cm(ReuseMethodCopy))).To(Equal(ReuseMethodCopy))
+		_, err := reuseMethod(cm("rsync"))
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,74 @@
+package controller
+
+import (
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sort"
+)
+
+// maximum number of steps that may be active at the same time, 0 if unlimited
+func maxParallelSteps(pr *pipelinev1.PipelineRun) int {
+	if pr.Spec.MaxParallelSteps != nil {
+		return int(*pr.Spec.MaxParallelSteps)
+	}
+	if pr.Status.PipelineStructure.MaxParallelSteps != nil {
+		return int(*pr.Status.PipelineStructure.MaxParallelSteps)
+	}
+	return 0
+}
+
+// number of steps that have been started and have not terminated, yet
+func numActiveSteps(pr *pipelinev1.PipelineRun) int {
+	res := 0
+	for _, stepId := range allStepIds(pr.Status.PipelineStructure) {
+		if meta.IsStatusConditionPresentAndEqual(pr.Status.Conditions, StepStatus(stepId), v1.ConditionUnknown) {
+			res++
+		}
+	}
+	return res
+}
+
+func priority(p *int32) int32 {
+	if p != nil {
+		return *p
+	}
+	return 0
+}
+
+/*
+find the ids of all steps (job steps and sub-pipelines) that are startable (i.e. not active yet and all input steps
+have succeeded). They are ordered by decreasing priority (then job steps before sub-pipelines, each in order of the
+definition) and limited to the number of steps that may still be started without exceeding the parallelism limit.
+*/
+func findStartableSteps(pr *pipelinev1.PipelineRun) []string {
+	type candidate struct {
+		stepId   string
+		priority int32
+	}
+	var candidates []candidate
+	for _, step := range pr.Status.PipelineStructure.JobSteps {
+		if !isActive(pr, step.Id) && allInputsSucceeded(pr, step.Id) {
+			candidates = append(candidates, candidate{stepId: step.Id, priority: priority(step.Priority)})
+		}
+	}
+	for _, sp := range pr.Status.PipelineStructure.SubPipelines {
+		if !isActive(pr, sp.Id) && allInputsSucceeded(pr, sp.Id) {
+			candidates = append(candidates, candidate{stepId: sp.Id, priority: priority(sp.Priority)})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].priority > candidates[j].priority
+	})
+	if limit := maxParallelSteps(pr); limit > 0 {
+		free := min0(limit - numActiveSteps(pr))
+		if len(candidates) > free {
+			candidates = candidates[:free]
+		}
+	}
+	var res []string
+	for _, c := range candidates {
+		res = append(res, c.stepId)
+	}
+	return res
+}
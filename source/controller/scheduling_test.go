@@ -0,0 +1,74 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+)
+
+var _ = Describe("Step scheduling", func() {
+	var pr *pipelinev1.PipelineRun
+	int32Ptr := func(i int32) *int32 {
+		return &i
+	}
+	setStatus := func(stepId string, status metav1.ConditionStatus) {
+		meta.SetStatusCondition(&pr.Status.Conditions, metav1.Condition{Type: StepStatus(stepId), Status: status, Reason: "Test"})
+	}
+
+	BeforeEach(func() {
+		pr = &pipelinev1.PipelineRun{
+			Status: pipelinev1.PipelineRunStatus{
+				PipelineStructure: &pipelinev1.PipelineStructure{
+					JobSteps: []*pipelinev1.PipelineJobStepSpec{
+						{Id: "a"},
+						{Id: "b"},
+						{Id: "c", Priority: int32Ptr(5)},
+						{Id: "d"},
+					},
+					SubPipelines: []*pipelinev1.SubPipelineSpec{
+						{Id: "e", Priority: int32Ptr(1)},
+					},
+					Pipes: []*pipelinev1.PipelinePipe{
+						{From: pipelinev1.PipeConnector{StepId: "a", Name: "x"}, To: pipelinev1.PipeConnector{StepId: "d", Name: "x"}},
+					},
+				},
+			},
+		}
+	})
+
+	It("should start all ready steps ordered by priority", func() {
+		Expect(findStartableSteps(pr)).To(Equal([]string{"c", "e", "a", "b"}))
+	})
+
+	It("should start steps once their inputs have succeeded", func() {
+		setStatus("a", metav1.ConditionTrue)
+		Expect(findStartableSteps(pr)).To(Equal([]string{"c", "e", "b", "d"}))
+	})
+
+	It("should respect the parallelism limit of the definition and the run", func() {
+		pr.Status.PipelineStructure.MaxParallelSteps = int32Ptr(3)
+		setStatus("c", metav1.ConditionUnknown)
+		Expect(findStartableSteps(pr)).To(Equal([]string{"e", "a"}))
+		pr.Spec.MaxParallelSteps = int32Ptr(1)
+		Expect(findStartableSteps(pr)).To(BeEmpty())
+	})
+})
@@ -0,0 +1,326 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Version is a parsed semantic version (see https://semver.org/)
+type Version struct {
+	Major      int
+	Minor      int
+	Patch      int
+	PreRelease []string
+	Build      string
+}
+
+// VersionPattern is a parsed version pattern: a disjunction (separated by "||") of conjunctions (separated by
+// whitespace) of single constraints. A constraint is either an exact or wildcard version like "1.2.3", "1.#.#",
+// "1.x" or "*", or a comparison like ">=1.2", "<2" or "=1.0.0".
+type VersionPattern struct {
+	alternatives [][]versionConstraint
+}
+
+type versionConstraint struct {
+	operator string
+	// version components, nil represents a wildcard
+	components [3]*int
+	preRelease []string
+}
+
+var wildcards = map[string]bool{"#": true, "*": true, "x": true, "X": true}
+
+var operators = []string{">=", "<=", "!=", ">", "<", "="}
+
+// ParseVersion parses a version string according to semver 2
+func ParseVersion(version string) (*Version, error) {
+	rest := strings.TrimSpace(version)
+	res := &Version{}
+	if i := strings.Index(rest, "+"); i >= 0 {
+		res.Build = rest[i+1:]
+		rest = rest[:i]
+		if !validIdentifiers(res.Build, false) {
+			return nil, errors.New("invalid build metadata in version " + version)
+		}
+	}
+	if i := strings.Index(rest, "-"); i >= 0 {
+		preRelease := rest[i+1:]
+		rest = rest[:i]
+		if !validIdentifiers(preRelease, true) {
+			return nil, errors.New("invalid pre-release in version " + version)
+		}
+		res.PreRelease = strings.Split(preRelease, ".")
+	}
+	parts := strings.Split(rest, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("version must consist of major, minor and patch number: " + version)
+	}
+	numbers := []*int{&res.Major, &res.Minor, &res.Patch}
+	for i, part := range parts {
+		n, err := parseNumber(part)
+		if err != nil {
+			return nil, errors.New("invalid version " + version + ": " + err.Error())
+		}
+		*numbers[i] = n
+	}
+	return res, nil
+}
+
+// String returns the canonical representation of the version
+func (v *Version) String() string {
+	res := strconv.Itoa(v.Major) + "." + strconv.Itoa(v.Minor) + "." + strconv.Itoa(v.Patch)
+	if len(v.PreRelease) > 0 {
+		res = res + "-" + strings.Join(v.PreRelease, ".")
+	}
+	if len(v.Build) > 0 {
+		res = res + "+" + v.Build
+	}
+	return res
+}
+
+// Compare returns -1, 0 or 1 if v has lower, equal or higher precedence than other (build metadata is ignored)
+func (v *Version) Compare(other *Version) int {
+	if c := compareInt(v.Major, other.Major); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Minor, other.Minor); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Patch, other.Patch); c != 0 {
+		return c
+	}
+	return comparePreRelease(v.PreRelease, other.PreRelease)
+}
+
+// ParseVersionPattern parses a version pattern, see VersionPattern for the supported syntax
+func ParseVersionPattern(pattern string) (*VersionPattern, error) {
+	res := &VersionPattern{}
+	for _, alternative := range strings.Split(pattern, "||") {
+		var constraints []versionConstraint
+		for _, field := range strings.Fields(alternative) {
+			constraint, err := parseConstraint(field)
+			if err != nil {
+				return nil, errors.New("invalid version pattern " + pattern + ": " + err.Error())
+			}
+			constraints = append(constraints, *constraint)
+		}
+		if len(constraints) == 0 {
+			return nil, errors.New("invalid version pattern " + pattern + ": empty constraint")
+		}
+		res.alternatives = append(res.alternatives, constraints)
+	}
+	return res, nil
+}
+
+// Matches checks if the version satisfies the pattern
+func (p *VersionPattern) Matches(v *Version) bool {
+	for _, constraints := range p.alternatives {
+		if allConstraintsMatch(constraints, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func allConstraintsMatch(constraints []versionConstraint, v *Version) bool {
+	for _, c := range constraints {
+		if !c.matches(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseConstraint(field string) (*versionConstraint, error) {
+	res := &versionConstraint{}
+	for _, op := range operators {
+		if strings.HasPrefix(field, op) {
+			res.operator = op
+			field = field[len(op):]
+			break
+		}
+	}
+	if i := strings.Index(field, "+"); i >= 0 {
+		// build metadata has no influence on precedence
+		field = field[:i]
+	}
+	if i := strings.Index(field, "-"); i >= 0 {
+		preRelease := field[i+1:]
+		field = field[:i]
+		if !validIdentifiers(preRelease, true) {
+			return nil, errors.New("invalid pre-release " + preRelease)
+		}
+		res.preRelease = strings.Split(preRelease, ".")
+	}
+	parts := strings.Split(field, ".")
+	if len(parts) > 3 {
+		return nil, errors.New("too many version components in " + field)
+	}
+	wildcard := false
+	for i, part := range parts {
+		if wildcards[part] {
+			wildcard = true
+			continue
+		}
+		if wildcard {
+			return nil, errors.New("wildcard must not be followed by a number in " + field)
+		}
+		n, err := parseNumber(part)
+		if err != nil {
+			return nil, err
+		}
+		res.components[i] = &n
+	}
+	if res.preRelease != nil && (wildcard || len(parts) < 3) {
+		return nil, errors.New("pre-release requires a full version in " + field)
+	}
+	if res.operator != "" && res.operator != "=" && wildcard {
+		return nil, errors.New("wildcards are not allowed with operator " + res.operator)
+	}
+	return res, nil
+}
+
+func (c *versionConstraint) matches(v *Version) bool {
+	switch c.operator {
+	case "", "=":
+		return c.matchesExactly(v)
+	case "!=":
+		return !c.matchesExactly(v)
+	}
+	// a pre-release only satisfies a comparison if the constraint refers to a pre-release of the same version
+	if len(v.PreRelease) > 0 && !(len(c.preRelease) > 0 && c.sameNumbers(v)) {
+		return false
+	}
+	cmp := v.Compare(c.lowerBound())
+	// a partial version like ">1.2" or "<=1.2" refers to all versions 1.2.x, a full version is compared by precedence
+	// (including the pre-release identifiers)
+	partialMatch := c.partial() && c.sameNumbersUpToGiven(v)
+	switch c.operator {
+	case ">=":
+		return cmp >= 0
+	case ">":
+		return cmp > 0 && !partialMatch
+	case "<=":
+		return cmp <= 0 || partialMatch
+	case "<":
+		return cmp < 0
+	}
+	return false
+}
+
+// check if not all version components are given (e.g. "1.2")
+func (c *versionConstraint) partial() bool {
+	for _, component := range c.components {
+		if component == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// exact match of all given components, wildcards match anything; pre-releases only match if explicitly given
+func (c *versionConstraint) matchesExactly(v *Version) bool {
+	if !c.sameNumbersUpToGiven(v) {
+		return false
+	}
+	return comparePreRelease(c.preRelease, v.PreRelease) == 0
+}
+
+func (c *versionConstraint) sameNumbersUpToGiven(v *Version) bool {
+	numbers := []int{v.Major, v.Minor, v.Patch}
+	for i, component := range c.components {
+		if component != nil && *component != numbers[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *versionConstraint) sameNumbers(v *Version) bool {
+	bound := c.lowerBound()
+	return bound.Major == v.Major && bound.Minor == v.Minor && bound.Patch == v.Patch
+}
+
+// the smallest version that matches all given components (missing components are set to 0)
+func (c *versionConstraint) lowerBound() *Version {
+	numbers := [3]int{}
+	for i, component := range c.components {
+		if component != nil {
+			numbers[i] = *component
+		}
+	}
+	return &Version{Major: numbers[0], Minor: numbers[1], Patch: numbers[2], PreRelease: c.preRelease}
+}
+
+func parseNumber(s string) (int, error) {
+	if len(s) == 0 {
+		return 0, errors.New("empty version number")
+	}
+	if len(s) > 1 && s[0] == '0' {
+		return 0, errors.New("version number must not have leading zeros: " + s)
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, errors.New("not a valid version number: " + s)
+	}
+	return n, nil
+}
+
+func validIdentifiers(s string, noLeadingZeros bool) bool {
+	for _, id := range strings.Split(s, ".") {
+		if len(id) == 0 {
+			return false
+		}
+		numeric := true
+		for _, ch := range id {
+			if !((ch >= '0' && ch <= '9') || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || ch == '-') {
+				return false
+			}
+			if ch < '0' || ch > '9' {
+				numeric = false
+			}
+		}
+		if noLeadingZeros && numeric && len(id) > 1 && id[0] == '0' {
+			return false
+		}
+	}
+	return true
+}
+
+func compareInt(a int, b int) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+// compare pre-release identifiers according to semver 2 precedence rules (no pre-release has higher precedence)
+func comparePreRelease(a []string, b []string) int {
+	if len(a) == 0 || len(b) == 0 {
+		return -compareInt(len(a), len(b))
+	}
+	for i := 0; i < len(a) && i < len(b); i++ {
+		an, aErr := strconv.Atoi(a[i])
+		bn, bErr := strconv.Atoi(b[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if c := compareInt(an, bn); c != 0 {
+				return c
+			}
+		case aErr == nil:
+			// numeric identifiers have lower precedence than alphanumeric ones
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return compareInt(len(a), len(b))
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Semantic versions", func() {
+	matches := func(pattern string, version string) bool {
+		p, err := ParseVersionPattern(pattern)
+		Expect(err).NotTo(HaveOccurred())
+		v, err := ParseVersion(version)
+		Expect(err).NotTo(HaveOccurred())
+		return p.Matches(v)
+	}
+
+	It("should parse valid versions and reject invalid ones", func() {
+		v, err := ParseVersion("1.2.3-rc.1+build.5")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(v.String()).To(Equal("1.2.3-rc.1+build.5"))
+		for _, invalid := range []string{"1.2", "1.2.3.4", "01.2.3", "1.2.x", "1.2.3-", "1.2.3-01"} {
+			_, err := ParseVersion(invalid)
+			Expect(err).To(HaveOccurred(), invalid)
+		}
+	})
+
+	It("should order versions by semver precedence", func() {
+		ordered := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.10.0", "2.0.0"}
+		for i := 0; i < len(ordered)-1; i++ {
+			a, _ := ParseVersion(ordered[i])
+			b, _ := ParseVersion(ordered[i+1])
+			Expect(a.Compare(b)).To(Equal(-1), ordered[i]+" < "+ordered[i+1])
+			Expect(b.Compare(a)).To(Equal(1), ordered[i+1]+" > "+ordered[i])
+		}
+	})
+
+	It("should match exact pins and wildcards", func() {
+		Expect(matches("1.0.0", "1.0.0")).To(BeTrue())
+		Expect(matches("1.0.0", "1.0.1")).To(BeFalse())
+		Expect(matches("1.#.#", "1.7.3")).To(BeTrue())
+		Expect(matches("1.#.#", "2.0.0")).To(BeFalse())
+		Expect(matches("1.x", "1.7.3")).To(BeTrue())
+		Expect(matches("*", "3.2.1")).To(BeTrue())
+		Expect(matches("1.#.#", "1.2.0-rc.1")).To(BeFalse())
+		Expect(matches("1.2.0-rc.1", "1.2.0-rc.1")).To(BeTrue())
+	})
+
+	It("should match ranges", func() {
+		Expect(matches(">=1.2 <2", "1.2.0")).To(BeTrue())
+		Expect(matches(">=1.2 <2", "1.9.9")).To(BeTrue())
+		Expect(matches(">=1.2 <2", "1.1.9")).To(BeFalse())
+		Expect(matches(">=1.2 <2", "2.0.0")).To(BeFalse())
+		Expect(matches(">1.2", "1.2.5")).To(BeFalse())
+		Expect(matches(">1.2", "1.3.0")).To(BeTrue())
+		Expect(matches("<=1.2", "1.2.5")).To(BeTrue())
+		Expect(matches("<1 || >=3", "3.1.0")).To(BeTrue())
+		Expect(matches("<1 || >=3", "2.0.0")).To(BeFalse())
+		Expect(matches(">=1.0.0", "2.0.0-beta")).To(BeFalse())
+		Expect(matches(">=2.0.0-alpha", "2.0.0-beta")).To(BeTrue())
+	})
+
+	DescribeTable("should compare with pre-release bounds by semver precedence",
+		func(pattern string, version string, expected bool) {
+			Expect(matches(pattern, version)).To(Equal(expected))
+		},
+		Entry(">1.2.3-alpha accepts the release", ">1.2.3-alpha", "1.2.3", true),
+		Entry(">1.2.3-alpha accepts later pre-releases", ">1.2.3-alpha", "1.2.3-beta", true),
+		Entry(">1.2.3-alpha rejects the bound", ">1.2.3-alpha", "1.2.3-alpha", false),
+		Entry(">1.2.3-beta rejects earlier pre-releases", ">1.2.3-beta", "1.2.3-alpha.1", false),
+		Entry(">=1.2.3-beta accepts the bound", ">=1.2.3-beta", "1.2.3-beta", true),
+		Entry(">=1.2.3-beta accepts the release", ">=1.2.3-beta", "1.2.3", true),
+		Entry("<=1.2.3-beta rejects the release", "<=1.2.3-beta", "1.2.3", false),
+		Entry("<=1.2.3-beta accepts the bound", "<=1.2.3-beta", "1.2.3-beta", true),
+		Entry("<=1.2.3-beta accepts earlier pre-releases", "<=1.2.3-beta", "1.2.3-alpha", true),
+		Entry("<=1.2.3-beta rejects later pre-releases", "<=1.2.3-beta", "1.2.3-rc.1", false),
+		Entry("<1.2.3-rc.1 accepts earlier pre-releases", "<1.2.3-rc.1", "1.2.3-beta.11", true),
+		Entry("<1.2.3-rc.1 rejects the release", "<1.2.3-rc.1", "1.2.3", false),
+		Entry("<1.2.3 accepts earlier releases", "<1.2.3", "1.2.2", true),
+		Entry("<=1.2.3 accepts the bound", "<=1.2.3", "1.2.3", true),
+		Entry(">1.2.3 rejects the bound", ">1.2.3", "1.2.3", false),
+		Entry("pre-releases of other versions do not match comparisons", ">1.2.3-alpha", "1.2.4-alpha", false),
+	)
+
+	It("should reject invalid patterns", func() {
+		for _, invalid := range []string{"", "1.#.2", ">=1.#", "1.2.3.4", "a.b.c"} {
+			_, err := ParseVersionPattern(invalid)
+			Expect(err).To(HaveOccurred(), invalid)
+		}
+	})
+})
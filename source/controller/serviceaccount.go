@@ -22,25 +22,17 @@ func (r *PipelineDefinitionReconciler) GetServiceAccount(ctx context.Context, na
 /*
 create ConfigMap
 */
-func (r *PipelineDefinitionReconciler) CreateServiceAccount(ctx context.Context, log func(string, ...interface{}), pd *pipelinev1.PipelineDefinition, name types.NamespacedName, annotatationLabel string, annotationValue string) (*corev1.ServiceAccount, error) {
+func (r *PipelineDefinitionReconciler) CreateServiceAccount(ctx context.Context, log func(string, ...interface{}), pd *pipelinev1.PipelineDefinition, name types.NamespacedName, annotations map[string]string) (*corev1.ServiceAccount, error) {
 
 	// the labels to be attached to pvc
-	labels := map[string]string{
-		"app.kubernetes.io/name":       "ServiceAccount",
-		"app.kubernetes.io/instance":   name.Name,
-		"app.kubernetes.io/version":    "v1",
-		"app.kubernetes.io/part-of":    "pipeline-operator",
-		"app.kubernetes.io/created-by": "controller-manager", // TODO should we change this?
-	}
+	labels := currentConfig().resourceLabels("ServiceAccount", name.Name)
 
 	sa := corev1.ServiceAccount{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name.Name, // claim gets same name as volume it claims
-			Namespace: name.Namespace,
-			Labels:    labels,
-			Annotations: map[string]string{
-				annotatationLabel: annotationValue,
-			},
+			Name:        name.Name, // claim gets same name as volume it claims
+			Namespace:   name.Namespace,
+			Labels:      labels,
+			Annotations: annotations,
 		},
 	}
 	if err := ctrl.SetControllerReference(pd, &sa, r.Scheme); err != nil {
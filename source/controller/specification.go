@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+)
+
+/*
+apply the specification of a job spec to the pod template of a Job by strategic merge patch, the volumes, containers,
+volume mounts and labels generated by the operator must not be changed by it
+*/
+func applySpecification(job *batchv1.Job, specification json.RawMessage) error {
+	patch, err := pipelinev1.SpecificationPatch(specification)
+	if (err != nil) || (patch == nil) {
+		return err
+	}
+	if patch, err = retargetMainContainer(patch, &job.Spec.Template.Spec); err != nil {
+		return err
+	}
+	original, err := json.Marshal(job.Spec.Template)
+	if err != nil {
+		return err
+	}
+	merged, err := strategicpatch.StrategicMergePatch(original, patch, corev1.PodTemplateSpec{})
+	if err != nil {
+		return fmt.Errorf("specification can not be applied: %w", err)
+	}
+	template := corev1.PodTemplateSpec{}
+	if err := json.Unmarshal(merged, &template); err != nil {
+		return fmt.Errorf("specification can not be applied: %w", err)
+	}
+	if err := checkOperatorSettings(&job.Spec.Template, &template); err != nil {
+		return err
+	}
+	job.Spec.Template = template
+	return nil
+}
+
+/*
+when the step container runs as init container (its output is uploaded to the artifact store afterwards), the patch of
+the container "main" is moved to the init containers
+*/
+func retargetMainContainer(patch []byte, spec *corev1.PodSpec) ([]byte, error) {
+	for _, c := range spec.Containers {
+		if c.Name == "main" {
+			return patch, nil
+		}
+	}
+	template := map[string]interface{}{}
+	if err := json.Unmarshal(patch, &template); err != nil {
+		return nil, err
+	}
+	podSpec, ok := template["spec"].(map[string]interface{})
+	if !ok {
+		return patch, nil
+	}
+	containers, ok := podSpec["containers"].([]interface{})
+	if !ok {
+		return patch, nil
+	}
+	var others []interface{}
+	for _, c := range containers {
+		if fields, ok := c.(map[string]interface{}); ok && (fields["name"] == "main") {
+			initContainers, _ := podSpec["initContainers"].([]interface{})
+			podSpec["initContainers"] = append(initContainers, c)
+		} else {
+			others = append(others, c)
+		}
+	}
+	if len(others) > 0 {
+		podSpec["containers"] = others
+	} else {
+		delete(podSpec, "containers")
+	}
+	return json.Marshal(template)
+}
+
+func findContainer(containers []corev1.Container, name string) *corev1.Container {
+	for i := range containers {
+		if containers[i].Name == name {
+			return &containers[i]
+		}
+	}
+	return nil
+}
+
+// check that a patched pod template still has the volumes, containers, volume mounts and labels of the original one
+func checkOperatorSettings(original *corev1.PodTemplateSpec, patched *corev1.PodTemplateSpec) error {
+	for _, volume := range original.Spec.Volumes {
+		found := false
+		for _, v := range patched.Spec.Volumes {
+			if v.Name == volume.Name {
+				found = equality.Semantic.DeepEqual(v, volume)
+			}
+		}
+		if !found {
+			return errors.New("specification must not override volume " + volume.Name)
+		}
+	}
+	for _, lists := range [][2][]corev1.Container{{original.Spec.InitContainers, patched.Spec.InitContainers}, {original.Spec.Containers, patched.Spec.Containers}} {
+		for _, container := range lists[0] {
+			c := findContainer(lists[1], container.Name)
+			if c == nil {
+				return errors.New("specification must not remove container " + container.Name)
+			}
+			if (c.Image != container.Image) || !equality.Semantic.DeepEqual(c.Command, container.Command) || !equality.Semantic.DeepEqual(c.Args, container.Args) {
+				return errors.New("specification must not change image, command or args of container " + container.Name)
+			}
+			for _, mount := range container.VolumeMounts {
+				found := false
+				for _, m := range c.VolumeMounts {
+					if m.MountPath == mount.MountPath {
+						found = equality.Semantic.DeepEqual(m, mount)
+					}
+				}
+				if !found {
+					return errors.New("specification must not override mount " + mount.MountPath + " of container " + container.Name)
+				}
+			}
+		}
+	}
+	for key, value := range original.Labels {
+		if patched.Labels[key] != value {
+			return errors.New("specification must not override label " + key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Job specification", func() {
+	var job *batchv1.Job
+
+	BeforeEach(func() {
+		mounts := []corev1.VolumeMount{getVolumeMount("workdir", "/workdir")}
+		job = &batchv1.Job{
+			Spec: batchv1.JobSpec{
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app.kubernetes.io/name": "PipelineJob"}},
+					Spec: corev1.PodSpec{
+						Volumes:        []corev1.Volume{{Name: "workdir", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}},
+						InitContainers: []corev1.Container{{Name: "init", Image: "bash", VolumeMounts: mounts}},
+						Containers:     []corev1.Container{{Name: "main", Image: "busybox", VolumeMounts: mounts}},
+					},
+				},
+			},
+		}
+	})
+
+	It("should apply a partial pod spec", func() {
+		spec := `{"tolerations": [{"key": "gpu", "operator": "Exists"}], "containers": [{"name": "main", "securityContext": {"runAsNonRoot": true}}, {"name": "proxy", "image": "envoy"}]}`
+		Expect(applySpecification(job, json.RawMessage(spec))).To(Succeed())
+		podSpec := job.Spec.Template.Spec
+		Expect(podSpec.Tolerations).To(HaveLen(1))
+		Expect(podSpec.Containers).To(HaveLen(2))
+		Expect(podSpec.Containers[0].Image).To(Equal("busybox"))
+		Expect(*podSpec.Containers[0].SecurityContext.RunAsNonRoot).To(BeTrue())
+		Expect(podSpec.Containers[0].VolumeMounts).To(HaveLen(1))
+	})
+
+	It("should apply a full pod template spec", func() {
+		spec := `{"metadata": {"annotations": {"a": "b"}}, "spec": {"volumes": [{"name": "cache", "emptyDir": {}}]}}`
+		Expect(applySpecification(job, json.RawMessage(spec))).To(Succeed())
+		Expect(job.Spec.Template.Annotations).To(HaveKeyWithValue("a", "b"))
+		Expect(job.Spec.Template.Labels).To(HaveKey("app.kubernetes.io/name"))
+		Expect(job.Spec.Template.Spec.Volumes).To(HaveLen(2))
+	})
+
+	It("should apply the patch of the step container when it runs as init container", func() {
+		job.Spec.Template.Spec.InitContainers = append(job.Spec.Template.Spec.InitContainers, job.Spec.Template.Spec.Containers[0])
+		job.Spec.Template.Spec.Containers = []corev1.Container{{Name: "upload", Image: "amazon/aws-cli"}}
+		Expect(applySpecification(job, json.RawMessage(`{"containers": [{"name": "main", "workingDir": "/tmp"}]}`))).To(Succeed())
+		Expect(job.Spec.Template.Spec.Containers).To(HaveLen(1))
+		Expect(job.Spec.Template.Spec.InitContainers[1].WorkingDir).To(Equal("/tmp"))
+	})
+
+	It("should protect the volumes, containers and mounts of the operator", func() {
+		for _, spec := range []string{
+			`{"volumes": [{"name": "workdir", "hostPath": {"path": "/tmp"}}]}`,
+			`{"containers": [{"name": "main", "image": "other"}]}`,
+			`{"containers": [{"name": "main", "volumeMounts": [{"name": "other", "mountPath": "/workdir"}]}]}`,
+			`{"metadata": {"labels": {"app.kubernetes.io/name": "other"}}}`,
+			`{"containers": [{"name": "main", "$patch": "delete"}]}`,
+			`["not", "an", "object"]`,
+		} {
+			original := job.DeepCopy()
+			Expect(applySpecification(job, json.RawMessage(spec))).NotTo(Succeed(), spec)
+			Expect(job).To(Equal(original))
+		}
+	})
+})
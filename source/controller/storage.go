@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	"strings"
+)
+
+const (
+	/*
+		name of the config map holding the storage defaults and limits of a namespace, the keys are: size,
+		storageClassName, accessMode, workdirSizeLimit (defaults), maxSize, maxWorkdirSizeLimit (limits),
+		allowedStorageClasses (comma separated) and reuseMethod, volumeSnapshotClassName (copying of reused outputs)
+	*/
+	StorageConfigMap = "pipeline-storage"
+
+	DefaultVolumeSize       = "10Gi"
+	DefaultWorkdirSizeLimit = "1Gi"
+)
+
+// the storage settings of a job step after applying the namespace defaults
+type storageSettings struct {
+	size             resource.Quantity
+	storageClassName *string
+	accessMode       corev1.PersistentVolumeAccessMode
+	workdirSizeLimit resource.Quantity
+}
+
+// the storage config map of a namespace, returns nil if it does not exist
+func (r *PipelineRunReconciler) getStorageConfig(ctx context.Context, namespace string) (*corev1.ConfigMap, error) {
+	res := &corev1.ConfigMap{}
+	notexists, err := NotExistsResource(r, ctx, res, types.NamespacedName{Namespace: namespace, Name: StorageConfigMap})
+	if notexists {
+		res = nil
+	}
+	return res, err
+}
+
+// the value of a key of the storage config map (empty if not set)
+func storageConfig(cm *corev1.ConfigMap, key string) string {
+	if cm == nil {
+		return ""
+	}
+	return strings.TrimSpace(cm.Data[key])
+}
+
+// the quantity given in the step spec, otherwise the one of the config map key, otherwise the fallback
+func quantity(specified *resource.Quantity, cm *corev1.ConfigMap, key string, fallback string) (resource.Quantity, error) {
+	if specified != nil {
+		return specified.DeepCopy(), nil
+	}
+	value := storageConfig(cm, key)
+	if len(value) == 0 {
+		value = fallback
+	}
+	res, err := resource.ParseQuantity(value)
+	if err != nil {
+		return res, fmt.Errorf("invalid value of %s in config map %s: %s", key, StorageConfigMap, value)
+	}
+	return res, nil
+}
+
+// check a quantity against the limit given by a key of the config map (if set)
+func checkLimit(name string, q resource.Quantity, cm *corev1.ConfigMap, key string) error {
+	value := storageConfig(cm, key)
+	if len(value) == 0 {
+		return nil
+	}
+	limit, err := resource.ParseQuantity(value)
+	if err != nil {
+		return fmt.Errorf("invalid value of %s in config map %s: %s", key, StorageConfigMap, value)
+	}
+	if q.Cmp(limit) > 0 {
+		return fmt.Errorf("%s %s exceeds the limit of %s", name, q.String(), limit.String())
+	}
+	return nil
+}
+
+/*
+determine the storage settings of a step from its spec and the defaults and limits of the namespace (cm may be nil), the
+storage class defaults to the one given by env var STORAGE_CLASS
+*/
+func resolveStorage(spec *pipelinev1.StorageSpec, cm *corev1.ConfigMap) (*storageSettings, error) {
+	if spec == nil {
+		spec = &pipelinev1.StorageSpec{}
+	}
+	var err error
+	res := &storageSettings{}
+	if res.size, err = quantity(spec.Size, cm, "size", DefaultVolumeSize); err != nil {
+		return nil, err
+	}
+	if res.workdirSizeLimit, err = quantity(spec.WorkdirSizeLimit, cm, "workdirSizeLimit", DefaultWorkdirSizeLimit); err != nil {
+		return nil, err
+	}
+	if err := checkLimit("volume size", res.size, cm, "maxSize"); err != nil {
+		return nil, err
+	}
+	if err := checkLimit("workdir size limit", res.workdirSizeLimit, cm, "maxWorkdirSizeLimit"); err != nil {
+		return nil, err
+	}
+
+	res.storageClassName = spec.StorageClassName
+	if res.storageClassName == nil {
+		if value := storageConfig(cm, "storageClassName"); len(value) > 0 {
+			res.storageClassName = &value
+		} else {
+			res.storageClassName = env("STORAGE_CLASS")
+		}
+	}
+	if allowed := storageConfig(cm, "allowedStorageClasses"); (len(allowed) > 0) && (spec.StorageClassName != nil) {
+		found := false
+		for _, sc := range strings.Split(allowed, ",") {
+			if strings.TrimSpace(sc) == *spec.StorageClassName {
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("storage class %s is not allowed (allowed are: %s)", *spec.StorageClassName, allowed)
+		}
+	}
+
+	res.accessMode = spec.AccessMode
+	if len(res.accessMode) == 0 {
+		res.accessMode = corev1.PersistentVolumeAccessMode(storageConfig(cm, "accessMode"))
+	}
+	if len(res.accessMode) == 0 {
+		res.accessMode = corev1.ReadWriteOnce
+	}
+	return res, nil
+}
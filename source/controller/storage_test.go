@@ -0,0 +1,73 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var _ = Describe("Step storage", func() {
+	quantity := func(s string) *resource.Quantity {
+		q := resource.MustParse(s)
+		return &q
+	}
+	str := func(s string) *string {
+		return &s
+	}
+	config := func(data map[string]string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{Data: data}
+	}
+
+	It("should use the built-in defaults without spec and config map", func() {
+		storage, err := resolveStorage(nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(storage.size.Cmp(resource.MustParse("10Gi"))).To(Equal(0))
+		Expect(storage.workdirSizeLimit.Cmp(resource.MustParse("1Gi"))).To(Equal(0))
+		Expect(storage.accessMode).To(Equal(corev1.ReadWriteOnce))
+	})
+
+	It("should prefer the step spec over the namespace defaults", func() {
+		cm := config(map[string]string{"size": "5Gi", "storageClassName": "standard", "accessMode": "ReadWriteMany"})
+		storage, err := resolveStorage(&pipelinev1.StorageSpec{Size: quantity("200Gi")}, cm)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(storage.size.Cmp(resource.MustParse("200Gi"))).To(Equal(0))
+		Expect(*storage.storageClassName).To(Equal("standard"))
+		Expect(storage.accessMode).To(Equal(corev1.ReadWriteMany))
+	})
+
+	It("should enforce the namespace limits", func() {
+		cm := config(map[string]string{"maxSize": "100Gi", "maxWorkdirSizeLimit": "2Gi", "allowedStorageClasses": "standard, premium"})
+		_, err := resolveStorage(&pipelinev1.StorageSpec{Size: quantity("200Gi")}, cm)
+		Expect(err).To(HaveOccurred())
+		_, err = resolveStorage(&pipelinev1.StorageSpec{WorkdirSizeLimit: quantity("4Gi")}, cm)
+		Expect(err).To(HaveOccurred())
+		_, err = resolveStorage(&pipelinev1.StorageSpec{StorageClassName: str("fast")}, cm)
+		Expect(err).To(HaveOccurred())
+		_, err = resolveStorage(&pipelinev1.StorageSpec{Size: quantity("100Gi"), StorageClassName: str("premium")}, cm)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should reject invalid config map values", func() {
+		_, err := resolveStorage(nil, config(map[string]string{"size": "lots"}))
+		Expect(err).To(HaveOccurred())
+	})
+})
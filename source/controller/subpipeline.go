@@ -0,0 +1,249 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"strings"
+)
+
+// find the sub-pipeline with given step id, returns nil if the step is not a sub-pipeline
+func findSubPipeline(pr *pipelinev1.PipelineRun, stepId string) *pipelinev1.SubPipelineSpec {
+	for _, sp := range pr.Status.PipelineStructure.SubPipelines {
+		if sp.Id == stepId {
+			return sp
+		}
+	}
+	return nil
+}
+
+// namespace in which the child run of a sub-pipeline is executed
+func subPipelineNamespace(pr *pipelinev1.PipelineRun, sp *pipelinev1.SubPipelineSpec) string {
+	if len(sp.Namespace) > 0 {
+		return sp.Namespace
+	}
+	return pr.Namespace
+}
+
+// parse the parent run reference of a child run, returns nil if the run has no parent
+func parentRunName(pr *pipelinev1.PipelineRun) *types.NamespacedName {
+	if pr.Spec.ParentRun == nil || len(*pr.Spec.ParentRun) == 0 {
+		return nil
+	}
+	if namespace, name, found := strings.Cut(*pr.Spec.ParentRun, "/"); found {
+		return &types.NamespacedName{Namespace: namespace, Name: name}
+	}
+	return &types.NamespacedName{Namespace: pr.Namespace, Name: *pr.Spec.ParentRun}
+}
+
+// Gets the child run that executes the given sub-pipeline step, returns nil,nil if not found
+func (r *PipelineRunReconciler) GetChildPipelineRun(ctx context.Context, pr *pipelinev1.PipelineRun, sp *pipelinev1.SubPipelineSpec) (*pipelinev1.PipelineRun, error) {
+	return r.GetPipelineRun(ctx, types.NamespacedName{Namespace: subPipelineNamespace(pr, sp), Name: r.ConstructPipelineJobName(pr, sp.Id)})
+}
+
+/*
+create child PipelineRun executing a sub-pipeline step
+*/
+func (r *PipelineRunReconciler) CreateChildPipelineRun(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, sp *pipelinev1.SubPipelineSpec) error {
+	bindings, err := r.resolveInputBindings(ctx, pr, sp.Id, subPipelineNamespace(pr, sp))
+	if err != nil {
+		return err
+	}
+	return r.createChildRun(ctx, log, pr, sp, r.ConstructPipelineJobName(pr, sp.Id), bindings, "")
+}
+
+// create the child run of a sub-pipeline, batchIndex is the index of the batch item it processes (empty if not batched)
+func (r *PipelineRunReconciler) createChildRun(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, sp *pipelinev1.SubPipelineSpec, name string, bindings []pipelinev1.PipeBinding, batchIndex string) error {
+	namespace := subPipelineNamespace(pr, sp)
+
+	// the labels to be attached to child run, the label keys are recorded since they are read back
+	config := currentConfig()
+	runLabels := config.resourceLabels("PipelineRun", name)
+	runLabels[config.LabelKeys.ParentStep] = sp.Id
+	if len(batchIndex) > 0 {
+		runLabels[config.LabelKeys.BatchIndex] = batchIndex
+	}
+	parentRun := pr.Namespace + "/" + pr.Name
+	child := &pipelinev1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    runLabels,
+			Annotations: map[string]string{
+				LabelKeysAnnotation: config.labelKeysAnnotation(),
+			},
+		},
+		Spec: pipelinev1.PipelineRunSpec{
+			PipelineName:   sp.PipelineName,
+			VersionPattern: sp.VersionPattern,
+			Description:    sp.Description,
+			ParentRun:      &parentRun,
+			InputPipes:     bindings,
+			Control:        control(pr),
+			ArtifactStore:  pr.Spec.ArtifactStore.DeepCopy(),
+			Retention:      pr.Spec.Retention.DeepCopy(),
+			Parameters:     pr.Spec.Parameters,
+		},
+	}
+	// owner references can not cross namespaces, child runs in other namespaces are only linked by ParentRun
+	if namespace == pr.Namespace {
+		if err := ctrl.SetControllerReference(pr, child, r.Scheme); err != nil {
+			return err
+		}
+	}
+
+	log("Creating a new child PipelineRun", "PipelineRun.Namespace", child.Namespace, "PipelineRun.Name", child.Name)
+	return CreateOrUpdate(r, r, ctx, log, child, &pipelinev1.PipelineRun{})
+}
+
+// determine the input pipes of a step, the volumes must be located in the given namespace
+func (r *PipelineRunReconciler) resolveInputPipes(ctx context.Context, pr *pipelinev1.PipelineRun, stepId string, namespace string) ([]pipelinev1.InputPipe, error) {
+	bindings, err := r.resolveInputBindings(ctx, pr, stepId, namespace)
+	if err != nil {
+		return nil, err
+	}
+	var res []pipelinev1.InputPipe
+	for _, binding := range bindings {
+		in, err := toInputPipe(&binding, binding.Name)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, in)
+	}
+	return res, nil
+}
+
+// determine the bindings of the input pipes of a step (named by the receiving end), the volumes must be located in the
+// given namespace. Pipes from skipped steps are left out.
+func (r *PipelineRunReconciler) resolveInputBindings(ctx context.Context, pr *pipelinev1.PipelineRun, stepId string, namespace string) ([]pipelinev1.PipeBinding, error) {
+	var res []pipelinev1.PipeBinding
+	for _, pipe := range pr.Status.PipelineStructure.Pipes {
+		if (pipe.To.StepId != stepId) || isSkipped(pr, pipe.From.StepId) {
+			continue
+		}
+		binding, err := r.resolvePipeSource(ctx, pr, pipe.From)
+		if err != nil {
+			return nil, err
+		}
+		if namespace != pr.Namespace {
+			return nil, errors.New("pipes into steps in namespace " + namespace + " are not supported (run namespace is " + pr.Namespace + ")")
+		}
+		binding.Name = pipe.To.Name
+		res = append(res, *binding)
+	}
+	return res, nil
+}
+
+// determine volume and file that is the source of a pipe
+func (r *PipelineRunReconciler) resolvePipeSource(ctx context.Context, pr *pipelinev1.PipelineRun, from pipelinev1.PipeConnector) (*pipelinev1.PipeBinding, error) {
+	if from.StepId == pipelinev1.InputStepId {
+		for _, binding := range pr.Spec.InputPipes {
+			if binding.Name == from.Name {
+				return r.resolveRunInput(ctx, pr, &binding)
+			}
+		}
+		return nil, errors.New("no binding for input pipe " + from.Name)
+	}
+	if sp := findSubPipeline(pr, from.StepId); sp != nil {
+		if isBatched(sp) {
+			return nil, errors.New("pipes from batched sub-pipeline " + sp.Id + " are not supported")
+		}
+		child, err := r.GetChildPipelineRun(ctx, pr, sp)
+		if err != nil {
+			return nil, err
+		}
+		if child == nil {
+			return nil, errors.New("child run of sub-pipeline " + sp.Id + " not found")
+		}
+		if child.Namespace != pr.Namespace {
+			return nil, errors.New("pipes from sub-pipeline " + sp.Id + " in namespace " + child.Namespace + " are not supported")
+		}
+		for _, binding := range child.Status.OutputPipes {
+			if binding.Name == from.Name {
+				return binding.DeepCopy(), nil
+			}
+		}
+		return nil, errors.New("sub-pipeline " + sp.Id + " has no output pipe " + from.Name)
+	}
+	return r.artifactStore(pr).OutputBinding(pr, from.StepId, from.Name), nil
+}
+
+// the bindings of the pipes that end at the reserved output step
+func (r *PipelineRunReconciler) outputPipes(ctx context.Context, pr *pipelinev1.PipelineRun) ([]pipelinev1.PipeBinding, error) {
+	var res []pipelinev1.PipeBinding
+	for _, pipe := range pr.Status.PipelineStructure.Pipes {
+		if (pipe.To.StepId == pipelinev1.OutputStepId) && !isSkipped(pr, pipe.From.StepId) {
+			binding, err := r.resolvePipeSource(ctx, pr, pipe.From)
+			if err != nil {
+				return nil, err
+			}
+			binding.Name = pipe.To.Name
+			res = append(res, *binding)
+		}
+	}
+	return res, nil
+}
+
+/*
+fail the running sub-pipeline steps whose child run has ended in an error state, such a child run is not reconciled
+again and would never report its result to the parent (batched sub-pipelines count their errored child runs as failed)
+*/
+func (r *PipelineRunReconciler) failErroredSubPipelines(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun) (*ctrl.Result, error) {
+	for _, sp := range pr.Status.PipelineStructure.SubPipelines {
+		if isBatched(sp) || !meta.IsStatusConditionPresentAndEqual(pr.Status.Conditions, StepStatus(sp.Id), metav1.ConditionUnknown) {
+			continue
+		}
+		child, err := r.GetChildPipelineRun(ctx, pr, sp)
+		if err != nil {
+			result := r.failed(ctx, "Failed to get child run of sub-pipeline "+sp.Id, err, pr, r.Recorder)
+			return &result, err
+		}
+		if (child == nil) || !hasErrorState(child) {
+			continue
+		}
+		message := "Sub-pipeline has terminated with result: " + *child.Status.State
+		if err := r.SetPipelineRunStatus(ctx, log, pr, StepStatus(sp.Id), metav1.ConditionFalse, message); err != nil {
+			result := r.failed(ctx, "Failed to update PipelineRunStatus for sub-pipeline "+sp.Id, err, pr, r.Recorder)
+			return &result, err
+		}
+		r.Recorder.Event(pr, "Warning", "PipelineExecution", "Step "+sp.Id+" failed: "+message)
+		// changes made: end reconciliation iteration
+		return &ctrl.Result{}, nil
+	}
+	return nil, nil
+}
+
+// feed the terminal state of a child run back into the success condition of the corresponding step of the parent run
+func (r *PipelineRunReconciler) updateParentRun(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, status metav1.ConditionStatus, message string) error {
+	parentName := parentRunName(pr)
+	if parentName == nil {
+		return nil
+	}
+	labelKeys := createdLabelKeys(pr)
+	if _, batched := pr.Labels[labelKeys.BatchIndex]; batched {
+		// the parent aggregates the results of all batches itself
+		return nil
+	}
+	parentStepLabel := labelKeys.ParentStep
+	stepId, found := pr.Labels[parentStepLabel]
+	if !found {
+		return errors.New("child run has no label " + parentStepLabel)
+	}
+	parent, err := r.GetPipelineRun(ctx, *parentName)
+	if err != nil {
+		return err
+	}
+	if parent == nil {
+		log("Parent run not found, it may have been deleted", "PipelineRun.Namespace", parentName.Namespace, "PipelineRun.Name", parentName.Name)
+		return nil
+	}
+	if isTrue(parent, Terminated) || isCancelled(parent, stepId) {
+		// the step has been cancelled by the parent, the result of the child run does not matter any more
+		return nil
+	}
+	return r.SetPipelineRunStatus(ctx, log, parent, StepStatus(stepId), status, message)
+}
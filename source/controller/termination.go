@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"context"
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"strconv"
+	"strings"
+)
+
+const (
+	// status flag
+	TerminationJobsStarted string = "TerminationJobsStarted"
+
+	// prefix of the step id of termination jobs
+	TERMINATION_STEP_PREFIX = "termination-"
+)
+
+// check if the run has reached a terminal state and no more steps are active
+func isTerminal(pr *pipelinev1.PipelineRun) bool {
+	if isTrue(pr, Terminated) {
+		return true
+	}
+	if (pr.Status.State == nil) || ((*pr.Status.State != Succeeded) && (*pr.Status.State != Failed)) {
+		return false
+	}
+	for _, stepId := range allStepIds(pr.Status.PipelineStructure) {
+		if meta.IsStatusConditionPresentAndEqual(pr.Status.Conditions, StepStatus(stepId), v1.ConditionUnknown) {
+			return false
+		}
+	}
+	return true
+}
+
+// ids of the steps that have failed
+func failedSteps(pr *pipelinev1.PipelineRun) []string {
+	var res []string
+	for _, stepId := range allStepIds(pr.Status.PipelineStructure) {
+		if hasFailed(pr, stepId) {
+			res = append(res, stepId)
+		}
+	}
+	return res
+}
+
+func (r *PipelineRunReconciler) startTerminationJobs(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun) (*ctrl.Result, error) {
+	if !isTerminal(pr) || isTrue(pr, TerminationJobsStarted) {
+		// return nil result to indicate that reconciliation can proceed
+		return nil, nil
+	}
+	pd, err := GetPipelineDefinition(r, ctx, types.NamespacedName{Name: getPipelineId(*pr), Namespace: pr.Namespace})
+	if err != nil {
+		result := r.failed(ctx, "Failed to load pipeline definition", err, pr, r.Recorder)
+		return &result, err
+	}
+	var jobs []pipelinev1.JobSpec
+	if pd != nil {
+		jobs = pd.Spec.TerminationJobs
+	}
+	pr.Status.TerminationJobs = nil
+	for i := range jobs {
+		jobName := r.ConstructPipelineJobName(pr, TERMINATION_STEP_PREFIX+strconv.Itoa(i))
+		js, reason, err := r.jobSpecWithDefaults(ctx, pr.Namespace, &jobs[i])
+		if err != nil {
+			result := r.failed(ctx, "Failed to get job defaults of namespace "+pr.Namespace, err, pr, r.Recorder)
+			return &result, err
+		}
+		state := "Created"
+		if len(reason) > 0 {
+			// the pods of the termination job would be rejected, it is not started
+			state = "Invalid (" + reason + ")"
+			r.Recorder.Event(pr, "Warning", "PipelineExecution", "Invalid termination job "+strconv.Itoa(i)+": "+reason)
+		} else if err := r.CreateTerminationPipelineJob(ctx, log, pr, jobName, TERMINATION_STEP_PREFIX+strconv.Itoa(i), js); err != nil {
+			result := r.failed(ctx, "Failed to create termination PipelineJob", err, pr, r.Recorder)
+			return &result, err
+		}
+		pr.Status.TerminationJobs = append(pr.Status.TerminationJobs, pipelinev1.TerminationJobStatus{Name: jobName, State: &state})
+	}
+	message := "Started " + strconv.Itoa(len(jobs)) + " termination jobs"
+	if err := r.SetPipelineRunStatus(ctx, log, pr, TerminationJobsStarted, v1.ConditionTrue, message); err != nil {
+		result := r.failed(ctx, "Failed to set PipelineRun status", err, pr, r.Recorder)
+		return &result, err
+	}
+	r.Recorder.Event(pr, "Normal", "PipelineExecution", message)
+	// changes to state have been made, return empty result to stop current reconciliation iteration
+	return &ctrl.Result{}, nil
+}
+
+/*
+create PipelineJob for a termination job, the outcome of the run is passed as environment variables
+*/
+func (r *PipelineRunReconciler) CreateTerminationPipelineJob(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, jobName string, stepId string, js *pipelinev1.JobSpec) error {
+	state := ""
+	if isTrue(pr, Terminated) {
+		state = Terminated
+	} else if pr.Status.State != nil {
+		state = *pr.Status.State
+	}
+	env := []corev1.EnvVar{
+		{Name: "PIPELINE_RUN", Value: pr.Name},
+		{Name: "PIPELINE_NAME", Value: pr.Spec.PipelineName},
+		{Name: "PIPELINE_VERSION", Value: *pr.Status.PipelineVersion},
+		{Name: "PIPELINE_RUN_STATE", Value: state},
+		{Name: "PIPELINE_RUN_FAILED_STEPS", Value: strings.Join(failedSteps(pr), ",")},
+	}
+
+	// the labels to be attached to job
+	jobLabels := currentConfig().resourceLabels("PipelineSchedule", stepId)
+	// define the job object
+	pj := &pipelinev1.PipelineJob{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      jobName,
+			Namespace: pr.Namespace,
+			Labels:    jobLabels,
+		},
+		Spec: pipelinev1.PipelineJobSpec{
+			Id:                 jobName,
+			JobSpec:            js.DeepCopy(),
+			PipelineRun:        pr.Name,
+			PipelineDefinition: getPipelineId(*pr),
+			StepId:             stepId,
+			Env:                env,
+			TerminationJob:     true,
+		},
+	}
+	// Set the ownerRef for the PipelineJob
+	if err := ctrl.SetControllerReference(pr, pj, r.Scheme); err != nil {
+		return err
+	}
+
+	log("Creating a new termination PipelineJob", "PipelineJob.Namespace", pj.Namespace, "PipelineJob.Name", pj.Name)
+	return CreateOrUpdate(r, r, ctx, log, pj, &pipelinev1.PipelineJob{})
+}
+
+// Sets the state of a termination job in the status of the pipeline run (from PipelineJob reconciliation)
+func (r *PipelineJobReconciler) SetTerminationJobState(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, jobName string, state string) error {
+	for i := range pr.Status.TerminationJobs {
+		tj := &pr.Status.TerminationJobs[i]
+		if tj.Name == jobName {
+			if (tj.State != nil) && (*tj.State == state) {
+				// no change in state
+				return nil
+			}
+			log("Updating state of termination job " + jobName + " to " + state)
+			tj.State = &state
+			return r.Status().Update(ctx, pr)
+		}
+	}
+	log("Termination job " + jobName + " not registered in PipelineRun")
+	return nil
+}
@@ -0,0 +1,150 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	/*
+		reason of the success condition of steps that were not executed, either since their when clause evaluated to
+		false or since an input step was skipped and the step has no when clause (skipped steps count as non-failing)
+	*/
+	SkippedReason = "Skipped"
+)
+
+func isSkipped(pr *pipelinev1.PipelineRun, stepId string) bool {
+	condition := meta.FindStatusCondition(pr.Status.Conditions, StepStatus(stepId))
+	return (condition != nil) && (condition.Status == v1.ConditionTrue) && (condition.Reason == SkippedReason)
+}
+
+// the state of a step as seen by when clauses
+func stepState(pr *pipelinev1.PipelineRun, stepId string) string {
+	switch {
+	case isSkipped(pr, stepId):
+		return SkippedReason
+	case hasSucceeded(pr, stepId):
+		return Succeeded
+	case hasFailed(pr, stepId):
+		return Failed
+	case isActive(pr, stepId):
+		return "Running"
+	default:
+		return "Pending"
+	}
+}
+
+// the when clause of a job step or sub-pipeline (empty if not set)
+func stepWhen(pr *pipelinev1.PipelineRun, stepId string) string {
+	if step := findJobStep(pr, stepId); step != nil {
+		return step.When
+	}
+	if sp := findSubPipeline(pr, stepId); sp != nil {
+		return sp.When
+	}
+	return ""
+}
+
+// the input steps of a step that were skipped
+func skippedInputs(pr *pipelinev1.PipelineRun, stepId string) []string {
+	var res []string
+	for _, pipe := range pr.Status.PipelineStructure.Pipes {
+		if (pipe.To.StepId == stepId) && isSkipped(pr, pipe.From.StepId) {
+			res = append(res, pipe.From.StepId)
+		}
+	}
+	return res
+}
+
+// the variables available to when clauses (see pipelinev1.WhenEnv)
+func whenVariables(pr *pipelinev1.PipelineRun) map[string]interface{} {
+	steps := map[string]interface{}{}
+	for _, stepId := range allStepIds(pr.Status.PipelineStructure) {
+		steps[stepId] = map[string]interface{}{
+			"state":    stepState(pr, stepId),
+			"executed": (hasSucceeded(pr, stepId) || hasFailed(pr, stepId)) && !isSkipped(pr, stepId),
+			"outputs":  decodedStepOutputs(pr, stepId),
+		}
+	}
+	params := map[string]string{}
+	for key, value := range pr.Spec.Parameters {
+		params[key] = value
+	}
+	return map[string]interface{}{
+		"steps":  steps,
+		"params": params,
+	}
+}
+
+// evaluate a when clause against the current state of the run
+func evaluateWhen(pr *pipelinev1.PipelineRun, when string) (bool, error) {
+	program, err := pipelinev1.CompileWhen(when)
+	if err != nil {
+		return false, err
+	}
+	value, _, err := program.Eval(whenVariables(pr))
+	if err != nil {
+		return false, err
+	}
+	res, ok := value.Value().(bool)
+	if !ok {
+		return false, errors.New("when clause did not evaluate to bool")
+	}
+	return res, nil
+}
+
+/*
+determine whether a startable step is skipped, returns the reason as message (empty if the step is to be executed). A
+step with when clause is decided by its when clause alone (inputs from skipped steps are missing then), a step without
+when clause is skipped if one of its input steps was skipped. An error is returned if the when clause can not be
+evaluated.
+*/
+func skipReason(pr *pipelinev1.PipelineRun, stepId string) (string, error) {
+	when := stepWhen(pr, stepId)
+	if len(when) == 0 {
+		if skipped := skippedInputs(pr, stepId); len(skipped) > 0 {
+			return fmt.Sprintf("Skipped since input step %s was skipped", skipped[0]), nil
+		}
+		return "", nil
+	}
+	run, err := evaluateWhen(pr, when)
+	if err != nil || run {
+		return "", err
+	}
+	return "Skipped since when clause evaluated to false: " + when, nil
+}
+
+/*
+mark a startable step as skipped if its when clause evaluates to false or (without when clause) an input step was
+skipped (the step fails if its when clause can not be evaluated), returns true if the step must not be started
+*/
+func (r *PipelineRunReconciler) skipStep(ctx context.Context, log func(string, ...interface{}), pr *pipelinev1.PipelineRun, stepId string) (bool, error) {
+	message, err := skipReason(pr, stepId)
+	if err != nil {
+		message = "Invalid when clause: " + err.Error()
+		if err := r.SetPipelineRunStatus(ctx, log, pr, StepStatus(stepId), v1.ConditionFalse, message); err != nil {
+			return true, err
+		}
+		r.Recorder.Event(pr, "Warning", "PipelineExecution", "Step "+stepId+" failed: "+message)
+		return true, nil
+	}
+	if len(message) == 0 {
+		return false, nil
+	}
+	log(message + " (step " + stepId + ")")
+	meta.SetStatusCondition(&pr.Status.Conditions, v1.Condition{
+		Type:    StepStatus(stepId),
+		Status:  v1.ConditionTrue,
+		Reason:  SkippedReason,
+		Message: message,
+	})
+	if err := r.Status().Update(ctx, pr); err != nil {
+		return true, err
+	}
+	r.Recorder.Event(pr, "Normal", "PipelineExecution", "Step "+stepId+": "+message)
+	return true, nil
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	pipelinev1 "github.com/k-pipe/pipeline-operator/api/v1"
+)
+
+var _ = Describe("Conditional steps", func() {
+	var pr *pipelinev1.PipelineRun
+	setStatus := func(stepId string, status metav1.ConditionStatus, reason string) {
+		meta.SetStatusCondition(&pr.Status.Conditions, metav1.Condition{Type: StepStatus(stepId), Status: status, Reason: reason})
+	}
+	pipe := func(from string, to string) *pipelinev1.PipelinePipe {
+		return &pipelinev1.PipelinePipe{From: pipelinev1.PipeConnector{StepId: from, Name: "x"}, To: pipelinev1.PipeConnector{StepId: to, Name: from}}
+	}
+
+	BeforeEach(func() {
+		// detect -> retrain -> deploy
+		pr = &pipelinev1.PipelineRun{
+			Spec: pipelinev1.PipelineRunSpec{
+				Parameters: map[string]string{"mode": "full"},
+			},
+			Status: pipelinev1.PipelineRunStatus{
+				PipelineStructure: &pipelinev1.PipelineStructure{
+					JobSteps: []*pipelinev1.PipelineJobStepSpec{
+						{Id: "detect"},
+						{Id: "retrain", When: "steps.detect.state == 'Succeeded' && params.mode == 'full'"},
+						{Id: "deploy"},
+					},
+					Pipes: []*pipelinev1.PipelinePipe{pipe("detect", "retrain"), pipe("retrain", "deploy")},
+				},
+			},
+		}
+		setStatus("detect", metav1.ConditionTrue, "Reconciling")
+	})
+
+	It("should report the states of the steps", func() {
+		Expect(stepState(pr, "detect")).To(Equal(Succeeded))
+		Expect(stepState(pr, "deploy")).To(Equal("Pending"))
+		setStatus("deploy", metav1.ConditionUnknown, "Reconciling")
+		Expect(stepState(pr, "deploy")).To(Equal("Running"))
+		setStatus("deploy", metav1.ConditionFalse, "Reconciling")
+		Expect(stepState(pr, "deploy")).To(Equal(Failed))
+		setStatus("deploy", metav1.ConditionTrue, SkippedReason)
+		Expect(stepState(pr, "deploy")).To(Equal(SkippedReason))
+	})
+
+	It("should run steps whose when clause evaluates to true", func() {
+		Expect(skipReason(pr, "retrain")).To(BeEmpty())
+		Expect(skipReason(pr, "detect")).To(BeEmpty())
+	})
+
+	It("should skip steps whose when clause evaluates to false", func() {
+		pr.Spec.Parameters["mode"] = "dry-run"
+		Expect(skipReason(pr, "retrain")).To(ContainSubstring("when clause evaluated to false"))
+	})
+
+	It("should skip consumers of skipped steps", func() {
+		setStatus("retrain", metav1.ConditionTrue, SkippedReason)
+		Expect(skipReason(pr, "deploy")).To(ContainSubstring("input step retrain was skipped"))
+	})
+
+	It("should decide by the when clause of consumers of skipped steps", func() {
+		setStatus("retrain", metav1.ConditionTrue, SkippedReason)
+		findJobStep(pr, "deploy").When = "!steps.retrain.executed"
+		Expect(skipReason(pr, "deploy")).To(BeEmpty())
+		Expect(whenVariables(pr)["steps"]).To(HaveKeyWithValue("detect", HaveKeyWithValue("executed", true)))
+		setStatus("retrain", metav1.ConditionTrue, "Reconciling")
+		Expect(skipReason(pr, "deploy")).To(ContainSubstring("when clause evaluated to false"))
+	})
+
+	It("should treat skipped steps as non-failing", func() {
+		setStatus("retrain", metav1.ConditionTrue, SkippedReason)
+		Expect(isSkipped(pr, "retrain")).To(BeTrue())
+		Expect(hasSucceeded(pr, "retrain")).To(BeTrue())
+		Expect(hasFailed(pr, "retrain")).To(BeFalse())
+		Expect(allInputsSucceeded(pr, "deploy")).To(BeTrue())
+	})
+
+	It("should fail on when clauses that can not be evaluated", func() {
+		findJobStep(pr, "retrain").When = "steps.unknown.state == 'Succeeded'"
+		_, err := skipReason(pr, "retrain")
+		Expect(err).To(HaveOccurred())
+		delete(pr.Spec.Parameters, "mode")
+		findJobStep(pr, "retrain").When = "params.mode == 'full'"
+		_, err = skipReason(pr, "retrain")
+		Expect(err).To(HaveOccurred())
+	})
+})